@@ -27,10 +27,10 @@ func TestFuture(t *testing.T) {
 
 func TestFutureReady(t *testing.T) {
 	f := New(func(*Future) error { time.Sleep(time.Second); return nil }).Go()
-	require.False(t, f.IsReady())
+	require.False(t, f.IsDone())
 	err := f.Wait()
 	require.NoError(t, err)
-	require.True(t, f.IsReady())
+	require.True(t, f.IsDone())
 }
 
 func TestFutureTimedWait(t *testing.T) {
@@ -0,0 +1,59 @@
+package future
+
+import (
+	"context"
+	"sync"
+)
+
+// Value is the typed value resolved once by its producer and waited for by
+// many consumers, the generic future of T. It is not named Future[T], since
+// Future is the chain of functions of this package
+type Value[T any] struct {
+	once sync.Once
+	c    chan struct{}
+	v    T
+	err  error
+}
+
+// NewValue creates the unresolved value
+func NewValue[T any]() *Value[T] {
+	return &Value[T]{c: make(chan struct{})}
+}
+
+// Resolve sets the value or the error and wakes the waiters. It returns
+// false if the value is already resolved, the first resolution is kept
+func (f *Value[T]) Resolve(v T, err error) bool {
+	ok := false
+	f.once.Do(func() {
+		f.v, f.err = v, err
+		close(f.c)
+		ok = true
+	})
+	return ok
+}
+
+// Get waits for the resolution or the cancellation of ctx
+func (f *Value[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.c:
+		return f.v, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Done returns the channel closed once the value is resolved
+func (f *Value[T]) Done() <-chan struct{} {
+	return f.c
+}
+
+// IsDone returns true if the value is resolved
+func (f *Value[T]) IsDone() bool {
+	select {
+	case <-f.c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValue(t *testing.T) {
+	f := NewValue[int]()
+	require.False(t, f.IsDone())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := f.Get(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	got := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			v, _ := f.Get(context.Background())
+			got <- v
+		}()
+	}
+	require.True(t, f.Resolve(42, nil))
+	require.False(t, f.Resolve(1, errors.New("late")))
+	for i := 0; i < 4; i++ {
+		require.Equal(t, 42, <-got)
+	}
+	<-f.Done()
+	require.True(t, f.IsDone())
+}
@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AckReader is the read end whose reads are tentative until they are
+// acknowledged: the data stays in the pipe until Ack, and Nack delivers it
+// again. The data of the reader that failed mid-message is never lost
+type AckReader struct {
+	r *Reader
+
+	mu          sync.Mutex
+	read        int // bytes read past the acknowledged position
+	delivered   time.Time
+	timeout     time.Duration
+	redelivered int64
+}
+
+// AckPipe creates the pipe with buffer of at least max bytes and the
+// acknowledged read end. The unacknowledged data takes the buffer space, so
+// the reader must acknowledge the data before reading max bytes ahead
+func AckPipe(max int) (*AckReader, *Writer) {
+	r, w := pipe(max, false, false)
+	return &AckReader{r: r}, w
+}
+
+func (a *AckReader) Read(data []byte) (int, error) {
+	return a.ReadContext(context.Background(), data)
+}
+
+// ReadContext reads the data after the data read before, it must not be
+// called concurrently. The data read but not acknowledged for the ack
+// timeout is delivered again, e.g. after the reader has crashed, the
+// blocked read gets it once the timeout is over
+func (a *AckReader) ReadContext(ctx context.Context, data []byte) (int, error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		a.mu.Lock()
+		var expire <-chan time.Time
+		if a.read > 0 && a.timeout > 0 {
+			left := a.timeout - time.Since(a.delivered)
+			if left < 0 {
+				a.read = 0
+				a.redelivered++
+			} else {
+				if timer == nil {
+					timer = time.NewTimer(left)
+				} else {
+					timer.Stop()
+					select {
+					case <-timer.C:
+					default:
+					}
+					timer.Reset(left)
+				}
+				expire = timer.C
+			}
+		}
+		read := a.read
+		a.mu.Unlock()
+		n, err := a.r.readAhead(ctx, read, data, expire)
+		a.mu.Lock()
+		if a.read != read || (n == 0 && err == nil && len(data) > 0) {
+			// nacked or acked while reading, or woken to check the
+			// timeout, read at the new position
+			a.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if a.read == 0 {
+			a.delivered = time.Now()
+		}
+		a.read += n
+		a.mu.Unlock()
+		return n, err
+	}
+}
+
+// Ack acknowledges first n bytes of the data read but not acknowledged and
+// frees their space. It may be called by any goroutine
+func (a *AckReader) Ack(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n > a.read {
+		panic("ack exceeds read data")
+	}
+	if n > 0 {
+		a.r.advance(n)
+		a.read -= n
+		a.delivered = time.Now()
+		notify(a.r.wsig) // the blocked read continues at the new position
+	}
+}
+
+// Nack makes the data read but not acknowledged to be read again. It may
+// be called by any goroutine, e.g. by the supervisor of the failed reader
+func (a *AckReader) Nack() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.read > 0 {
+		a.read = 0
+		a.redelivered++
+		notify(a.r.wsig) // the blocked read gets the data again
+	}
+}
+
+// SetAckTimeout sets the time after the delivery or the last Ack in which
+// the read data must be acknowledged, zero means no timeout
+func (a *AckReader) SetAckTimeout(d time.Duration) {
+	a.mu.Lock()
+	a.timeout = d
+	a.mu.Unlock()
+}
+
+// Unacked returns number of bytes read but not acknowledged
+func (a *AckReader) Unacked() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.read
+}
+
+// Redelivered returns number of times the data was delivered again
+func (a *AckReader) Redelivered() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.redelivered
+}
+
+// Close closes the read end, the unacknowledged data is discarded
+func (a *AckReader) Close() error {
+	return a.r.CloseRead()
+}
+
+// readAhead reads the buffered data skip bytes past the read position
+// without consuming it, or waits for it. It returns zero after the wakeup of
+// the reader or expire, the caller checks its position again
+func (r *Reader) readAhead(ctx context.Context, skip int, data []byte, expire <-chan time.Time) (int, error) {
+	var parked parking
+	defer parked.leave()
+	for {
+		_, closed, head, sz := r.loadHeader()
+		if n := minInt(sz-skip, len(data)); n > 0 {
+			pos := r.wrap(head + skip)
+			if pos > len(r.mem)-n {
+				// wrapped
+				ll := len(r.mem) - pos
+				copy(data[:ll], r.mem[pos:])
+				copy(data[ll:n], r.mem[:n-ll])
+			} else {
+				copy(data, r.mem[pos:pos+n])
+			}
+			return n, nil
+		}
+		if closed {
+			return 0, r.closeErr()
+		}
+		if len(data) == 0 {
+			return 0, nil
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		schedWait(r.wakeWaiters)
+		select {
+		case <-r.wsig:
+		case <-expire:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		return 0, nil
+	}
+}
@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckPipe(t *testing.T) {
+	r, w := AckPipe(16)
+	_, err := w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(buf[:n]))
+	require.Equal(t, 4, r.Unacked())
+	require.Equal(t, 6, w.Free())
+	r.Ack(2)
+	require.Equal(t, 8, w.Free())
+	r.Nack()
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "2345", string(buf[:n]))
+	require.Equal(t, int64(1), r.Redelivered())
+	r.Ack(4)
+	require.Equal(t, 0, r.Unacked())
+
+	// the crashed reader's data is delivered again after the timeout
+	r.SetAckTimeout(10 * time.Millisecond)
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "6789", string(buf[:n]))
+	time.Sleep(20 * time.Millisecond)
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "6789", string(buf[:n]))
+	r.Ack(4)
+	w.Close()
+	_, err = r.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestAckPipeBlockedRead(t *testing.T) {
+	r, w := AckPipe(16)
+	w.Write([]byte("0123"))
+	buf := make([]byte, 4)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	// Nack wakes the read blocked past the data
+	got := make(chan string)
+	go func() {
+		n, _ := r.Read(buf)
+		got <- string(buf[:n])
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Nack()
+	select {
+	case s := <-got:
+		require.Equal(t, "0123", s)
+	case <-time.After(time.Second):
+		t.Fatal("the blocked read missed Nack")
+	}
+
+	// so does the ack timeout
+	r.SetAckTimeout(20 * time.Millisecond)
+	go func() {
+		n, _ := r.Read(buf)
+		got <- string(buf[:n])
+	}()
+	select {
+	case s := <-got:
+		require.Equal(t, "0123", s)
+	case <-time.After(time.Second):
+		t.Fatal("the blocked read missed the ack timeout")
+	}
+	require.Equal(t, int64(2), r.Redelivered())
+}
@@ -0,0 +1,32 @@
+package pipe
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveWait is the longest park the spinning could have saved, the
+// shorter parks grow the budget and the longer ones shrink it
+const adaptiveWait = 20 * time.Microsecond
+
+// adaptive is the spin budget of the end with the adaptive policy, learned
+// from the hand-offs it observes
+type adaptive struct {
+	lock int32 // attempts of the contended lock
+	wait int32 // attempts of the read and write waits
+}
+
+// learn updates the budget after the hand-off that parked at since, zero
+// since means the spinning succeeded. Successful spins and short parks
+// double the budget up to max, long parks halve it down to parking at once
+func (a *adaptive) learn(budget *int32, since time.Time, max int) {
+	n := atomic.LoadInt32(budget)
+	if since.IsZero() || time.Since(since) < adaptiveWait {
+		if n = 2*n + 1; int(n) > max {
+			n = int32(max)
+		}
+	} else {
+		n /= 2
+	}
+	atomic.StoreInt32(budget, n)
+}
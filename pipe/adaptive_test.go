@@ -0,0 +1,61 @@
+package pipe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveSpin(t *testing.T) {
+	var a adaptive
+	for i := 0; i < 10; i++ {
+		a.learn(&a.wait, time.Time{}, 50)
+	}
+	require.EqualValues(t, 50, a.wait)
+	for i := 0; i < 10; i++ {
+		a.learn(&a.wait, time.Now().Add(-time.Millisecond), 50)
+	}
+	require.EqualValues(t, 0, a.wait)
+	a.learn(&a.wait, time.Now(), 50)
+	require.EqualValues(t, 1, a.wait)
+
+	r, w := SyncPipe(64)
+	p := SpinPolicy{Spins: 200, Pause: PauseBusy, Adaptive: true}
+	r.SetSpinPolicy(p)
+	w.SetSpinPolicy(p)
+	require.EqualValues(t, 200, w.adapt.lock)
+
+	// the contended lock learns and the pipe goes on
+	const writers, bytes = 8, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < bytes; j++ {
+				w.WriteByte(1)
+			}
+		}()
+	}
+	buf := make([]byte, writers*bytes)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+	wg.Wait()
+
+	// the long waits make the reader park at once
+	for i := 0; i < 10; i++ {
+		go func() {
+			time.Sleep(time.Millisecond)
+			w.Write([]byte{1})
+		}()
+		_, err = r.Read(buf[:1])
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 0, r.adapt.wait)
+
+	r.SetSpinPolicy(DefaultSpin)
+	require.Nil(t, r.adapt)
+}
@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultArenaRegionSize = 64 << 10
+
+// Arena hands out the scratch buffers of the messages from bump regions,
+// e.g. to encode and decode millions of small messages without garbage. The
+// region is reclaimed once all its buffers are released. Arena may be used
+// concurrently
+type Arena struct {
+	mu         sync.Mutex
+	regionSize int
+	cur        *arenaRegion
+	free       []*arenaRegion // reclaimed regions
+}
+
+type arenaRegion struct {
+	a    *Arena
+	buf  []byte
+	off  int
+	live int // buffers not released
+}
+
+// Scratch is the buffer taken from Arena, it is returned by Release
+type Scratch struct {
+	Data []byte
+	r    *arenaRegion
+}
+
+// NewArena creates the arena of regions of regionSize bytes, non-positive
+// regionSize means 64KB
+func NewArena(regionSize int) *Arena {
+	if regionSize <= 0 {
+		regionSize = defaultArenaRegionSize
+	}
+	return &Arena{regionSize: regionSize}
+}
+
+// Alloc returns the buffer of n bytes. The buffers larger than the region
+// are allocated separately
+func (a *Arena) Alloc(n int) Scratch {
+	if n > a.regionSize {
+		return Scratch{Data: make([]byte, n)}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := a.cur
+	if r == nil || len(r.buf)-r.off < n {
+		if r != nil && r.live == 0 {
+			// nothing to wait for, bump from the start again
+			r.off = 0
+		} else if l := len(a.free); l > 0 {
+			r = a.free[l-1]
+			a.free = a.free[:l-1]
+		} else {
+			r = &arenaRegion{a: a, buf: make([]byte, a.regionSize)}
+		}
+		a.cur = r
+	}
+	data := r.buf[r.off : r.off+n : r.off+n]
+	r.off += n
+	r.live++
+	return Scratch{Data: data, r: r}
+}
+
+// Release returns the buffer to the arena, the buffer must not be used
+// after Release
+func (s Scratch) Release() {
+	r := s.r
+	if r == nil {
+		return
+	}
+	a := r.a
+	a.mu.Lock()
+	if r.live--; r.live == 0 {
+		r.off = 0
+		if r != a.cur {
+			a.free = append(a.free, r)
+		}
+	}
+	a.mu.Unlock()
+}
+
+// ReadScratch waits for the message and reads it into the buffer taken from
+// a, the buffer is released by the caller after decoding the message
+func (m *MessageReader) ReadScratch(ctx context.Context, a *Arena) (Scratch, error) {
+	select {
+	case m.lock <- struct{}{}:
+	case <-ctx.Done():
+		return Scratch{}, ctx.Err()
+	}
+	defer func() { <-m.lock }()
+	size, err := m.next(ctx)
+	if err != nil {
+		return Scratch{}, err
+	}
+	s := a.Alloc(size)
+	if err = m.r.ReadFullWithContext(ctx, s.Data); err != nil {
+		s.Release()
+		return Scratch{}, err
+	}
+	m.size = -1
+	return s, nil
+}
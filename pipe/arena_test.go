@@ -0,0 +1,39 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArena(t *testing.T) {
+	a := NewArena(16)
+	s1 := a.Alloc(10)
+	s2 := a.Alloc(10) // the second region
+	require.Len(t, s1.Data, 10)
+	require.Equal(t, 10, cap(s1.Data))
+	copy(s1.Data, "0123456789")
+	copy(s2.Data, "abcdefghij")
+	require.Equal(t, "0123456789", string(s1.Data))
+	s1.Release()
+	s3 := a.Alloc(10) // the second region is full, the first one is reused
+	require.Equal(t, &s1.Data[0], &s3.Data[0])
+	s2.Release()
+	s3.Release()
+	big := a.Alloc(100)
+	require.Len(t, big.Data, 100)
+	big.Release()
+
+	r, w := MessagePipe(64)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.Close()
+	s, err := r.ReadScratch(context.Background(), a)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(s.Data))
+	s.Release()
+	_, err = r.ReadScratch(context.Background(), a)
+	require.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,115 @@
+package pipe
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// frameBatch collects the frames written by FramedWriter, see SetBatch
+type frameBatch struct {
+	mu       sync.Mutex
+	w        io.Writer
+	size     int
+	interval time.Duration
+	buf      []byte
+	timer    *time.Timer
+	armed    bool
+	err      error // error of the timed flush, reported once
+}
+
+// SetBatch makes the writer collect the frames and write them at once when
+// size bytes are collected, or interval after the first frame of the batch
+// was collected, whatever comes first. Flush writes the batch before that,
+// Close writes it and closes the underlying writer. Non-positive interval
+// leaves the batch to the size, Flush and Close, non-positive size writes
+// each frame at once as without SetBatch. The error of the timed write is
+// returned once by the next write or Flush, the frames of the failed write
+// are dropped. It must not be called concurrently with writes
+func (f *FramedWriter) SetBatch(size int, interval time.Duration) error {
+	err := f.Flush()
+	f.batch = nil
+	if size > 0 {
+		f.batch = &frameBatch{w: f.w, size: size, interval: interval}
+	}
+	return err
+}
+
+// Flush writes the collected frames
+func (f *FramedWriter) Flush() error {
+	if f.batch == nil {
+		return nil
+	}
+	b := f.batch
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.takeErr(); err != nil {
+		return err
+	}
+	return b.flush()
+}
+
+// Close writes the collected frames and closes the underlying writer if it's
+// io.Closer, even if the write fails. The first error is returned
+func (f *FramedWriter) Close() error {
+	err := f.Flush()
+	if cl, ok := f.w.(io.Closer); ok {
+		if cerr := cl.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// takeErr returns the error of the timed flush and forgets it
+func (b *frameBatch) takeErr() error {
+	err := b.err
+	b.err = nil
+	return err
+}
+
+// add collects the frame made of bufs
+func (b *frameBatch) add(bufs [][]byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.takeErr(); err != nil {
+		return err
+	}
+	for _, buf := range bufs {
+		b.buf = append(b.buf, buf...)
+	}
+	if len(b.buf) >= b.size {
+		return b.flush()
+	}
+	if b.interval > 0 && !b.armed {
+		b.armed = true
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, b.timed)
+		} else {
+			b.timer.Reset(b.interval)
+		}
+	}
+	return nil
+}
+
+// timed writes the batch when its interval is over
+func (b *frameBatch) timed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.armed && b.err == nil {
+		b.err = b.flush()
+	}
+}
+
+func (b *frameBatch) flush() error {
+	if b.armed {
+		b.armed = false
+		b.timer.Stop()
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+	_, err := b.w.Write(b.buf)
+	b.buf = b.buf[:0]
+	return err
+}
@@ -0,0 +1,115 @@
+package pipe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameBatch(t *testing.T) {
+	r, w := Pipe(256)
+	fw := NewFramedWriter(w, 0)
+	require.NoError(t, fw.SetBatch(32, 20*time.Millisecond))
+	require.NoError(t, fw.WriteFrame([]byte("a")))
+	require.NoError(t, fw.WriteFrame([]byte("b")))
+	require.Equal(t, 0, r.Buffered())
+	// the partial batch is written after the interval
+	start := time.Now()
+	fr := NewFramedReader(r, 0)
+	frame, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(frame))
+	require.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+	frame, err = fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "b", string(frame))
+
+	// the full batch is written at once
+	require.NoError(t, fw.WriteFrame(make([]byte, 40)))
+	require.Equal(t, 44, r.Buffered())
+	fr.ReadFrame()
+	// and flushed
+	require.NoError(t, fw.WriteFrame([]byte("c")))
+	require.NoError(t, fw.Flush())
+	require.Equal(t, 5, r.Buffered())
+	fr.ReadFrame()
+
+	// the codecs flush on close
+	r, w = Pipe(256)
+	enc := NewEncoderPipe(w)
+	require.NoError(t, enc.SetBatch(1024, 0))
+	require.NoError(t, enc.Encode("hello"))
+	require.Equal(t, 0, r.Buffered())
+	require.NoError(t, enc.Close())
+	var s string
+	require.NoError(t, NewDecoderPipe(r).Decode(&s))
+	require.Equal(t, "hello", s)
+}
+
+// closeRecorder is failWriter that records its close
+type closeRecorder struct {
+	failWriter
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFrameBatchClose(t *testing.T) {
+	// the frames batched without the interval are written by Close
+	r, w := Pipe(256)
+	fw := NewFramedWriter(w, 0)
+	require.NoError(t, fw.SetBatch(1024, 0))
+	require.NoError(t, fw.WriteFrame([]byte("a")))
+	require.Equal(t, 0, r.Buffered())
+	require.NoError(t, fw.Close())
+	fr := NewFramedReader(r, 0)
+	frame, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(frame))
+	_, err = fr.ReadFrame()
+	require.Equal(t, io.EOF, err)
+
+	// the error of the timed flush is reported once, then the writer is
+	// reused
+	dst := &closeRecorder{}
+	fw = NewFramedWriter(dst, 0)
+	require.NoError(t, fw.SetBatch(1024, time.Millisecond))
+	require.NoError(t, fw.WriteFrame([]byte("lost")))
+	time.Sleep(20 * time.Millisecond)
+	require.Error(t, fw.Flush())
+	dst.limit = 1000
+	require.NoError(t, fw.WriteFrame([]byte("b")))
+	require.NoError(t, fw.Flush())
+	require.Equal(t, 5, dst.Len())
+
+	// the failed flush still closes the underlying writers
+	block, err := aes.NewCipher(make([]byte, 16))
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	for _, c := range []io.WriteCloser{NewFramedWriter(dst, 0), Compress(dst, Gzip), Seal(dst, aead)} {
+		dst.Reset()
+		dst.limit, dst.closed = 0, false
+		c.(interface {
+			SetBatch(int, time.Duration) error
+		}).SetBatch(1024, 0)
+		c.Write([]byte("c"))
+		require.Error(t, c.Close())
+		require.True(t, dst.closed)
+	}
+
+	// and the encoder pipe reports the failure of the batched values
+	r, w = Pipe(256)
+	enc := NewEncoderPipe(w)
+	require.NoError(t, enc.SetBatch(1024, 0))
+	require.NoError(t, enc.Encode("hello"))
+	r.CloseRead()
+	require.Error(t, enc.Close())
+}
@@ -0,0 +1,215 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Duplex is the bidirectional conversation over two pipes: Write sends the
+// data to the peer, Read reads the data received from it
+type Duplex struct {
+	r *Reader // received data
+	w *Writer // data to send
+
+	c    io.Closer // the bridged connection, nil in memory
+	sent chan struct{}
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error // the first error of the connection
+}
+
+// Bridge pumps the connection through the pipes of bufSize bytes in both
+// directions. The close of the write end half-closes the connection once
+// the data is sent, if the connection supports it by CloseWrite. The end
+// of the received data closes the read end with the error of the
+// connection, io.EOF if none. The connection is closed when both
+// directions end or by Close. ErrPeerLost of Keepalive ends both
+// directions. The errors of the connection are returned by Wait
+func Bridge(c io.ReadWriteCloser, bufSize int) *Duplex {
+	d, rw, sr := newDuplex(bufSize, c)
+	d.run(func() {
+		_, err := rw.ReadFrom(c)
+		d.endRecv(rw, err)
+		if err == ErrPeerLost {
+			// nothing sent reaches the peer either
+			d.endSend(sr, err)
+		}
+	}, func() {
+		if _, err := sr.WriteTo(c); err != nil {
+			d.endSend(sr, err)
+			return
+		}
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			if err := cw.CloseWrite(); err != nil {
+				d.setErr(err)
+			}
+		}
+	})
+	return d
+}
+
+// newDuplex creates the Duplex over the connection c and returns the ends
+// of its pipes for the pumps: the received data is written to recv, the
+// data to send is read from send
+func newDuplex(bufSize int, c io.Closer) (d *Duplex, recv *Writer, send *Reader) {
+	rr, rw := pipe(bufSize, true, false)
+	sr, sw := pipe(bufSize, false, true)
+	d = &Duplex{r: rr, w: sw, c: c, sent: make(chan struct{}), done: make(chan struct{})}
+	return d, rw, sr
+}
+
+// run starts the pumps of the connection, the connection is closed once
+// both of them return
+func (d *Duplex) run(recv, send func()) {
+	recvd := make(chan struct{})
+	go func() {
+		defer close(recvd)
+		recv()
+	}()
+	go func() {
+		defer close(d.sent)
+		send()
+	}()
+	go func() {
+		<-recvd
+		<-d.sent
+		d.c.Close()
+		close(d.done)
+	}()
+}
+
+// endRecv closes the pipe of the received data after the receive error,
+// nil err means the end of the stream
+func (d *Duplex) endRecv(recv *Writer, err error) {
+	switch {
+	case err == nil:
+		recv.Close()
+	case recv.IsClosed():
+		// the reader is closed
+	default:
+		d.setErr(err)
+		recv.CloseWithError(err)
+	}
+}
+
+// endSend fails the writes after the send error and closes the connection
+func (d *Duplex) endSend(send *Reader, err error) {
+	d.setErr(err)
+	send.CloseRead()
+	d.c.Close()
+}
+
+var _ net.Conn = (*Duplex)(nil)
+
+// DuplexPair creates the in-memory socket pair: the data written to one
+// Duplex is read from the other one through the pipe of bufSize bytes
+func DuplexPair(bufSize int) (*Duplex, *Duplex) {
+	r1, w1 := pipe(bufSize, true, true)
+	r2, w2 := pipe(bufSize, true, true)
+	return &Duplex{r: r1, w: w2}, &Duplex{r: r2, w: w1}
+}
+
+func (d *Duplex) setErr(err error) {
+	d.mu.Lock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.mu.Unlock()
+}
+
+// Reader returns the end to read the received data from
+func (d *Duplex) Reader() *Reader {
+	return d.r
+}
+
+// Writer returns the end to write the data to send into
+func (d *Duplex) Writer() *Writer {
+	return d.w
+}
+
+func (d *Duplex) Read(data []byte) (int, error) {
+	return d.r.Read(data)
+}
+
+func (d *Duplex) ReadContext(ctx context.Context, data []byte) (int, error) {
+	return d.r.ReadContext(ctx, data)
+}
+
+func (d *Duplex) Write(data []byte) (int, error) {
+	return d.w.Write(data)
+}
+
+func (d *Duplex) WriteContext(ctx context.Context, data []byte) (int, error) {
+	return d.w.WriteContext(ctx, data)
+}
+
+// LocalAddr returns the local address of the bridged connection, the stub
+// address of the in-memory one
+func (d *Duplex) LocalAddr() net.Addr {
+	if c, ok := d.c.(interface{ LocalAddr() net.Addr }); ok {
+		return c.LocalAddr()
+	}
+	return pipeAddr(0)
+}
+
+// RemoteAddr returns the remote address of the bridged connection, the
+// stub address of the in-memory one
+func (d *Duplex) RemoteAddr() net.Addr {
+	if c, ok := d.c.(interface{ RemoteAddr() net.Addr }); ok {
+		return c.RemoteAddr()
+	}
+	return pipeAddr(0)
+}
+
+// SetReadDeadline sets the deadline for blocked and future reads
+func (d *Duplex) SetReadDeadline(deadline time.Time) error {
+	return d.r.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline sets the deadline for blocked and future writes, the
+// written data is sent later
+func (d *Duplex) SetWriteDeadline(deadline time.Time) error {
+	return d.w.SetWriteDeadline(deadline)
+}
+
+// SetDeadline sets both deadlines
+func (d *Duplex) SetDeadline(deadline time.Time) error {
+	d.r.SetReadDeadline(deadline)
+	return d.w.SetWriteDeadline(deadline)
+}
+
+// CloseWrite closes the sending direction, the peer gets io.EOF after the
+// data written before
+func (d *Duplex) CloseWrite() error {
+	return d.w.Close()
+}
+
+// Close closes both directions: the peer reads io.EOF after the data
+// written before and its writes fail. The data written before is still
+// sent, then the bridged connection is closed
+func (d *Duplex) Close() error {
+	d.w.Close()
+	d.r.CloseRead()
+	if d.c != nil {
+		go func() {
+			<-d.sent
+			d.c.Close()
+		}()
+	}
+	return nil
+}
+
+// Wait waits until both directions of the bridged connection end and
+// returns the first error of the connection
+func (d *Duplex) Wait() error {
+	if d.done != nil {
+		<-d.done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
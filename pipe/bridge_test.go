@@ -0,0 +1,68 @@
+package pipe
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridge(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		d := Bridge(c, 64)
+		io.Copy(d, d) // echo until the client half-closes
+		d.CloseWrite()
+		d.Wait()
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	d := Bridge(c, 64)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		d.Write(data)
+		d.CloseWrite()
+	}()
+	b, err := io.ReadAll(d)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	require.NoError(t, d.Wait())
+}
+
+func TestDuplexPair(t *testing.T) {
+	a, b := DuplexPair(64)
+	require.Equal(t, "pipe", a.LocalAddr().Network())
+	require.Equal(t, "pipe", b.RemoteAddr().String())
+	_, err := a.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(b, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	_, err = b.Write([]byte("pong"))
+	require.NoError(t, err)
+	a.Close()
+	// the peer reads io.EOF and its writes fail
+	_, err = io.ReadFull(b, buf)
+	require.Equal(t, io.EOF, err)
+	_, err = b.Write([]byte("x"))
+	require.Equal(t, ErrReadClosed, err)
+	require.NoError(t, b.Close())
+	require.NoError(t, a.Wait())
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	d := Bridge(c1, 64)
+	require.Equal(t, c1.LocalAddr(), d.LocalAddr())
+	d.Close()
+}
@@ -0,0 +1,285 @@
+package pipe
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrDropped = errors.New("Subscriber dropped")
+
+// OverrunPolicy tells the broadcast writer what to do when the slowest
+// subscriber would be overrun
+type OverrunPolicy int
+
+const (
+	// BlockWriter makes the writer wait for the slowest subscriber
+	BlockWriter OverrunPolicy = iota
+	// DropSubscriber unsubscribes the slow subscriber, its reads fail with ErrDropped
+	DropSubscriber
+	// SkipData moves the slow subscriber forward, it loses the oldest data
+	SkipData
+)
+
+// droppedPos is the read position of the dropped or closed subscriber
+const droppedPos = ^uint64(0)
+
+// Broadcast is the pipe with one writer and many subscribers, each of them
+// reads the full stream written after it subscribed with its own cursor over
+// the shared ring
+type Broadcast struct {
+	mem    []byte
+	mask   int
+	wpos   uint64
+	closed uint32
+	policy OverrunPolicy
+	rsig   chan struct{} // subscriber moved its cursor
+
+	mu   sync.Mutex   // serializes changes of subs
+	subs atomic.Value // []*Subscriber
+
+	// ow is held by the writer overrunning the subscribers and shared by
+	// the copies of the subscribers, unused under BlockWriter
+	ow sync.RWMutex
+}
+
+// Subscriber is the read end of the broadcast
+type Subscriber struct {
+	b       *Broadcast
+	rpos    uint64
+	skipped uint64
+	sig     chan struct{}
+}
+
+// NewBroadcast creates the broadcast with buffer of at least max bytes
+func NewBroadcast(max int, policy OverrunPolicy) *Broadcast {
+	var rb ringbuf
+	rb.init(max, false)
+	b := &Broadcast{mem: rb.mem, mask: rb.mask, policy: policy, rsig: make(chan struct{}, 1)}
+	b.subs.Store([]*Subscriber(nil))
+	return b
+}
+
+// Subscribe adds the subscriber that receives the data written from now on
+func (b *Broadcast) Subscribe() *Subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &Subscriber{b: b, rpos: atomic.LoadUint64(&b.wpos), sig: make(chan struct{}, 1)}
+	subs := b.subs.Load().([]*Subscriber)
+	b.subs.Store(append(subs[:len(subs):len(subs)], s))
+	return s
+}
+
+// Clone adds the subscriber that starts at the position of s, so it reads
+// the data s hasn't read yet and the rest of the stream, e.g. the tap
+// joining the live stream. The data is retained for the clone as for
+// other subscribers. It must not be called concurrently with the reads
+// of s. The clone of the dropped or closed subscriber is dropped
+func (s *Subscriber) Clone() *Subscriber {
+	b := s.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rpos := atomic.LoadUint64(&s.rpos)
+	c := &Subscriber{b: b, rpos: rpos, sig: make(chan struct{}, 1)}
+	if rpos == droppedPos {
+		return c
+	}
+	subs := b.subs.Load().([]*Subscriber)
+	b.subs.Store(append(subs[:len(subs):len(subs)], c))
+	// the writer may have moved s before it saw the clone
+	if moved := atomic.LoadUint64(&s.rpos); moved != rpos {
+		if moved == droppedPos {
+			atomic.StoreUint64(&c.rpos, droppedPos)
+		} else if atomic.CompareAndSwapUint64(&c.rpos, rpos, moved) {
+			atomic.AddUint64(&c.skipped, moved-rpos)
+		}
+	}
+	return c
+}
+
+func (b *Broadcast) unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs.Load().([]*Subscriber)
+	for i, ss := range subs {
+		if ss == s {
+			nsubs := make([]*Subscriber, 0, len(subs)-1)
+			b.subs.Store(append(append(nsubs, subs[:i]...), subs[i+1:]...))
+			break
+		}
+	}
+	notify(b.rsig)
+}
+
+// Cap returns capacity of the buffer
+func (b *Broadcast) Cap() int {
+	return len(b.mem)
+}
+
+func (b *Broadcast) IsClosed() bool {
+	return atomic.LoadUint32(&b.closed) != 0
+}
+
+// Close closes the broadcast. Subscribers get io.EOF once they drain the
+// data, the writer waiting for the slowest subscriber gets io.EOF
+func (b *Broadcast) Close() error {
+	if atomic.CompareAndSwapUint32(&b.closed, 0, 1) {
+		for _, s := range b.subs.Load().([]*Subscriber) {
+			notify(s.sig)
+		}
+		notify(b.rsig)
+	}
+	return nil
+}
+
+// Write writes data to all subscribers. It must not be called concurrently
+func (b *Broadcast) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		if b.IsClosed() {
+			return written, io.EOF
+		}
+		n := minInt(len(data)-written, len(b.mem))
+		wpos := atomic.LoadUint64(&b.wpos)
+		if err := b.put(wpos, data[written:written+n]); err != nil {
+			return written, err
+		}
+		atomic.StoreUint64(&b.wpos, wpos+uint64(n))
+		for _, s := range b.subs.Load().([]*Subscriber) {
+			notify(s.sig)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// put makes room for data written at wpos and copies it to the ring. The
+// overrunning writer waits for the copies of the subscribers
+func (b *Broadcast) put(wpos uint64, data []byte) error {
+	if b.policy != BlockWriter {
+		b.ow.Lock()
+		defer b.ow.Unlock()
+	}
+	n := len(data)
+	if err := b.makeRoom(wpos + uint64(n)); err != nil {
+		return err
+	}
+	pos := int(wpos & uint64(b.mask))
+	if pos > len(b.mem)-n {
+		// wrapped
+		ll := len(b.mem) - pos
+		copy(b.mem[pos:], data[:ll])
+		copy(b.mem[:n-ll], data[ll:])
+	} else {
+		copy(b.mem[pos:pos+n], data)
+	}
+	return nil
+}
+
+// makeRoom applies the policy to the subscribers that would be overrun when
+// the data is written up to end. It fails if the broadcast is closed while
+// the writer waits
+func (b *Broadcast) makeRoom(end uint64) error {
+	if end <= uint64(len(b.mem)) {
+		return nil
+	}
+	min := end - uint64(len(b.mem))
+	for _, s := range b.subs.Load().([]*Subscriber) {
+		for {
+			if b.IsClosed() {
+				return io.EOF
+			}
+			rpos := atomic.LoadUint64(&s.rpos)
+			if rpos == droppedPos || rpos >= min {
+				break
+			}
+			switch b.policy {
+			case DropSubscriber:
+				if atomic.CompareAndSwapUint64(&s.rpos, rpos, droppedPos) {
+					b.unsubscribe(s)
+					notify(s.sig)
+				}
+			case SkipData:
+				if atomic.CompareAndSwapUint64(&s.rpos, rpos, min) {
+					atomic.AddUint64(&s.skipped, min-rpos)
+				}
+			default:
+				<-b.rsig
+			}
+		}
+	}
+	return nil
+}
+
+// Read reads up to len(data) bytes, blocking until at least one byte is
+// available. The data is copied while the writer can't overrun the
+// subscriber, the cursor moved by the writer meanwhile is read again from
+// the new position
+func (s *Subscriber) Read(data []byte) (int, error) {
+	b := s.b
+	if len(data) == 0 {
+		return 0, nil
+	}
+	for {
+		rpos := atomic.LoadUint64(&s.rpos)
+		if rpos == droppedPos {
+			return 0, ErrDropped
+		}
+		closed := b.IsClosed()
+		wpos := atomic.LoadUint64(&b.wpos)
+		if wpos > rpos {
+			if n, ok := s.copyAt(data, rpos, wpos); ok {
+				notify(b.rsig)
+				return n, nil
+			}
+			continue
+		}
+		if closed {
+			return 0, io.EOF
+		}
+		<-s.sig
+	}
+}
+
+// copyAt copies the data between rpos and wpos and moves the cursor, it
+// fails if the writer moved the cursor first
+func (s *Subscriber) copyAt(data []byte, rpos, wpos uint64) (int, bool) {
+	b := s.b
+	if b.policy != BlockWriter {
+		b.ow.RLock()
+		defer b.ow.RUnlock()
+	}
+	n := minInt(int(wpos-rpos), len(data))
+	pos := int(rpos & uint64(b.mask))
+	if pos > len(b.mem)-n {
+		// wrapped
+		ll := len(b.mem) - pos
+		copy(data[:ll], b.mem[pos:])
+		copy(data[ll:n], b.mem[:n-ll])
+	} else {
+		copy(data, b.mem[pos:pos+n])
+	}
+	return n, atomic.CompareAndSwapUint64(&s.rpos, rpos, rpos+uint64(n))
+}
+
+// Skipped returns number of bytes lost by the subscriber under SkipData policy
+func (s *Subscriber) Skipped() int64 {
+	return int64(atomic.LoadUint64(&s.skipped))
+}
+
+// Len returns number of bytes available to the subscriber
+func (s *Subscriber) Len() int {
+	rpos := atomic.LoadUint64(&s.rpos)
+	if rpos == droppedPos {
+		return 0
+	}
+	return int(atomic.LoadUint64(&s.b.wpos) - rpos)
+}
+
+// Close unsubscribes, the writer no longer waits for the subscriber
+func (s *Subscriber) Close() error {
+	atomic.StoreUint64(&s.rpos, droppedPos)
+	s.b.unsubscribe(s)
+	return nil
+}
@@ -0,0 +1,132 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcast(t *testing.T) {
+	b := NewBroadcast(64, BlockWriter)
+	data := make([]byte, 10000)
+	rand.Read(data)
+	subs := []*Subscriber{b.Subscribe(), b.Subscribe(), b.Subscribe()}
+	go func() {
+		for i := 0; i < len(data); i += 100 {
+			b.Write(data[i : i+100])
+		}
+		b.Close()
+	}()
+	var wg sync.WaitGroup
+	for _, s := range subs {
+		wg.Add(1)
+		go func(s *Subscriber) {
+			defer wg.Done()
+			rdata, err := io.ReadAll(s)
+			require.NoError(t, err)
+			require.Equal(t, data, rdata)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func TestBroadcastOverrun(t *testing.T) {
+	b := NewBroadcast(16, DropSubscriber)
+	fast, slow := b.Subscribe(), b.Subscribe()
+	rdata := make([]byte, 16)
+	b.Write([]byte("0123456789"))
+	n, err := fast.Read(rdata)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(rdata[:n]))
+	b.Write([]byte("abcdefghij"))
+	n, err = slow.Read(rdata)
+	require.Equal(t, ErrDropped, err)
+	require.Zero(t, n)
+	n, _ = fast.Read(rdata)
+	require.Equal(t, "abcdefghij", string(rdata[:n]))
+
+	b = NewBroadcast(16, SkipData)
+	s := b.Subscribe()
+	b.Write([]byte("0123456789"))
+	b.Write([]byte("abcdefghij"))
+	require.EqualValues(t, 4, s.Skipped())
+	n, err = s.Read(rdata)
+	require.NoError(t, err)
+	require.Equal(t, "456789abcdefghij", string(rdata[:n]))
+	b.Close()
+	_, err = s.Read(rdata)
+	require.Equal(t, io.EOF, err)
+
+	// the close releases the writer blocked by the slow subscriber
+	b = NewBroadcast(16, BlockWriter)
+	b.Subscribe()
+	b.Write([]byte("0123456789"))
+	done := make(chan error)
+	go func() {
+		_, err := b.Write([]byte("abcdefghij"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Close()
+	require.Equal(t, io.EOF, <-done)
+
+	// the subscriber never sees the record torn by the overrunning writer
+	b = NewBroadcast(64, SkipData)
+	s = b.Subscribe()
+	go func() {
+		var rec [8]byte
+		for i := uint64(1); i <= 100000; i++ {
+			binary.BigEndian.PutUint64(rec[:], i)
+			b.Write(rec[:])
+		}
+		b.Close()
+	}()
+	requireRecords(t, s)
+}
+
+func TestSubscriberClone(t *testing.T) {
+	b := NewBroadcast(16, BlockWriter)
+	s := b.Subscribe()
+	b.Write([]byte("0123456789"))
+	rdata := make([]byte, 4)
+	n, _ := s.Read(rdata)
+	require.Equal(t, "0123", string(rdata[:n]))
+	tap := s.Clone()
+	require.Equal(t, s.Len(), tap.Len())
+	b.Write([]byte("abcdef"))
+	b.Close()
+	got, err := io.ReadAll(tap)
+	require.NoError(t, err)
+	require.Equal(t, "456789abcdef", string(got))
+	got, err = io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, "456789abcdef", string(got))
+
+	// the clone holds the writer as other subscribers do
+	b = NewBroadcast(16, BlockWriter)
+	s = b.Subscribe()
+	b.Write([]byte("0123456789"))
+	tap = s.Clone()
+	io.ReadFull(s, make([]byte, 10))
+	written := make(chan struct{})
+	go func() {
+		b.Write([]byte("abcdefghij"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("the writer overran the clone")
+	case <-time.After(10 * time.Millisecond):
+	}
+	io.ReadFull(tap, make([]byte, 10))
+	<-written
+
+	s.Close()
+	_, err = s.Clone().Read(rdata)
+	require.Equal(t, ErrDropped, err)
+}
@@ -0,0 +1,261 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"unicode/utf8"
+)
+
+// BufReader is the part of *bufio.Reader implemented by *Reader straight
+// from the ring memory
+type BufReader interface {
+	io.Reader
+	io.ByteReader
+	io.WriterTo
+	ReadRune() (r rune, size int, err error)
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+	Buffered() int
+	ReadSlice(delim byte) ([]byte, error)
+	ReadBytes(delim byte) ([]byte, error)
+	ReadString(delim byte) (string, error)
+}
+
+// BufWriter is the part of *bufio.Writer implemented by *Writer, whose
+// writes need no flush
+type BufWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+	io.ReaderFrom
+	WriteRune(r rune) (int, error)
+	Available() int
+	Flush() error
+}
+
+var (
+	_ BufReader = (*bufio.Reader)(nil)
+	_ BufReader = bufReader{}
+	_ BufWriter = (*bufio.Writer)(nil)
+	_ BufWriter = (*Writer)(nil)
+)
+
+// bufReader is the pipe reader whose Peek follows bufio.Reader
+type bufReader struct {
+	*Reader
+}
+
+// Peek returns n bytes without advancing the read position, blocking until
+// they are buffered. As in bufio.Reader fewer bytes come only with the
+// error: the close error if the pipe is closed before n bytes arrive, or
+// bufio.ErrBufferFull if n exceeds the pipe capacity
+func (b bufReader) Peek(n int) ([]byte, error) {
+	data, err := b.peek(context.Background(), n, n)
+	if err == nil && len(data) < n {
+		err = bufio.ErrBufferFull
+	}
+	return data, err
+}
+
+// NewBufReader returns the pipe reader reading straight from the ring
+// memory, instead of the second buffer of bufio.Reader in front of it.
+// Other readers are wrapped by bufio.NewReaderSize
+func NewBufReader(r io.Reader, size int) BufReader {
+	if pr, ok := r.(*Reader); ok {
+		return bufReader{pr}
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+// NewBufWriter returns the pipe writer as is, other writers are wrapped by
+// bufio.NewWriterSize
+func NewBufWriter(w io.Writer, size int) BufWriter {
+	if pw, ok := w.(*Writer); ok {
+		return pw
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+// ReadRune reads the UTF-8 encoded rune, the invalid encoding is read as
+// one byte of utf8.RuneError. The rune is decoded and consumed under one
+// lock, so concurrent readers cannot split it
+func (r *Reader) ReadRune() (rune, int, error) {
+	if r.synchronized {
+		if err := r.lock(); err != nil {
+			return 0, 0, err
+		}
+		defer r.unlock()
+	}
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
+		return 0, 0, timeoutError
+	}
+	var parked parking
+	defer parked.leave()
+	for {
+		_, closed, head, sz := r.loadHeader()
+		if sz > 0 {
+			var b [utf8.UTFMax]byte
+			n := minInt(sz, utf8.UTFMax)
+			ll := minInt(n, len(r.mem)-head)
+			copy(b[:ll], r.mem[head:head+ll])
+			copy(b[ll:n], r.mem[:n-ll])
+			if b[0] < utf8.RuneSelf {
+				r.advance(1)
+				return rune(b[0]), 1, nil
+			}
+			if utf8.FullRune(b[:n]) || closed || sz >= r.maxCap() {
+				c, size := utf8.DecodeRune(b[:n])
+				r.advance(size)
+				return c, size, nil
+			}
+		} else if closed {
+			notify(r.wsig) // resume other readers (if any)
+			return 0, 0, r.closeErr()
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return 0, 0, timeoutError
+			}
+		}
+		r.readBlocked(context.Background(), start)
+	}
+}
+
+// ReadSlice reads until the first delim and returns the data including it.
+// The slice is valid until the next read. As in bufio.Reader it fails with
+// bufio.ErrBufferFull if the buffer fills without delim, and returns the
+// data before the close error if the pipe is closed without it
+func (r *Reader) ReadSlice(delim byte) ([]byte, error) {
+	if r.synchronized {
+		if err := r.lock(); err != nil {
+			return nil, err
+		}
+		defer r.unlock()
+	}
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
+		return nil, timeoutError
+	}
+	from := 0
+	var parked parking
+	defer parked.leave()
+	for {
+		_, closed, head, sz := r.loadHeader()
+		if i := r.indexByte(head, sz, from, delim); i >= 0 {
+			return r.take(head, i+1), nil
+		}
+		if sz >= r.maxCap() {
+			return r.take(head, sz), bufio.ErrBufferFull
+		}
+		if closed {
+			if sz == 0 {
+				notify(r.wsig) // resume other readers (if any)
+				return nil, r.closeErr()
+			}
+			return r.take(head, sz), r.closeErr()
+		}
+		from = sz
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return nil, timeoutError
+			}
+		}
+		r.readBlocked(context.Background(), start)
+	}
+}
+
+// ReadBytes reads until the first delim and returns the copy of the data
+// including it
+func (r *Reader) ReadBytes(delim byte) ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice(delim)
+		line = append(line, frag...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// ReadString is ReadBytes returning string
+func (r *Reader) ReadString(delim byte) (string, error) {
+	line, err := r.ReadBytes(delim)
+	return string(line), err
+}
+
+// indexByte returns the offset of c in sz bytes buffered at head, or -1 if
+// there is none. The search starts at from
+func (r *Reader) indexByte(head, sz, from int, c byte) int {
+	first := minInt(sz, len(r.mem)-head)
+	if from < first {
+		if i := bytes.IndexByte(r.mem[head+from:head+first], c); i >= 0 {
+			return from + i
+		}
+		from = first
+	}
+	if from < sz {
+		if i := bytes.IndexByte(r.mem[from-first:sz-first], c); i >= 0 {
+			return from + i
+		}
+	}
+	return -1
+}
+
+// take copies n bytes at head into the scratch buffer and consumes them
+func (r *Reader) take(head, n int) []byte {
+	if cap(r.peekBuf) < n {
+		r.peekBuf = make([]byte, n)
+	}
+	data := r.peekBuf[:n]
+	ll := minInt(n, len(r.mem)-head)
+	copy(data, r.mem[head:head+ll])
+	copy(data[ll:], r.mem[:n-ll])
+	r.advance(n)
+	return data
+}
+
+// WriteRune writes the UTF-8 encoding of c
+func (w *Writer) WriteRune(c rune) (int, error) {
+	if c >= 0 && c < utf8.RuneSelf {
+		if err := w.WriteByte(byte(c)); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	var b [utf8.UTFMax]byte
+	return w.Write(b[:utf8.EncodeRune(b[:], c)])
+}
+
+// Available returns number of bytes that can be written without blocking
+func (w *Writer) Available() int {
+	return w.Free()
+}
+
+// Flush publishes the writes held by SetPublishBatch, the other written
+// data is already available to the reader. It wakes the reader deferred by
+// SetNagle. It fails if the pipe is closed
+func (w *Writer) Flush() error {
+	if w.IsClosed() {
+		return w.writeErr()
+	}
+	w.flushBatch()
+	if w.nagle != nil {
+		w.nagle.flush()
+	}
+	return nil
+}
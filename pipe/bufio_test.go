@@ -0,0 +1,84 @@
+package pipe
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufInterop(t *testing.T) {
+	r, w := Pipe(16)
+	var br BufReader = NewBufReader(r, 4096)
+	var bw BufWriter = NewBufWriter(w, 4096)
+	_, ok := br.(*bufio.Reader)
+	require.False(t, ok)
+	require.True(t, bw == BufWriter(w))
+	_, ok = NewBufReader(strings.NewReader(""), 16).(*bufio.Reader)
+	require.True(t, ok)
+
+	bw.WriteString("0123456789")
+	r.Discard(8)
+	bw.WriteString("ab\ncd")
+	bw.WriteRune('ж')
+	require.NoError(t, bw.Flush())
+	line, err := br.ReadSlice('\n') // wrapped
+	require.NoError(t, err)
+	require.Equal(t, "89ab\n", string(line))
+	c, size, err := br.ReadRune()
+	require.NoError(t, err)
+	require.Equal(t, 'c', c)
+	require.Equal(t, 1, size)
+	br.ReadByte()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteString("0123456789abcdef") // fills the buffer
+	}()
+	c, size, err = br.ReadRune()
+	require.NoError(t, err)
+	require.Equal(t, 'ж', c)
+	require.Equal(t, 2, size)
+	time.Sleep(20 * time.Millisecond)
+	_, err = br.ReadSlice('\n')
+	require.Equal(t, bufio.ErrBufferFull, err)
+	w.WriteString("x\nyz")
+	w.Close()
+	s, err := br.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "x\n", s)
+	s, err = br.ReadString('\n')
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "yz", s)
+}
+
+func TestBufReaderPeek(t *testing.T) {
+	r, w := Pipe(16)
+	br := NewBufReader(r, 4096)
+	w.WriteString("ab")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteString("cd")
+	}()
+	b, err := br.Peek(4) // waits for all of them
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(b))
+
+	w.WriteString("efghijklmnop")
+	b, err = br.Peek(32)
+	require.Equal(t, bufio.ErrBufferFull, err)
+	require.Equal(t, "abcdefghijklmnop", string(b))
+	r.Discard(16)
+
+	w.WriteString("xy")
+	w.Close()
+	b, err = br.Peek(4) // short peek before close
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "xy", string(b))
+	c, size, err := br.ReadRune()
+	require.NoError(t, err)
+	require.Equal(t, 'x', c)
+	require.Equal(t, 1, size)
+}
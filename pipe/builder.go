@@ -0,0 +1,171 @@
+package pipe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"math"
+)
+
+// Framing is the length prefix of the frames of the stack, see
+// Builder.Frame
+type Framing int
+
+const (
+	// Fixed is the 4-byte big-endian prefix of FramedWriter
+	Fixed Framing = iota + 1
+	// Varint is the uvarint prefix of NewVarintFramedWriter
+	Varint
+)
+
+// Builder assembles the pipe with the layers of framing, compression,
+// encryption and rate limit on both ends. The layers are stacked in the
+// order that works whatever the order of the calls: the data is framed,
+// then compressed, then encrypted, then throttled by the pipe
+type Builder struct {
+	max     int
+	framing Framing
+	comp    Compression
+	aead    cipher.AEAD
+	rate    int
+}
+
+// Build starts the pipe with the buffer of the default size and no layers
+func Build() *Builder {
+	return &Builder{}
+}
+
+// Size sets the buffer of the pipe of at least max bytes
+func (b *Builder) Size(max int) *Builder {
+	b.max = max
+	return b
+}
+
+// Frame keeps the boundaries of the writes, StackReader reads them one by
+// one
+func (b *Builder) Frame(f Framing) *Builder {
+	b.framing = f
+	return b
+}
+
+// Compress compresses the data by the codec of c, see Compress. New panics
+// if the codec is not registered
+func (b *Builder) Compress(c Compression) *Builder {
+	b.comp = c
+	return b
+}
+
+// Encrypt seals the data by AES-GCM with the key of 16, 24 or 32 bytes, see
+// Seal. It panics if the key is of other size
+func (b *Builder) Encrypt(key []byte) *Builder {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(c)
+	if err != nil {
+		panic(err)
+	}
+	return b.Seal(aead)
+}
+
+// Seal seals the data by aead, see Seal
+func (b *Builder) Seal(aead cipher.AEAD) *Builder {
+	b.aead = aead
+	return b
+}
+
+// RateLimit throttles the writer to bytesPerSec of the data in the pipe,
+// see SetWriteLimit
+func (b *Builder) RateLimit(bytesPerSec int) *Builder {
+	b.rate = bytesPerSec
+	return b
+}
+
+// StackReader reads the data written to StackWriter through the layers
+type StackReader struct {
+	r  io.Reader
+	fr *FramedReader // nil unless framed
+	p  *Reader
+}
+
+// StackWriter writes the data through the layers
+type StackWriter struct {
+	w io.Writer
+	c io.Closer // the outermost layer closing the ones below
+	p *Writer
+}
+
+// New creates the pipe and its ends with the layers
+func (b *Builder) New() (*StackReader, *StackWriter) {
+	pr, pw := pipe(b.max, false, false)
+	if b.rate > 0 {
+		pw.SetWriteLimit(b.rate, 0)
+	}
+	sr := &StackReader{r: pr, p: pr}
+	sw := &StackWriter{w: pw, c: pw, p: pw}
+	if b.aead != nil {
+		s := Seal(sw.w, b.aead)
+		sw.w, sw.c = s, s
+		// the frames of the own writer are trusted
+		sr.r = Open(sr.r, b.aead, math.MaxInt32-b.aead.Overhead())
+	}
+	if b.comp != 0 {
+		c := Compress(sw.w, b.comp)
+		sw.w, sw.c = c, c
+		sr.r = Decompress(sr.r)
+	}
+	if b.framing != 0 {
+		var fw *FramedWriter
+		if b.framing == Varint {
+			fw, sr.fr = NewVarintFramedWriter(sw.w, 0), NewVarintFramedReader(sr.r, 0)
+		} else {
+			fw, sr.fr = NewFramedWriter(sw.w, 0), NewFramedReader(sr.r, 0)
+		}
+		if sw.w != io.Writer(pw) {
+			// the prefix and the data go to the layer below in one write
+			fw.SetBatch(1, 0)
+		}
+		sw.w, sr.r = fw, sr.fr
+	}
+	return sr, sw
+}
+
+// Read reads the data, the frame if the stack is framed
+func (s *StackReader) Read(data []byte) (int, error) {
+	return s.r.Read(data)
+}
+
+// ReadFrame reads the next frame. The frame is valid until the next read.
+// It panics if the stack is not framed
+func (s *StackReader) ReadFrame() ([]byte, error) {
+	if s.fr == nil {
+		panic("stack is not framed")
+	}
+	return s.fr.ReadFrame()
+}
+
+// Reader returns the read end of the pipe under the layers
+func (s *StackReader) Reader() *Reader {
+	return s.p
+}
+
+// Close closes the read end
+func (s *StackReader) Close() error {
+	return s.p.CloseRead()
+}
+
+// Write writes data, as one frame if the stack is framed
+func (s *StackWriter) Write(data []byte) (int, error) {
+	return s.w.Write(data)
+}
+
+// Writer returns the write end of the pipe under the layers
+func (s *StackWriter) Writer() *Writer {
+	return s.p
+}
+
+// Close flushes the layers and closes the write end
+func (s *StackWriter) Close() error {
+	return s.c.Close()
+}
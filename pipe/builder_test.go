@@ -0,0 +1,42 @@
+package pipe
+
+import (
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	r, w := Build().Size(256).Frame(Varint).Compress(Gzip).Encrypt(key).RateLimit(1 << 20).New()
+	frames := []string{"hello", strings.Repeat("x", 1000), "bye"}
+	go func() {
+		for _, f := range frames {
+			w.Write([]byte(f))
+		}
+		w.Close()
+	}()
+	for _, f := range frames {
+		frame, err := r.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, f, string(frame))
+	}
+	_, err := r.ReadFrame()
+	require.Equal(t, io.EOF, err)
+
+	// the stream of the plain pipe
+	r, w = Build().Compress(Gzip).New()
+	go func() {
+		w.Write([]byte("hello"))
+		w.Close()
+	}()
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	require.Panics(t, func() { r.ReadFrame() })
+	require.Panics(t, func() { Build().Encrypt([]byte("short")) })
+}
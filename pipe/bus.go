@@ -0,0 +1,157 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus is the pub/sub bus of named topics: the frames published to the
+// topic are delivered to each of its subscribers through the subscriber's
+// own pipe
+type Bus struct {
+	mu     sync.Mutex
+	topics map[string][]*BusSubscriber // replaced on change, read without mu
+	closed bool
+}
+
+// BusSubscriber is the subscription to the topic of the bus
+type BusSubscriber struct {
+	b       *Bus
+	topic   string
+	policy  OverrunPolicy
+	r       *Reader
+	w       *Writer
+	fr      *FramedReader
+	dropped int64
+	mu      sync.Mutex // serializes publishers
+}
+
+// NewBus creates the empty bus
+func NewBus() *Bus {
+	return &Bus{topics: make(map[string][]*BusSubscriber)}
+}
+
+// Subscribe subscribes to the topic with the pipe of bufSize bytes. The
+// policy tells what to do when the pipe has no space for the frame: with
+// BlockWriter the publisher waits, with SkipData the subscriber misses the
+// frame, with DropSubscriber the subscriber is unsubscribed and its reads
+// fail with ErrDropped after the frames delivered before
+func (b *Bus) Subscribe(topic string, bufSize int, policy OverrunPolicy) *BusSubscriber {
+	r, w := pipe(bufSize, false, false)
+	s := &BusSubscriber{b: b, topic: topic, policy: policy, r: r, w: w, fr: NewFramedReader(r, 0)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		w.Close()
+		return s
+	}
+	subs := b.topics[topic]
+	b.topics[topic] = append(subs[:len(subs):len(subs)], s)
+	return s
+}
+
+func (b *Bus) unsubscribe(s *BusSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.topics[s.topic]
+	for i, ss := range subs {
+		if ss == s {
+			nsubs := append(append([]*BusSubscriber(nil), subs[:i]...), subs[i+1:]...)
+			if len(nsubs) == 0 {
+				delete(b.topics, s.topic)
+			} else {
+				b.topics[s.topic] = nsubs
+			}
+			return
+		}
+	}
+}
+
+// Publish delivers the frame to the subscribers of the topic and returns
+// number of subscribers that got it. It may be called concurrently. The
+// frame with its 4-byte header must fit into the pipe of the subscriber,
+// otherwise the subscriber misses it. It fails with io.EOF after Close
+func (b *Bus) Publish(topic string, frame []byte) (int, error) {
+	b.mu.Lock()
+	subs, closed := b.topics[topic], b.closed
+	b.mu.Unlock()
+	if closed {
+		return 0, io.EOF
+	}
+	var hdr [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(frame)))
+	delivered := 0
+	for _, s := range subs {
+		if s.publish(hdr[:], frame) {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// publish writes the frame to the subscriber's pipe and applies the policy
+// if there is no space
+func (s *BusSubscriber) publish(hdr, frame []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := len(hdr) + len(frame)
+	if size > s.w.Cap() || (s.policy != BlockWriter && s.w.Free() < size) {
+		if s.policy == DropSubscriber && size <= s.w.Cap() {
+			s.w.CloseWithError(ErrDropped)
+			s.b.unsubscribe(s)
+		} else {
+			atomic.AddInt64(&s.dropped, 1)
+		}
+		return false
+	}
+	if _, err := s.w.WriteBuffers(net.Buffers{hdr, frame}); err != nil {
+		// closed by the subscriber or the bus
+		s.b.unsubscribe(s)
+		return false
+	}
+	return true
+}
+
+// Close closes the bus, the subscribers get io.EOF after the frames
+// delivered before
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, subs := range b.topics {
+		for _, s := range subs {
+			s.w.Close()
+		}
+	}
+	b.topics = nil
+	return nil
+}
+
+// ReadFrame reads the next frame of the topic, the returned slice is valid
+// until the next read. It must not be called concurrently
+func (s *BusSubscriber) ReadFrame() ([]byte, error) {
+	return s.fr.ReadFrame()
+}
+
+// Reader returns the read end of the subscriber's pipe, it holds the frames
+// in the format of FramedWriter
+func (s *BusSubscriber) Reader() *Reader {
+	return s.r
+}
+
+// Dropped returns number of frames the subscriber missed
+func (s *BusSubscriber) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close unsubscribes from the topic, the blocked publishers are released
+func (s *BusSubscriber) Close() error {
+	s.b.unsubscribe(s)
+	return s.r.CloseRead()
+}
@@ -0,0 +1,54 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	blocking := b.Subscribe("t", 64, BlockWriter)
+	skipping := b.Subscribe("t", 16, SkipData)
+	dropped := b.Subscribe("t", 16, DropSubscriber)
+	other := b.Subscribe("other", 64, BlockWriter)
+	n, err := b.Publish("t", []byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	n, err = b.Publish("t", []byte("abcdefghij"))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, int64(1), skipping.Dropped())
+
+	for _, s := range []*BusSubscriber{blocking, skipping, dropped} {
+		frame, err := s.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, "0123456789", string(frame))
+	}
+	_, err = dropped.ReadFrame()
+	require.Equal(t, ErrDropped, err)
+	frame, err := blocking.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "abcdefghij", string(frame))
+
+	// the closed subscriber releases the blocked publisher
+	skipping.Close()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		blocking.Close()
+	}()
+	for i := 0; i < 10; i++ {
+		b.Publish("t", []byte("0123456789"))
+	}
+	n, err = b.Publish("t", []byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	b.Close()
+	_, err = other.ReadFrame()
+	require.Equal(t, io.EOF, err)
+	_, err = b.Publish("other", nil)
+	require.Equal(t, io.EOF, err)
+}
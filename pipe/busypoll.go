@@ -0,0 +1,33 @@
+package pipe
+
+import "time"
+
+// SetBusyPoll makes the reader poll the pipe for up to d instead of
+// parking when it waits for data, 0 d restores the parking at once. Meant
+// for the SPSCPipe handing off between two goroutines pinned to their own
+// processors: the hand-off takes well under a microsecond while the
+// blocking one takes several. The polling reader burns its processor for
+// the whole d of every wait, and with fewer processors than the spinning
+// goroutines it delays the writer it waits for. After d the reader parks
+// as usual, so ctx and the deadline are noticed within d of the wait. It
+// must not be called concurrently with the reads
+func (r *Reader) SetBusyPoll(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	r.busyPoll = d
+}
+
+// poll busy-waits once while the wait of p is within the busy poll of the
+// end, it returns false when the poll is over
+func (b *ringbuf) poll(p *parking) bool {
+	now := time.Now()
+	if p.until.IsZero() {
+		p.until = now.Add(b.busyPoll)
+	}
+	if !now.Before(p.until) {
+		return false
+	}
+	busyPause(1)
+	return true
+}
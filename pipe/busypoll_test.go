@@ -0,0 +1,42 @@
+package pipe
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusyPoll(t *testing.T) {
+	r, w := SPSCPipe(64)
+	r.SetBusyPoll(time.Second)
+
+	// the polling reader never parks
+	parked := make(chan int32, 1)
+	go func() {
+		time.Sleep(time.Millisecond)
+		parked <- atomic.LoadInt32(&r.waiting.data)
+		w.Write([]byte("hello"))
+	}()
+	buf := make([]byte, 5)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.EqualValues(t, 0, <-parked)
+
+	// it parks after the poll
+	r.SetBusyPoll(time.Millisecond)
+	go func() {
+		for atomic.LoadInt32(&r.waiting.data) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Write([]byte("world"))
+	}()
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(buf[:n]))
+
+	r.SetBusyPoll(-1)
+	require.Zero(t, r.busyPoll)
+}
@@ -0,0 +1,48 @@
+package pipe
+
+import "sync/atomic"
+
+// Clone returns another handle of the write end. The pipe is closed by the
+// close of its last handle, as the pipe of Unix is closed by the close of
+// its last descriptor, so the producers of fan-in close their handles
+// without agreeing who closes the pipe. The handles of the synchronized
+// writer share its lock and the handles of the MPMC writer reserve as it
+// does, so they write concurrently. The handles of other writers must not.
+// The settings of the end, e.g. the deadline, are not cloned. The handle
+// must not be used after its close. The first Clone must not run
+// concurrently with the close of w. It panics for the SPSC writer, the
+// writer that never waits for space and the writer batching its
+// publication, those have one producer
+func (w *Writer) Clone() *Writer {
+	if w.spsc != nil || w.overflow != nil || w.batch != nil {
+		panic("writer is not cloneable")
+	}
+	if w.refs == nil {
+		w.refs = new(int32)
+		*w.refs = 1
+	}
+	atomic.AddInt32(w.refs, 1)
+	c := &Writer{refs: w.refs}
+	c.initFrom(&w.ringbuf, false)
+	c.mpmc = w.mpmc
+	if w.synchronized {
+		c.synchronized = true
+		c.lsig = w.lsig
+		c.locker = w.locker
+		if c.locker == nil {
+			c.locker = &w.ringbuf
+		}
+	}
+	return c
+}
+
+// release closes the handle and reports whether it is the last one
+func (w *Writer) release() bool {
+	if w.refs == nil {
+		return true
+	}
+	if !atomic.CompareAndSwapInt32(&w.released, 0, 1) {
+		return false
+	}
+	return atomic.AddInt32(w.refs, -1) == 0
+}
@@ -0,0 +1,52 @@
+package pipe
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterClone(t *testing.T) {
+	r, w := SyncPipe(64)
+	handles := []*Writer{w}
+	for i := 0; i < 3; i++ {
+		handles = append(handles, handles[i].Clone())
+	}
+	for _, h := range handles {
+		go func(h *Writer) {
+			for i := 0; i < 100; i++ {
+				h.Write([]byte("0123456789"))
+			}
+			h.Close()
+			h.Close() // the second close of the handle is ignored
+		}(h)
+	}
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, 4000, len(b))
+	require.Equal(t, strings.Repeat("0123456789", 400), string(b))
+
+	// the handle stays open after the close of the original
+	r, w = Pipe(16)
+	c := w.Clone()
+	w.Close()
+	require.False(t, r.IsClosed())
+	c.Write([]byte("a"))
+	c.Close()
+	b, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "a", string(b))
+
+	// the error closes at once
+	r, w = Pipe(16)
+	w.Clone()
+	w.CloseWithError(errors.New("broken"))
+	require.True(t, r.IsClosed())
+	require.Panics(t, func() {
+		_, w := SPSCPipe(16)
+		w.Clone()
+	})
+}
@@ -0,0 +1,54 @@
+package pipe
+
+import "time"
+
+// minRead coalesces the reads of small chunks, see SetMinRead
+type minRead struct {
+	n       int
+	maxWait time.Duration
+	since   time.Time // when the data below n was seen first, zero if not
+}
+
+// SetMinRead makes the blocked reader wait until at least n bytes are
+// buffered, or less than requested if the read needs less, before it takes
+// the data. The data below n is taken once it waits for maxWait. It cuts
+// the reads of tiny chunks written one by one at the cost of up to maxWait
+// latency. n below 2 or non-positive maxWait removes the option. It must
+// not be called concurrently with reads
+func (r *Reader) SetMinRead(n int, maxWait time.Duration) {
+	if n < 2 || maxWait <= 0 {
+		r.minRead = nil
+		return
+	}
+	r.minRead = &minRead{n: minInt(n, r.maxCap()), maxWait: maxWait}
+}
+
+// take returns sz if it is enough for the read of want bytes or the data
+// waited long enough, otherwise it returns zero and the channel that fires
+// when the wait is over
+func (m *minRead) take(sz, want int) (int, <-chan time.Time) {
+	if sz >= minInt(m.n, want) {
+		m.since = time.Time{}
+		return sz, nil
+	}
+	now := time.Now()
+	if m.since.IsZero() {
+		m.since = now
+	}
+	left := m.maxWait - now.Sub(m.since)
+	if left <= 0 {
+		m.since = time.Time{}
+		return sz, nil
+	}
+	return 0, time.After(left)
+}
+
+// coalesce returns the number of sz buffered bytes the read of want bytes
+// takes now. If it takes none, it returns the channel that fires when it
+// does. The closed pipe is drained at once
+func (r *Reader) coalesce(closed bool, sz, want int) (int, <-chan time.Time) {
+	if r.minRead == nil || sz == 0 || closed {
+		return sz, nil
+	}
+	return r.minRead.take(sz, want)
+}
@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinRead(t *testing.T) {
+	r, w := Pipe(64)
+	r.SetMinRead(8, 50*time.Millisecond)
+	go func() {
+		for i := 0; i < 8; i++ {
+			w.Write([]byte{byte(i)})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	b, err := r.AcquireRead()
+	require.NoError(t, err)
+	require.Len(t, b, 8)
+	r.ReleaseRead(len(b))
+
+	// the data below the minimum is taken after maxWait
+	w.Write([]byte("ab"))
+	start := time.Now()
+	b, err = r.AcquireRead()
+	require.NoError(t, err)
+	require.Equal(t, "ab", string(b))
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	r.ReleaseRead(len(b))
+
+	// the read shorter than the minimum doesn't wait
+	w.Write([]byte("cd"))
+	start = time.Now()
+	buf := make([]byte, 2)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "cd", string(buf))
+	require.Less(t, time.Since(start), 40*time.Millisecond)
+
+	// the closed pipe is drained at once
+	w.Write([]byte("e"))
+	w.Close()
+	var out bytes.Buffer
+	_, err = r.WriteTo(&out)
+	require.NoError(t, err)
+	require.Equal(t, "e", out.String())
+}
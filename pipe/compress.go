@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrUnknownCodec = errors.New("Unknown compression codec")
+
+// Compression identifies the codec of the compressed frame
+type Compression byte
+
+const (
+	Gzip Compression = iota + 1
+	Snappy
+	Zstd
+)
+
+// Codec compresses and decompresses frames. Snappy and Zstd have no
+// implementation in the standard library, so their codecs are registered by
+// the user, e.g. as adapters of github.com/klauspost/compress
+type Codec interface {
+	// Compress appends compressed src to dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends decompressed src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var codecs = [256]Codec{Gzip: gzipCodec{}}
+
+// RegisterCodec sets the codec of compression c. It must be called before
+// the compressing pipes are used, e.g. in init
+func RegisterCodec(c Compression, codec Codec) {
+	codecs[c] = codec
+}
+
+var gzipWriters sync.Pool
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(dst, src []byte) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	zw, _ := gzipWriters.Get().(*gzip.Writer)
+	if zw == nil {
+		zw = gzip.NewWriter(b)
+	} else {
+		zw.Reset(b)
+	}
+	defer gzipWriters.Put(zw)
+	if _, err := zw.Write(src); err != nil {
+		return dst, err
+	}
+	if err := zw.Close(); err != nil {
+		return dst, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(dst, src []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	b := bytes.NewBuffer(dst)
+	if _, err = b.ReadFrom(zr); err != nil {
+		return dst, err
+	}
+	return b.Bytes(), nil
+}
+
+// CompressWriter compresses each write as one frame
+type CompressWriter struct {
+	w     io.Writer
+	fw    *FramedWriter
+	c     Compression
+	codec Codec
+	buf   []byte
+}
+
+// Compress creates the writer compressing the data written to w with the
+// codec of c. It panics if the codec is not registered
+func Compress(w io.Writer, c Compression) *CompressWriter {
+	if codecs[c] == nil {
+		panic("compression codec is not registered")
+	}
+	return &CompressWriter{w: w, fw: NewFramedWriter(w, 0), c: c, codec: codecs[c]}
+}
+
+// Write compresses data and writes it as one frame. It must not be called
+// concurrently
+func (c *CompressWriter) Write(data []byte) (int, error) {
+	buf, err := c.codec.Compress(append(c.buf[:0], byte(c.c)), data)
+	if err != nil {
+		return 0, err
+	}
+	c.buf = buf[:0]
+	if err = c.fw.WriteFrame(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetBatch batches the frames as FramedWriter.SetBatch
+func (c *CompressWriter) SetBatch(size int, interval time.Duration) error {
+	return c.fw.SetBatch(size, interval)
+}
+
+// Flush writes the batched frames
+func (c *CompressWriter) Flush() error {
+	return c.fw.Flush()
+}
+
+// Close writes the batched frames and closes the underlying writer if it's
+// io.Closer, even if the write fails. The first error is returned
+func (c *CompressWriter) Close() error {
+	return c.fw.Close()
+}
+
+// DecompressReader reads the data written by CompressWriter. Each frame names
+// its codec, so the codecs may be mixed in one stream
+type DecompressReader struct {
+	fr   *FramedReader
+	buf  []byte
+	rest []byte // unread part of the decompressed frame
+}
+
+// Decompress creates the reader decompressing the data read from r
+func Decompress(r io.Reader) *DecompressReader {
+	return &DecompressReader{fr: NewFramedReader(r, 0)}
+}
+
+// Read reads the decompressed data. It fails with ErrUnknownCodec if the
+// codec of the frame is not registered
+func (d *DecompressReader) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	for len(d.rest) == 0 {
+		frame, err := d.fr.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		if len(frame) == 0 || codecs[frame[0]] == nil {
+			return 0, ErrUnknownCodec
+		}
+		if d.buf, err = codecs[frame[0]].Decompress(d.buf[:0], frame[1:]); err != nil {
+			return 0, err
+		}
+		d.rest = d.buf
+	}
+	n := copy(data, d.rest)
+	d.rest = d.rest[n:]
+	return n, nil
+}
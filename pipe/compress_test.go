@@ -0,0 +1,41 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type identityCodec struct{}
+
+func (identityCodec) Compress(dst, src []byte) ([]byte, error)   { return append(dst, src...), nil }
+func (identityCodec) Decompress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func TestCompress(t *testing.T) {
+	RegisterCodec(Snappy, identityCodec{})
+	defer RegisterCodec(Snappy, nil)
+	require.Panics(t, func() { Compress(io.Discard, Zstd) })
+	r, w := Pipe(256)
+	data := bytes.Repeat([]byte("log line\n"), 1000)
+	go func() {
+		gz := Compress(w, Gzip)
+		sn := Compress(w, Snappy)
+		for i := 0; i < 10; i++ {
+			gz.Write(data)
+			sn.Write(data[:100])
+		}
+		gz.Close()
+	}()
+	res, err := io.ReadAll(Decompress(r))
+	require.NoError(t, err)
+	require.Equal(t, 10*(len(data)+100), len(res))
+	require.Equal(t, string(data), string(res[:len(data)]))
+	require.Equal(t, string(data[:100]), string(res[len(data):len(data)+100]))
+
+	var buf bytes.Buffer
+	NewFramedWriter(&buf, 0).Write([]byte{byte(Zstd), 1, 2})
+	_, err = Decompress(&buf).Read(make([]byte, 10))
+	require.Equal(t, ErrUnknownCodec, err)
+}
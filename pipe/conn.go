@@ -27,6 +27,13 @@ func newConn(r1 *Reader, w1 *Writer, r2 *Reader, w2 *Writer) (net.Conn, net.Conn
 		}
 }
 
+// ConnPair returns both ends of the in-memory buffered connection. Unlike
+// net.Pipe, writes complete as soon as the data fits into the buffer of
+// bufSize bytes. Deadlines are supported
+func ConnPair(bufSize int) (net.Conn, net.Conn) {
+	return Conn(bufSize)
+}
+
 func Conn(bufSize int) (net.Conn, net.Conn) {
 	r1, w1 := SyncPipe(bufSize)
 	r2, w2 := SyncPipe(bufSize)
@@ -54,13 +61,11 @@ func (c *pipeConn) RemoteAddr() net.Addr {
 }
 
 func (c *pipeConn) SetReadDeadline(deadline time.Time) error {
-	c.r.setDeadline(deadline)
-	return nil
+	return c.r.SetReadDeadline(deadline)
 }
 
 func (c *pipeConn) SetWriteDeadline(deadline time.Time) error {
-	c.w.setDeadline(deadline)
-	return nil
+	return c.w.SetWriteDeadline(deadline)
 }
 
 func (c *pipeConn) SetDeadline(deadline time.Time) error {
@@ -78,6 +83,16 @@ func (c *pipeConn) Close() error {
 	return err
 }
 
+// CloseRead shuts down the reading side of the connection
+func (c *pipeConn) CloseRead() error {
+	return c.r.CloseRead()
+}
+
+// CloseWrite shuts down the writing side of the connection
+func (c *pipeConn) CloseWrite() error {
+	return c.w.CloseWrite()
+}
+
 func (c *pipeConn) Read(buf []byte) (int, error) {
 	return c.r.Read(buf)
 }
@@ -2,6 +2,7 @@ package pipe
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -151,3 +152,48 @@ func checkTimeoutErr(t *testing.T, err interface{}) {
 	require.True(t, ok)
 	require.True(t, ne.Timeout())
 }
+
+func TestConnHalfClose(t *testing.T) {
+	c1, c2 := Conn(BS)
+	type halfCloser interface {
+		CloseRead() error
+		CloseWrite() error
+	}
+	c1.Write([]byte("ping"))
+	require.NoError(t, c1.(halfCloser).CloseWrite())
+	buf := make([]byte, 8)
+	n, err := c2.Read(buf)
+	require.Equal(t, "ping", string(buf[:n]))
+	require.Equal(t, io.EOF, err)
+	// other direction is still open
+	n, err = c2.Write([]byte("pong"))
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	n, err = c1.Read(buf[:4])
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf[:n]))
+
+	require.NoError(t, c1.(halfCloser).CloseRead())
+	_, err = c2.Write([]byte("pong"))
+	require.Equal(t, ErrReadClosed, err)
+}
+
+func TestConnPair(t *testing.T) {
+	c1, c2 := ConnPair(1024)
+	// buffered: write completes without the reader
+	n, err := c1.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	require.Equal(t, 1000, n)
+	c1.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = c1.Write(make([]byte, 100))
+	checkTimeoutErr(t, err)
+	buf := make([]byte, 1024)
+	n, err = c2.Read(buf[:1000])
+	require.NoError(t, err)
+	require.Equal(t, 1000, n)
+	c1.Close()
+	_, err = c2.Read(buf)
+	require.Equal(t, io.EOF, err)
+	_, err = c2.Write(buf)
+	require.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const copyBufferSize = 32 << 10
+
+// CopyError is the error of Copy, it tells the side that failed
+type CopyError struct {
+	Write bool // the write to dst failed, otherwise the read from src
+	Err   error
+}
+
+func (e *CopyError) Error() string {
+	if e.Write {
+		return "copy write: " + e.Err.Error()
+	}
+	return "copy read: " + e.Err.Error()
+}
+
+func (e *CopyError) Unwrap() error {
+	return e.Err
+}
+
+type contextReader interface {
+	ReadContext(ctx context.Context, data []byte) (int, error)
+}
+
+type contextWriter interface {
+	WriteContext(ctx context.Context, data []byte) (int, error)
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// Copy copies from src to dst until io.EOF like io.Copy, but it aborts on
+// the cancellation of ctx even while blocked. The pipes and other ends with
+// ReadContext and WriteContext are aborted by ctx, the ones with deadlines,
+// e.g. net.Conn, by the past deadline that Copy leaves set. Other ones are
+// aborted after the blocked call returns. The errors are *CopyError
+func Copy(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				past := time.Unix(1, 0)
+				if _, ok := src.(contextReader); !ok {
+					if d, ok := src.(readDeadliner); ok {
+						d.SetReadDeadline(past)
+					}
+				}
+				if _, ok := dst.(contextWriter); !ok {
+					if d, ok := dst.(writeDeadliner); ok {
+						d.SetWriteDeadline(past)
+					}
+				}
+			case <-stop:
+			}
+		}()
+	}
+	cr, _ := src.(contextReader)
+	cw, _ := dst.(contextWriter)
+	buf := make([]byte, copyBufferSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, &CopyError{Err: err}
+		}
+		var n int
+		var rerr error
+		if cr != nil {
+			n, rerr = cr.ReadContext(ctx, buf)
+		} else {
+			n, rerr = src.Read(buf)
+		}
+		if n > 0 {
+			var m int
+			var werr error
+			if cw != nil {
+				m, werr = cw.WriteContext(ctx, buf[:n])
+			} else {
+				m, werr = dst.Write(buf[:n])
+			}
+			written += int64(m)
+			if werr == nil && m < n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				if cerr := ctx.Err(); cerr != nil {
+					werr = cerr
+				}
+				return written, &CopyError{Write: true, Err: werr}
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			if cerr := ctx.Err(); cerr != nil {
+				rerr = cerr
+			}
+			return written, &CopyError{Err: rerr}
+		}
+	}
+}
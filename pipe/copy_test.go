@@ -0,0 +1,61 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyContext(t *testing.T) {
+	sr, sw := Pipe(64)
+	dr, dw := Pipe(64)
+	_, err := sw.Write([]byte("hello"))
+	require.NoError(t, err)
+	sw.Close()
+	n, err := Copy(context.Background(), dw, sr)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(dr, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+
+	// blocked in the read
+	sr, _ = Pipe(64)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = Copy(ctx, dw, sr)
+	var cerr *CopyError
+	require.ErrorAs(t, err, &cerr)
+	require.False(t, cerr.Write)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// blocked in the write
+	sr, sw = Pipe(64)
+	_, err = sw.Write(make([]byte, 64))
+	require.NoError(t, err)
+	sw.Close()
+	_, err = dw.Write(make([]byte, 64))
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	n, err = Copy(ctx, dw, sr)
+	require.ErrorAs(t, err, &cerr)
+	require.True(t, cerr.Write)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int64(0), n)
+
+	// net.Conn by the deadline
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = Copy(ctx, dw, c1)
+	require.ErrorAs(t, err, &cerr)
+	require.False(t, cerr.Write)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
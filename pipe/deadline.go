@@ -0,0 +1,89 @@
+package pipe
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pi/goal/md"
+)
+
+// deadline of one pipe end. Blocked operations wait on c, which is closed
+// when the deadline expires or is changed, so all waiters wake up and recheck
+type deadline struct {
+	t   int64        // md.Monotime of the deadline, 0 - no deadline
+	c   atomic.Value // chan struct{}
+	mu  sync.Mutex
+	tmr *time.Timer
+}
+
+func closeOnce(c chan struct{}) {
+	select {
+	case <-c:
+	default:
+		close(c)
+	}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tmr != nil {
+		d.tmr.Stop()
+		d.tmr = nil
+	}
+	if c, _ := d.c.Load().(chan struct{}); c != nil {
+		closeOnce(c) // wake waiters of the previous deadline
+	}
+	c := make(chan struct{})
+	d.c.Store(c)
+	if t.IsZero() {
+		atomic.StoreInt64(&d.t, 0)
+		return
+	}
+	timeout := time.Until(t)
+	atomic.StoreInt64(&d.t, int64(md.Monotime()+timeout))
+	if timeout <= 0 {
+		close(c)
+		return
+	}
+	d.tmr = time.AfterFunc(timeout, func() {
+		d.mu.Lock()
+		closeOnce(c)
+		d.mu.Unlock()
+	})
+}
+
+func (d *deadline) get() time.Time {
+	t := atomic.LoadInt64(&d.t)
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(t) - md.Monotime())
+}
+
+func (d *deadline) isSet() bool {
+	return atomic.LoadInt64(&d.t) != 0
+}
+
+func (d *deadline) exceeded() bool {
+	t := atomic.LoadInt64(&d.t)
+	return t != 0 && time.Duration(t) <= md.Monotime()
+}
+
+// wait returns the channel to wait on, or true if the deadline is exceeded
+func (d *deadline) wait() (<-chan struct{}, bool) {
+	if d.exceeded() {
+		return nil, true
+	}
+	c, _ := d.c.Load().(chan struct{})
+	if c == nil {
+		d.mu.Lock()
+		if c, _ = d.c.Load().(chan struct{}); c == nil {
+			c = make(chan struct{})
+			d.c.Store(c)
+		}
+		d.mu.Unlock()
+	}
+	return c, false
+}
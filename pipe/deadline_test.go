@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockedDeadline(t *testing.T) {
+	r, w := SyncPipe(8)
+	// several blocked readers all time out
+	ec := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := r.Read(make([]byte, 1))
+			ec <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	r.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	for i := 0; i < 3; i++ {
+		checkTimeoutErr(t, <-ec)
+	}
+
+	// deadline extended while blocked
+	r.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ec <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	r.SetReadDeadline(time.Time{})
+	time.Sleep(30 * time.Millisecond)
+	w.WriteByte(1)
+	require.NoError(t, <-ec)
+
+	// deadline moved to the past unblocks writer
+	w.Write(make([]byte, 8))
+	go func() {
+		_, err := w.Write(make([]byte, 1))
+		ec <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.SetWriteDeadline(time.Now().Add(-time.Second))
+	checkTimeoutErr(t, <-ec)
+	w.SetWriteDeadline(time.Time{})
+	r.Discard(1)
+	require.NoError(t, w.WriteByte(1))
+}
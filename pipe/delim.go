@@ -0,0 +1,138 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+)
+
+// DelimitedReader splits the data of the pipe into frames ending with the
+// delimiter, e.g. lines of text protocol. Frames are returned straight from
+// the ring memory, only the frames wrapped around its end are copied
+type DelimitedReader struct {
+	r       *Reader
+	delim   []byte
+	max     int
+	buf     []byte // wrapped frames are copied here
+	pending int    // length of the last frame with delimiter, consumed on the next read
+	err     error  // sticky, the stream is out of sync after the oversized frame
+}
+
+// NewDelimitedReader creates the reader of frames of up to max bytes ending
+// with delim. max is limited by the buffer size less the delimiter, non-positive
+// max means this limit. The reader must not be used by others meanwhile
+func NewDelimitedReader(r *Reader, delim []byte, max int) *DelimitedReader {
+	if len(delim) == 0 {
+		panic("empty delimiter")
+	}
+	if limit := r.maxCap() - len(delim); max <= 0 || max > limit {
+		max = limit
+	}
+	return &DelimitedReader{r: r, delim: append([]byte(nil), delim...), max: max}
+}
+
+func (d *DelimitedReader) ReadFrame() ([]byte, error) {
+	return d.ReadFrameContext(context.Background())
+}
+
+// ReadFrameContext waits for the delimiter and returns the frame before it.
+// The frame is valid until the next read. Data after the last delimiter is
+// returned as the last frame once the pipe is closed. It fails with
+// *FrameSizeError if no delimiter is found within max bytes, Size is the
+// number of bytes buffered then
+func (d *DelimitedReader) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	r := d.r
+	if d.pending > 0 {
+		r.advance(d.pending)
+		d.pending = 0
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
+		return nil, timeoutError
+	}
+	from := 0
+	var parked parking
+	defer parked.leave()
+	for {
+		_, closed, head, sz := r.loadHeader()
+		if i := d.index(head, sz, from); i >= 0 && i <= d.max {
+			return d.frame(head, i, i+len(d.delim)), nil
+		}
+		if sz-len(d.delim)+1 > d.max {
+			d.err = &FrameSizeError{Size: sz, Max: d.max}
+			return nil, d.err
+		}
+		if closed {
+			if sz == 0 {
+				notify(r.wsig) // resume other readers (if any)
+				return nil, r.closeErr()
+			}
+			return d.frame(head, sz, sz), nil
+		}
+		if from = sz - len(d.delim) + 1; from < 0 {
+			from = 0
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return nil, timeoutError
+			}
+		}
+		r.readBlocked(ctx, start)
+	}
+}
+
+// frame returns n bytes at head and makes next read consume the frame of consumed bytes
+func (d *DelimitedReader) frame(head, n, consumed int) []byte {
+	mem := d.r.mem
+	d.pending = consumed
+	if head+n <= len(mem) {
+		return mem[head : head+n]
+	}
+	ll := len(mem) - head
+	d.buf = append(append(d.buf[:0], mem[head:]...), mem[:n-ll]...)
+	return d.buf
+}
+
+// index returns the offset of the delimiter in sz bytes buffered at head, or
+// -1 if there is none. The search starts at from
+func (d *DelimitedReader) index(head, sz, from int) int {
+	mem := d.r.mem
+	first := minInt(sz, len(mem)-head)
+	if from < first {
+		if i := bytes.Index(mem[head+from:head+first], d.delim); i >= 0 {
+			return from + i
+		}
+	}
+	if first == sz {
+		return -1
+	}
+	// the delimiter spanning the end of memory
+	i := first - len(d.delim) + 1
+	if i < from {
+		i = from
+	}
+	for ; i < first && i+len(d.delim) <= sz; i++ {
+		ll := first - i
+		if bytes.Equal(mem[head+i:], d.delim[:ll]) && bytes.Equal(mem[:len(d.delim)-ll], d.delim[ll:]) {
+			return i
+		}
+	}
+	start := from - first
+	if start < 0 {
+		start = 0
+	}
+	if i := bytes.Index(mem[start:sz-first], d.delim); i >= 0 {
+		return first + start + i
+	}
+	return -1
+}
@@ -0,0 +1,40 @@
+package pipe
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelimitedReader(t *testing.T) {
+	r, w := InitWith(make([]byte, 13), false, false)
+	d := NewDelimitedReader(r, []byte("\r\n"), 0)
+	go func() {
+		for i := 0; i < 200; i++ {
+			w.WriteString(strings.Repeat("x", i%10) + "\r\n")
+		}
+		w.WriteString("tail")
+		w.Close()
+	}()
+	for i := 0; i < 200; i++ {
+		frame, err := d.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("x", i%10), string(frame))
+	}
+	frame, err := d.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "tail", string(frame))
+	_, err = d.ReadFrame()
+	require.Equal(t, io.EOF, err)
+
+	r, w = Pipe(16)
+	d = NewDelimitedReader(r, []byte("\n"), 4)
+	w.WriteString("abcd\nabcde\n")
+	frame, err = d.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(frame))
+	_, err = d.ReadFrame()
+	require.Equal(t, &FrameSizeError{Size: 6, Max: 4}, err)
+}
@@ -0,0 +1,106 @@
+package pipe
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// Dump prints the state of the pipe for debugging, e.g. of the wedged pipe:
+// the decoded header, capacity, lock state and the number of lock waiters.
+// It doesn't take the lock, the values are loaded one by one and may be
+// inconsistent while the pipe is used
+func (b *ringbuf) Dump(w io.Writer) error {
+	return b.dump(w, false)
+}
+
+// DumpData is Dump followed by the hex dump of the buffered data
+func (b *ringbuf) DumpData(w io.Writer) error {
+	return b.dump(w, true)
+}
+
+func (b *ringbuf) dump(w io.Writer, data bool) error {
+	flags := atomic.LoadUint64(&b.hdr.flags)
+	wpos := atomic.LoadUint64(&b.hdr.wpos)
+	rpos := atomic.LoadUint64(&b.hdr.rpos)
+	mem := b.mem
+	if b.grow != nil {
+		mem = b.grow.load()
+	}
+	avail := 0
+	if wpos > rpos {
+		avail = int(wpos - rpos)
+	}
+	head := int(rpos % uint64(len(mem)))
+	_, err := fmt.Fprintf(w, "flags=%#x closed=%t readClosed=%t rpos=%d wpos=%d head=%d avail=%d cap=%d maxCap=%d\n",
+		flags, (flags&closeFlag) != 0, (flags&readCloseFlag) != 0, rpos, wpos, head, avail, len(mem), b.maxCap())
+	if err != nil {
+		return err
+	}
+	if b.synchronized {
+		_, err = fmt.Fprintf(w, "locked=%t waiters=%d\n", atomic.LoadInt32(&b.lck) != 0, atomic.LoadInt32(&b.lq))
+		if err != nil {
+			return err
+		}
+	}
+	if modes := b.modes(); modes != "" {
+		if _, err = fmt.Fprintf(w, "modes=%s\n", modes); err != nil {
+			return err
+		}
+	}
+	if b.cerr != nil {
+		if ce, ok := b.cerr.Load().(closeError); ok && ce.err != nil {
+			if _, err = fmt.Fprintf(w, "closeErr=%v\n", ce.err); err != nil {
+				return err
+			}
+		}
+	}
+	if !data || avail == 0 {
+		return nil
+	}
+	if avail > len(mem) {
+		avail = len(mem) // overwritten meanwhile
+	}
+	d := hex.Dumper(w)
+	if head+avail > len(mem) {
+		// wrapped
+		if _, err = d.Write(mem[head:]); err == nil {
+			_, err = d.Write(mem[:head+avail-len(mem)])
+		}
+	} else {
+		_, err = d.Write(mem[head : head+avail])
+	}
+	if err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+// modes returns the names of the optional behaviors of the end
+func (b *ringbuf) modes() string {
+	var modes []string
+	if b.grow != nil {
+		modes = append(modes, "grow")
+	}
+	if b.remote != nil {
+		modes = append(modes, "remote")
+	}
+	if b.spsc != nil {
+		modes = append(modes, "spsc")
+	}
+	if b.mpmc != nil {
+		modes = append(modes, "mpmc")
+	}
+	if b.overflow != nil {
+		modes = append(modes, "overflow")
+	}
+	if b.limit != nil {
+		modes = append(modes, "limit")
+	}
+	if b.tee != nil {
+		modes = append(modes, "tee")
+	}
+	return strings.Join(modes, ",")
+}
@@ -0,0 +1,28 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	r, w := SyncPipe(16)
+	w.WriteString("0123456789")
+	r.Read(make([]byte, 8))
+	w.WriteString("abcdefghij") // wraps
+	var b bytes.Buffer
+	require.NoError(t, w.Dump(&b))
+	require.Equal(t, "flags=0x0 closed=false readClosed=false rpos=8 wpos=20 head=8 avail=12 cap=16 maxCap=16\nlocked=false waiters=0\n", b.String())
+
+	b.Reset()
+	w.CloseWithError(io.ErrUnexpectedEOF)
+	require.NoError(t, r.DumpData(&b))
+	lines := strings.Split(b.String(), "\n")
+	require.Equal(t, "flags=0x1 closed=true readClosed=false rpos=8 wpos=20 head=8 avail=12 cap=16 maxCap=16", lines[0])
+	require.Equal(t, "closeErr=unexpected EOF", lines[2])
+	require.Contains(t, lines[3], "|89abcdefghij|")
+}
@@ -0,0 +1,205 @@
+package pipe
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const durableMagic = 0x44504950 // "DPIP"
+const durableVersion = 1
+
+// durableLayout is the start of the first page of the durable pipe file, the
+// ring memory starts at the second page
+type durableLayout struct {
+	magic   uint32
+	version uint32
+	size    uint64 // ring memory size
+	_       [6]uint64
+	hdr     header // live counters, trusted only up to the checkpoint
+	cp      [2]checkpoint
+}
+
+// checkpoint is the synced state of the counters. The checkpoints of odd and
+// even generations alternate, so the torn write leaves the previous one
+type checkpoint struct {
+	gen  uint64
+	rpos uint64
+	wpos uint64
+	sum  uint64 // crc32 of the fields above
+}
+
+func (c *checkpoint) checksum() uint64 {
+	var b [24]byte
+	binary.LittleEndian.PutUint64(b[0:], c.gen)
+	binary.LittleEndian.PutUint64(b[8:], c.rpos)
+	binary.LittleEndian.PutUint64(b[16:], c.wpos)
+	return uint64(crc32.Checksum(b[:], castagnoli))
+}
+
+// DurablePipe is the pipe in the file that survives the crash of the
+// process or the system, e.g. the tiny local queue. Written data becomes
+// durable by Sync, consumed data by Commit. Reads don't free the space
+// until they are committed, so the writer never overwrites the data the
+// last checkpoint holds unread. After the crash the pipe is reopened at the
+// last checkpoint: the data read but not committed is read again, the data
+// written but not synced may be lost
+type DurablePipe struct {
+	r *Reader
+	w *Writer
+	f *os.File
+	m []byte
+	l *durableLayout
+
+	mu   sync.Mutex // serializes checkpoints
+	read int        // bytes read past the committed position
+}
+
+// OpenDurable opens the durable pipe in the file at path. The file is
+// created with the buffer of size bytes (rounded up to power of two) if it
+// doesn't exist, otherwise it is recovered at its last checkpoint and size
+// is ignored
+func OpenDurable(path string, size int) (*DurablePipe, error) {
+	f, m, err := openMapping(path, size, initDurable)
+	if err != nil {
+		return nil, err
+	}
+	page := os.Getpagesize()
+	l := (*durableLayout)(unsafe.Pointer(&m[0]))
+	if l.magic != durableMagic || l.version != durableVersion || l.size != uint64(len(m)-page) {
+		err = ErrBadMapping
+	} else if cp := l.last(); cp == nil {
+		err = ErrBadMapping
+	} else {
+		l.hdr.rpos = cp.rpos
+		l.hdr.wpos = cp.wpos
+		l.hdr.flags = 0
+	}
+	if err != nil {
+		munmap(m)
+		f.Close()
+		return nil, err
+	}
+	r := &Reader{}
+	r.initWith(m[page:], false)
+	r.hdr = &l.hdr
+	w := &Writer{}
+	w.initFrom(&r.ringbuf, false)
+	return &DurablePipe{r: r, w: w, f: f, m: m, l: l}, nil
+}
+
+// initDurable sets the layout of the durable pipe with the empty checkpoint
+func initDurable(m []byte, size int) error {
+	l := (*durableLayout)(unsafe.Pointer(&m[0]))
+	l.version = durableVersion
+	l.size = uint64(size)
+	l.magic = durableMagic
+	l.cp[0].sum = l.cp[0].checksum()
+	return msync(m[:os.Getpagesize()])
+}
+
+// last returns the valid checkpoint of the latest generation, nil if none
+func (l *durableLayout) last() *checkpoint {
+	var last *checkpoint
+	for i := range l.cp {
+		cp := &l.cp[i]
+		if cp.sum == cp.checksum() && (last == nil || cp.gen > last.gen) {
+			last = cp
+		}
+	}
+	return last
+}
+
+// Writer returns the write end, its data is made durable by Sync
+func (p *DurablePipe) Writer() *Writer {
+	return p.w
+}
+
+// Read reads the data after the data read before, the read data stays in
+// the pipe until Commit. It must not be called concurrently
+func (p *DurablePipe) Read(data []byte) (int, error) {
+	return p.ReadContext(context.Background(), data)
+}
+
+func (p *DurablePipe) ReadContext(ctx context.Context, data []byte) (int, error) {
+	for {
+		n, err := p.r.readAhead(ctx, p.read, data, nil)
+		if n > 0 || err != nil || len(data) == 0 {
+			p.read += n
+			return n, err
+		}
+	}
+}
+
+// Uncommitted returns number of bytes read but not committed
+func (p *DurablePipe) Uncommitted() int {
+	return p.read
+}
+
+// Commit makes the reads durable and frees their space for the writer, the
+// written data is synced too. It must be called by the reader
+func (p *DurablePipe) Commit() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.read
+	if n == 0 {
+		return nil
+	}
+	// the space is freed after the checkpoint no longer holds the data
+	if err := p.checkpoint(atomic.LoadUint64(&p.l.hdr.rpos) + uint64(n)); err != nil {
+		return err
+	}
+	p.r.advance(n)
+	p.read = 0
+	return nil
+}
+
+// Sync makes the written data durable. It may be called concurrently with
+// the writes, the data written before the call is synced
+func (p *DurablePipe) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkpoint(atomic.LoadUint64(&p.l.hdr.rpos))
+}
+
+// Generation returns the generation of the last checkpoint, it grows by one
+// with every Sync and Commit
+func (p *DurablePipe) Generation() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.l.last().gen
+}
+
+// checkpoint syncs the data and then writes the checkpoint of rpos and the
+// current write position
+func (p *DurablePipe) checkpoint(rpos uint64) error {
+	wpos := atomic.LoadUint64(&p.l.hdr.wpos)
+	if err := msync(p.m); err != nil {
+		return err
+	}
+	gen := p.l.last().gen + 1
+	cp := &p.l.cp[gen%2]
+	cp.gen, cp.rpos, cp.wpos = gen, rpos, wpos
+	cp.sum = cp.checksum()
+	if err := msync(p.m[:os.Getpagesize()]); err != nil {
+		return err
+	}
+	return p.f.Sync()
+}
+
+// Close syncs the written data and unmaps the file, the uncommitted reads
+// are read again after reopen. The ends must not be used after Close
+func (p *DurablePipe) Close() error {
+	err := p.Sync()
+	if merr := munmap(p.m); err == nil {
+		err = merr
+	}
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
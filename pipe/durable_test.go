@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurablePipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	p, err := OpenDurable(path, 16)
+	require.NoError(t, err)
+	w := p.Writer()
+	w.WriteString("hello")
+	require.NoError(t, p.Sync())
+	w.WriteString(" world") // not synced
+	buf := make([]byte, 3)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hel", string(buf[:n]))
+	require.NoError(t, p.Commit())
+	p.Read(buf[:1])
+	require.Equal(t, 1, p.Uncommitted())
+	require.Equal(t, uint64(2), p.Generation())
+	// crash
+	munmap(p.m)
+	p.f.Close()
+
+	p, err = OpenDurable(path, 0)
+	require.NoError(t, err)
+	n, err = p.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, 8, n) // "lo world", synced by Commit
+	// the uncommitted reads hold the space
+	w = p.Writer()
+	require.Equal(t, 8, w.Free())
+	require.NoError(t, p.Commit())
+	require.Equal(t, 16, w.Free())
+	w.WriteString("abc")
+	w.Close()
+	require.NoError(t, p.Close())
+
+	p, err = OpenDurable(path, 0)
+	require.NoError(t, err)
+	defer p.Close()
+	n, err = p.Read(buf)
+	require.NoError(t, err) // the closed state is not kept
+	require.Equal(t, "abc", string(buf[:n]))
+}
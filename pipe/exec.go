@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// Process is the started command with its standard streams bridged to
+// the pipes. The streams the command had set before Command are left as is
+// and their ends are nil
+type Process struct {
+	Cmd    *exec.Cmd
+	Stdin  *Writer // closed by the writer to send EOF to the command
+	Stdout *Reader
+	Stderr *Reader
+
+	ctx     context.Context
+	pumps   sync.WaitGroup // the output pumps
+	stopped chan struct{}
+}
+
+// Command starts cmd with its unset standard streams bridged to the pipes
+// of bufSize bytes. The output is pumped into the pipes as the command
+// writes it, so it never blocks on its output while the pipes have space.
+// The cancellation of ctx kills the command
+func Command(ctx context.Context, cmd *exec.Cmd, bufSize int) (*Process, error) {
+	p := &Process{Cmd: cmd, ctx: ctx, stopped: make(chan struct{})}
+	var stdin io.WriteCloser
+	var outputs []io.Reader
+	var ends []*Writer
+	var err error
+	if cmd.Stdin == nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if cmd.Stdout == nil {
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		var w *Writer
+		p.Stdout, w = pipe(bufSize, true, false)
+		outputs, ends = append(outputs, out), append(ends, w)
+	}
+	if cmd.Stderr == nil {
+		out, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+		var w *Writer
+		p.Stderr, w = pipe(bufSize, true, false)
+		outputs, ends = append(outputs, out), append(ends, w)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		var r *Reader
+		r, p.Stdin = pipe(bufSize, false, true)
+		go func() {
+			_, err := r.WriteTo(stdin)
+			stdin.Close()
+			if err != nil {
+				// the command has exited or closed its input
+				r.CloseRead()
+			}
+		}()
+	}
+	for i := range outputs {
+		p.pumps.Add(1)
+		go func(out io.Reader, w *Writer) {
+			defer p.pumps.Done()
+			_, err := w.ReadFrom(out)
+			if err != nil && !w.IsClosed() {
+				w.CloseWithError(err)
+				return
+			}
+			w.Close()
+		}(outputs[i], ends[i])
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				cmd.Process.Kill()
+			case <-p.stopped:
+			}
+		}()
+	}
+	return p, nil
+}
+
+// Wait waits until the output of the command is pumped into the pipes and
+// the command exits. It returns ctx.Err() if the command was killed by the
+// cancellation of ctx, the error of cmd.Wait otherwise. The output not read
+// from the full pipes holds Wait, unless the read ends are closed
+func (p *Process) Wait() error {
+	p.pumps.Wait()
+	err := p.Cmd.Wait()
+	close(p.stopped)
+	if p.Stdin != nil {
+		p.Stdin.Close()
+	}
+	if cerr := p.ctx.Err(); cerr != nil && err != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	p, err := Command(context.Background(), exec.Command("sh", "-c", "cat; echo done >&2"), 64)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	go func() {
+		p.Stdin.Write(data)
+		p.Stdin.Close()
+	}()
+	var stderr []byte
+	done := make(chan struct{})
+	go func() {
+		stderr, _ = io.ReadAll(p.Stderr)
+		close(done)
+	}()
+	out, err := io.ReadAll(p.Stdout)
+	require.NoError(t, err)
+	require.Equal(t, data, out)
+	<-done
+	require.Equal(t, "done\n", string(stderr))
+	require.NoError(t, p.Wait())
+
+	// killed by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	p, err = Command(ctx, exec.Command("sh", "-c", "exec sleep 10"), 64)
+	require.NoError(t, err)
+	require.Equal(t, context.DeadlineExceeded, p.Wait())
+}
@@ -0,0 +1,251 @@
+package pipe
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters is the Stats counting the events of the pipe
+type Counters struct {
+	written      int64
+	read         int64
+	writeBlocked int64 // ns
+	readBlocked  int64 // ns
+	overcaps     int64
+	closes       int64
+}
+
+func (c *Counters) Written(n int)                { atomic.AddInt64(&c.written, int64(n)) }
+func (c *Counters) Read(n int)                   { atomic.AddInt64(&c.read, int64(n)) }
+func (c *Counters) WriteBlocked(d time.Duration) { atomic.AddInt64(&c.writeBlocked, int64(d)) }
+func (c *Counters) ReadBlocked(d time.Duration)  { atomic.AddInt64(&c.readBlocked, int64(d)) }
+func (c *Counters) Overcap(n int)                { atomic.AddInt64(&c.overcaps, 1) }
+func (c *Counters) Closed(err error)             { atomic.AddInt64(&c.closes, 1) }
+
+// PipeStats is the snapshot of the pipe counters and occupancy
+type PipeStats struct {
+	Written      int64         // bytes
+	Read         int64         // bytes
+	WriteBlocked time.Duration // total stall time of writers
+	ReadBlocked  time.Duration // total stall time of readers
+	Overcaps     int64
+	Closes       int64
+	Buffered     int // bytes
+	Cap          int // bytes
+	HighWater    int // the most bytes ever buffered
+}
+
+func (s *PipeStats) add(o PipeStats) {
+	s.Written += o.Written
+	s.Read += o.Read
+	s.WriteBlocked += o.WriteBlocked
+	s.ReadBlocked += o.ReadBlocked
+	s.Overcaps += o.Overcaps
+	s.Closes += o.Closes
+	s.Buffered += o.Buffered
+	s.Cap += o.Cap
+	s.HighWater += o.HighWater
+}
+
+// Load returns the snapshot of the counters
+func (c *Counters) Load() PipeStats {
+	return PipeStats{
+		Written:      atomic.LoadInt64(&c.written),
+		Read:         atomic.LoadInt64(&c.read),
+		WriteBlocked: time.Duration(atomic.LoadInt64(&c.writeBlocked)),
+		ReadBlocked:  time.Duration(atomic.LoadInt64(&c.readBlocked)),
+		Overcaps:     atomic.LoadInt64(&c.overcaps),
+		Closes:       atomic.LoadInt64(&c.closes),
+	}
+}
+
+type exportedPipe struct {
+	c      *Counters
+	end    *ringbuf // for occupancy, either end
+	labels map[string]string
+}
+
+// Exporter collects PipeStats of the registered pipes for expvar, for
+// Prometheus through Collector, or for any other monitoring system through
+// Each
+type Exporter struct {
+	mu    sync.Mutex
+	pipes map[string]exportedPipe
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{pipes: make(map[string]exportedPipe)}
+}
+
+// Register sets the counters on the ends of the pipe and exports them under
+// name, replacing the pipe registered under it before. Either end may be nil
+func (e *Exporter) Register(name string, r *Reader, w *Writer) *Counters {
+	return e.RegisterLabels(name, nil, r, w)
+}
+
+// RegisterLabels is Register that attaches labels to the pipe, Collector
+// exports the values of its label names
+func (e *Exporter) RegisterLabels(name string, labels map[string]string, r *Reader, w *Writer) *Counters {
+	c := &Counters{}
+	p := exportedPipe{c: c, labels: labels}
+	if r != nil {
+		r.SetStats(c)
+		p.end = &r.ringbuf
+	}
+	if w != nil {
+		w.SetStats(c)
+		p.end = &w.ringbuf
+	}
+	e.mu.Lock()
+	e.pipes[name] = p
+	e.mu.Unlock()
+	return c
+}
+
+// Unregister stops exporting the pipe
+func (e *Exporter) Unregister(name string) {
+	e.mu.Lock()
+	delete(e.pipes, name)
+	e.mu.Unlock()
+}
+
+// Each calls fn for the registered pipes in the name order
+func (e *Exporter) Each(fn func(name string, s PipeStats)) {
+	e.each(func(name string, _ exportedPipe, s PipeStats) { fn(name, s) })
+}
+
+func (e *Exporter) each(fn func(name string, p exportedPipe, s PipeStats)) {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.pipes))
+	for name := range e.pipes {
+		names = append(names, name)
+	}
+	pipes := make([]exportedPipe, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		pipes[i] = e.pipes[name]
+	}
+	e.mu.Unlock()
+	for i, p := range pipes {
+		s := p.c.Load()
+		if p.end != nil {
+			o := p.end.Occupancy()
+			s.Buffered, s.Cap, s.HighWater = o.Buffered, o.Cap, o.HighWater
+		}
+		fn(names[i], p, s)
+	}
+}
+
+// Total returns the sum of PipeStats of the registered pipes
+func (e *Exporter) Total() (total PipeStats) {
+	e.Each(func(_ string, s PipeStats) { total.add(s) })
+	return total
+}
+
+// Publish publishes the stats as the expvar variable name, a map of the
+// pipe names and "total" to PipeStats
+func (e *Exporter) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		vars := make(map[string]PipeStats)
+		var total PipeStats
+		e.Each(func(name string, s PipeStats) {
+			vars[name] = s
+			total.add(s)
+		})
+		vars["total"] = total
+		return vars
+	}))
+}
+
+// MetricDesc describes the metric exported by Collector
+type MetricDesc struct {
+	Name    string // e.g. "pipe_written_bytes_total"
+	Help    string
+	Counter bool     // the counter, the gauge otherwise
+	Labels  []string // the variable labels, "pipe" with the pipe name first
+}
+
+var exportedMetrics = []struct {
+	name, help string
+	counter    bool
+	value      func(s *PipeStats) float64
+}{
+	{"written_bytes_total", "Bytes written to the pipe.", true, func(s *PipeStats) float64 { return float64(s.Written) }},
+	{"read_bytes_total", "Bytes read from the pipe.", true, func(s *PipeStats) float64 { return float64(s.Read) }},
+	{"write_blocked_seconds_total", "Time the writers waited for space.", true, func(s *PipeStats) float64 { return s.WriteBlocked.Seconds() }},
+	{"read_blocked_seconds_total", "Time the readers waited for data.", true, func(s *PipeStats) float64 { return s.ReadBlocked.Seconds() }},
+	{"overcaps_total", "Operations exceeding the pipe capacity.", true, func(s *PipeStats) float64 { return float64(s.Overcaps) }},
+	{"closes_total", "Closes of the pipe.", true, func(s *PipeStats) float64 { return float64(s.Closes) }},
+	{"buffered_bytes", "Bytes buffered in the pipe.", false, func(s *PipeStats) float64 { return float64(s.Buffered) }},
+	{"capacity_bytes", "Capacity of the pipe.", false, func(s *PipeStats) float64 { return float64(s.Cap) }},
+	{"high_water_bytes", "The most bytes ever buffered in the pipe.", false, func(s *PipeStats) float64 { return float64(s.HighWater) }},
+}
+
+// Collector exports the stats of the registered pipes as prometheus.Collector
+// does, without the dependency on the client library: D and M are the
+// description and the metric of the library, made by the functions passed
+// to NewCollector. So *Collector[*prometheus.Desc, prometheus.Metric] is
+// prometheus.Collector:
+//
+//	c := pipe.NewCollector(e, "pipe", []string{"service"},
+//		func(d pipe.MetricDesc) *prometheus.Desc {
+//			return prometheus.NewDesc(d.Name, d.Help, d.Labels, nil)
+//		},
+//		func(d *prometheus.Desc, md pipe.MetricDesc, v float64, labels []string) prometheus.Metric {
+//			t := prometheus.GaugeValue
+//			if md.Counter {
+//				t = prometheus.CounterValue
+//			}
+//			return prometheus.MustNewConstMetric(d, t, v, labels...)
+//		})
+//	prometheus.MustRegister(c)
+type Collector[D, M any] struct {
+	e      *Exporter
+	labels []string
+	mds    []MetricDesc
+	descs  []D
+	metric func(d D, md MetricDesc, value float64, labelValues []string) M
+}
+
+// NewCollector creates the collector of the metrics of e named with the
+// namespace prefix. Each metric has the "pipe" label with the name of the
+// pipe and labels with the values given to RegisterLabels, empty if the
+// pipe has none of them
+func NewCollector[D, M any](e *Exporter, namespace string, labels []string, desc func(MetricDesc) D, metric func(d D, md MetricDesc, value float64, labelValues []string) M) *Collector[D, M] {
+	c := &Collector[D, M]{e: e, labels: labels, metric: metric}
+	names := append([]string{"pipe"}, labels...)
+	for _, m := range exportedMetrics {
+		name := m.name
+		if namespace != "" {
+			name = namespace + "_" + name
+		}
+		md := MetricDesc{Name: name, Help: m.help, Counter: m.counter, Labels: names}
+		c.mds = append(c.mds, md)
+		c.descs = append(c.descs, desc(md))
+	}
+	return c
+}
+
+// Describe sends the descriptions of the metrics
+func (c *Collector[D, M]) Describe(ch chan<- D) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+// Collect sends the metrics of the registered pipes
+func (c *Collector[D, M]) Collect(ch chan<- M) {
+	c.e.each(func(name string, p exportedPipe, s PipeStats) {
+		values := make([]string, 1+len(c.labels))
+		values[0] = name
+		for i, l := range c.labels {
+			values[i+1] = p.labels[l]
+		}
+		for i, m := range exportedMetrics {
+			ch <- c.metric(c.descs[i], c.mds[i], m.value(&s), values)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package pipe
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	e := NewExporter()
+	r1, w1 := Pipe(16)
+	r2, w2 := Pipe(32)
+	e.Register("a", r1, w1)
+	e.Register("b", r2, w2)
+	w1.WriteString("hello")
+	w2.WriteString("world!")
+	r2.Read(make([]byte, 2))
+	w1.Close()
+	var names []string
+	e.Each(func(name string, s PipeStats) { names = append(names, name) })
+	require.Equal(t, []string{"a", "b"}, names)
+	total := e.Total()
+	require.Equal(t, int64(11), total.Written)
+	require.Equal(t, int64(2), total.Read)
+	require.Equal(t, 9, total.Buffered)
+	require.Equal(t, 48, total.Cap)
+	require.Equal(t, int64(1), total.Closes)
+
+	name := fmt.Sprintf("pipe_stats_%d", time.Now().UnixNano()) // published once per run
+	e.Publish(name)
+	var vars map[string]PipeStats
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &vars))
+	require.Equal(t, int64(6), vars["b"].Written)
+	require.Equal(t, total, vars["total"])
+	e.Unregister("a")
+	require.Equal(t, int64(6), e.Total().Written)
+}
+
+func TestCollector(t *testing.T) {
+	type metric struct {
+		desc   string
+		value  float64
+		labels []string
+	}
+	e := NewExporter()
+	r1, w1 := Pipe(16)
+	r2, w2 := Pipe(32)
+	e.RegisterLabels("a", map[string]string{"service": "api"}, r1, w1)
+	e.Register("b", r2, w2)
+	w1.WriteString("hello")
+	w2.WriteString("world!")
+	c := NewCollector(e, "pipe", []string{"service"},
+		func(d MetricDesc) string { return d.Name },
+		func(d string, md MetricDesc, v float64, labels []string) metric {
+			require.Equal(t, []string{"pipe", "service"}, md.Labels)
+			return metric{d, v, labels}
+		})
+
+	descs := make(chan string, 100)
+	c.Describe(descs)
+	close(descs)
+	require.Len(t, descs, len(exportedMetrics))
+	require.Equal(t, "pipe_written_bytes_total", <-descs)
+
+	metrics := make(chan metric, 100)
+	c.Collect(metrics)
+	close(metrics)
+	written := make(map[string]float64)
+	for m := range metrics {
+		if m.desc == "pipe_written_bytes_total" {
+			written[m.labels[0]+"/"+m.labels[1]] = m.value
+		}
+	}
+	require.Equal(t, map[string]float64{"a/api": 5, "b/": 6}, written)
+}
@@ -0,0 +1,256 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"strconv"
+)
+
+var ErrChecksum = errors.New("Frame checksum mismatch")
+
+// frameHeaderSize is the length of the big-endian frame size prefix
+const frameHeaderSize = 4
+
+// checksumSize is the length of the CRC32C appended to the frame in the
+// checksum mode
+const checksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is returned for the frame that fails the checksum. It
+// matches ErrChecksum with errors.Is
+type ChecksumError struct {
+	Offset int64 // stream offset of the frame prefix
+}
+
+func (e *ChecksumError) Error() string {
+	return ErrChecksum.Error() + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrChecksum
+}
+
+// FrameSizeError is returned for the frame longer than the maximum size
+type FrameSizeError struct {
+	Size int // length of the frame
+	Max  int // maximum frame size
+}
+
+func (e *FrameSizeError) Error() string {
+	return "Frame size " + strconv.Itoa(e.Size) + " exceeds " + strconv.Itoa(e.Max)
+}
+
+// maxFrameSize returns the frame size limit for max, non-positive max means
+// the largest int32
+func maxFrameSize(max int) int {
+	if max <= 0 || int64(max) > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return max
+}
+
+// frameSize returns the size read from the prefix, the sizes beyond the
+// largest int32 are cut to it
+func frameSize(n uint64) int {
+	if n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(n)
+}
+
+// uvarintLen returns the length of uvarint encoding of n
+func uvarintLen(n uint64) int {
+	l := 1
+	for ; n >= 0x80; n >>= 7 {
+		l++
+	}
+	return l
+}
+
+// FramedWriter writes each frame with 4-byte big-endian or uvarint length
+// prefix
+type FramedWriter struct {
+	w        io.Writer
+	max      int
+	varint   bool
+	checksum bool
+	hdr      [binary.MaxVarintLen64]byte
+	crc      [checksumSize]byte
+	batch    *frameBatch // nil unless the frames are batched
+}
+
+// NewFramedWriter creates the writer of frames of up to max bytes to w
+func NewFramedWriter(w io.Writer, max int) *FramedWriter {
+	return &FramedWriter{w: w, max: maxFrameSize(max)}
+}
+
+// NewVarintFramedWriter creates the writer of frames with uvarint length
+// prefix (protobuf wire style), so short frames cost 1 or 2 header bytes
+func NewVarintFramedWriter(w io.Writer, max int) *FramedWriter {
+	return &FramedWriter{w: w, max: maxFrameSize(max), varint: true}
+}
+
+// SetChecksum turns on or off the CRC32C appended to each frame. It must
+// match the mode of the reader and must not be changed in the middle of the stream
+func (f *FramedWriter) SetChecksum(on bool) {
+	f.checksum = on
+}
+
+// Write writes data as one frame
+func (f *FramedWriter) Write(data []byte) (int, error) {
+	if err := f.WriteFrame(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteFrame writes data as one frame. The pipe writer publishes the prefix
+// and data at once when they fit into its buffer
+func (f *FramedWriter) WriteFrame(data []byte) error {
+	if len(data) > f.max {
+		return &FrameSizeError{Size: len(data), Max: f.max}
+	}
+	var hdr []byte
+	if f.varint {
+		hdr = f.hdr[:binary.PutUvarint(f.hdr[:], uint64(len(data)))]
+	} else {
+		hdr = f.hdr[:frameHeaderSize]
+		binary.BigEndian.PutUint32(hdr, uint32(len(data)))
+	}
+	bufs := net.Buffers{hdr, data}
+	if f.checksum {
+		binary.BigEndian.PutUint32(f.crc[:], crc32.Checksum(data, castagnoli))
+		bufs = append(bufs, f.crc[:])
+	}
+	if f.batch != nil {
+		return f.batch.add(bufs)
+	}
+	if pw, ok := f.w.(*Writer); ok {
+		_, err := pw.WriteBuffers(bufs)
+		return err
+	}
+	for _, b := range bufs {
+		if _, err := f.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FramedReader reads frames written by FramedWriter
+type FramedReader struct {
+	r        io.Reader
+	max      int
+	varint   bool
+	checksum bool
+	off      int64 // stream offset of the next frame
+	hdr      [frameHeaderSize]byte
+	buf      []byte
+	err      error // sticky, the stream is out of sync after the oversized frame
+}
+
+// NewFramedReader creates the reader of frames of up to max bytes from r
+func NewFramedReader(r io.Reader, max int) *FramedReader {
+	return &FramedReader{r: r, max: maxFrameSize(max)}
+}
+
+// NewVarintFramedReader creates the reader of frames written by
+// NewVarintFramedWriter. The prefix is decoded byte by byte, so it may span
+// the wrap-around of the ring memory or the boundary of r's reads
+func NewVarintFramedReader(r io.Reader, max int) *FramedReader {
+	return &FramedReader{r: r, max: maxFrameSize(max), varint: true}
+}
+
+// SetChecksum turns on or off the verification of CRC32C appended to each
+// frame, e.g. when the ring memory is mapped from the file that may have been
+// partially written
+func (f *FramedReader) SetChecksum(on bool) {
+	f.checksum = on
+}
+
+// readSize reads the length prefix of the next frame
+func (f *FramedReader) readSize() (uint64, error) {
+	if !f.varint {
+		if _, err := io.ReadFull(f.r, f.hdr[:]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(f.hdr[:])), nil
+	}
+	br, ok := f.r.(io.ByteReader)
+	if !ok {
+		br = byteReader{f}
+	}
+	return binary.ReadUvarint(br)
+}
+
+// byteReader reads the prefix of the reader that is not io.ByteReader
+type byteReader struct {
+	f *FramedReader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(b.f.r, b.f.hdr[:1])
+	return b.f.hdr[0], err
+}
+
+// ReadFrame reads the next frame. The returned slice is valid until the next
+// read. It fails with *FrameSizeError if the frame exceeds the maximum size,
+// io.EOF if the stream ends at the frame boundary and io.ErrUnexpectedEOF if
+// it ends inside the frame. In the checksum mode the corrupted frame fails
+// with *ChecksumError, the reading may go on with the next frame
+func (f *FramedReader) ReadFrame() ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	n, err := f.readSize()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(f.max) {
+		f.err = &FrameSizeError{Size: frameSize(n), Max: f.max}
+		return nil, f.err
+	}
+	size := int(n)
+	total := size
+	if f.checksum {
+		total += checksumSize
+	}
+	if cap(f.buf) < total {
+		f.buf = make([]byte, total)
+	}
+	f.buf = f.buf[:total]
+	if _, err = io.ReadFull(f.r, f.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	off := f.off
+	if f.varint {
+		f.off += int64(uvarintLen(n) + total)
+	} else {
+		f.off += int64(frameHeaderSize + total)
+	}
+	if f.checksum && crc32.Checksum(f.buf[:size], castagnoli) != binary.BigEndian.Uint32(f.buf[size:]) {
+		return nil, &ChecksumError{Offset: off}
+	}
+	return f.buf[:size], nil
+}
+
+// Read reads the next frame into data. It fails with io.ErrShortBuffer if
+// data is too short, the frame is discarded then
+func (f *FramedReader) Read(data []byte) (int, error) {
+	frame, err := f.ReadFrame()
+	if err != nil {
+		return 0, err
+	}
+	if len(frame) > len(data) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(data, frame), nil
+}
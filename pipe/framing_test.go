@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFraming(t *testing.T) {
+	r, w := Pipe(16)
+	fw := NewFramedWriter(w, 20)
+	fr := NewFramedReader(r, 10)
+	go func() {
+		for i := 0; i < 50; i++ {
+			fw.Write(bytes.Repeat([]byte{byte(i)}, i%11))
+		}
+		fw.Write(make([]byte, 11))
+		w.Close()
+	}()
+	for i := 0; i < 50; i++ {
+		frame, err := fr.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, string(bytes.Repeat([]byte{byte(i)}, i%11)), string(frame))
+	}
+	_, err := fr.ReadFrame()
+	require.Equal(t, &FrameSizeError{Size: 11, Max: 10}, err)
+	require.Equal(t, &FrameSizeError{Size: 21, Max: 20}, fw.WriteFrame(make([]byte, 21)))
+
+	var buf bytes.Buffer
+	NewFramedWriter(&buf, 0).Write([]byte("abc"))
+	buf.Truncate(5)
+	fr = NewFramedReader(&buf, 0)
+	_, err = fr.ReadFrame()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestVarintFraming(t *testing.T) {
+	r, w := Pipe(16)
+	fw := NewVarintFramedWriter(w, 0)
+	fr := NewVarintFramedReader(r, 300)
+	go func() {
+		for i := 0; i < 100; i++ {
+			fw.Write(bytes.Repeat([]byte{byte(i)}, i*7%300))
+		}
+		w.Close()
+	}()
+	for i := 0; i < 100; i++ {
+		frame, err := fr.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, string(bytes.Repeat([]byte{byte(i)}, i*7%300)), string(frame))
+	}
+	_, err := fr.ReadFrame()
+	require.Equal(t, io.EOF, err)
+
+	var buf bytes.Buffer
+	NewVarintFramedWriter(&buf, 0).Write(make([]byte, 200))
+	require.Equal(t, 202, buf.Len())
+	buf.Truncate(1)
+	_, err = NewVarintFramedReader(struct{ io.Reader }{&buf}, 0).ReadFrame()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestFrameChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	fw := NewVarintFramedWriter(&buf, 0)
+	fw.SetChecksum(true)
+	for i := 0; i < 3; i++ {
+		fw.Write(bytes.Repeat([]byte{byte(i)}, 200))
+	}
+	data := buf.Bytes()
+	data[2+200+4+2+10] ^= 1 // payload of the second frame
+	fr := NewVarintFramedReader(bytes.NewReader(data), 0)
+	fr.SetChecksum(true)
+	frame, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, 200, len(frame))
+	_, err = fr.ReadFrame()
+	require.True(t, errors.Is(err, ErrChecksum))
+	require.Equal(t, &ChecksumError{Offset: 206}, err)
+	frame, err = fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, string(bytes.Repeat([]byte{2}, 200)), string(frame))
+
+	r, w := Pipe(64)
+	fw = NewFramedWriter(w, 0)
+	fw.SetChecksum(true)
+	fr = NewFramedReader(r, 0)
+	fr.SetChecksum(true)
+	go func() {
+		for i := 0; i < 50; i++ {
+			fw.Write(bytes.Repeat([]byte{byte(i)}, i))
+		}
+		w.Close()
+	}()
+	for i := 0; i < 50; i++ {
+		frame, err := fr.ReadFrame()
+		require.NoError(t, err)
+		require.Equal(t, i, len(frame))
+	}
+}
@@ -0,0 +1,18 @@
+package pipe
+
+import "github.com/pi/goal/future"
+
+// ReadFrameAsync reads the next frame in the background and returns the
+// future of its copy. No other read may run until the future is resolved
+func (f *FramedReader) ReadFrameAsync() *future.Value[[]byte] {
+	fut := future.NewValue[[]byte]()
+	go func() {
+		frame, err := f.ReadFrame()
+		if err != nil {
+			fut.Resolve(nil, err)
+			return
+		}
+		fut.Resolve(append([]byte(nil), frame...), nil)
+	}()
+	return fut
+}
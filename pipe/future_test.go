@@ -0,0 +1,22 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFrameAsync(t *testing.T) {
+	r, w := Pipe(64)
+	fr := NewFramedReader(r, 0)
+	fut := fr.ReadFrameAsync()
+	NewFramedWriter(w, 0).WriteFrame([]byte("hello"))
+	frame, err := fut.Get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(frame))
+	w.Close()
+	_, err = fr.ReadFrameAsync().Get(context.Background())
+	require.Equal(t, io.EOF, err)
+}
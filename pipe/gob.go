@@ -0,0 +1,128 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"sync"
+	"time"
+)
+
+// GobEncoder sends gob-encoded values through the pipe, one frame per value,
+// so the reader never sees a partially written value
+type GobEncoder struct {
+	w   *Writer
+	fw  *FramedWriter
+	mu  sync.Mutex // serializes Encode
+	buf bytes.Buffer
+	enc *gob.Encoder
+}
+
+// NewEncoderPipe binds the gob encoder to the write end w. Encode blocks
+// while the reader is behind
+func NewEncoderPipe(w *Writer) *GobEncoder {
+	e := &GobEncoder{w: w, fw: NewFramedWriter(w, 0)}
+	e.enc = gob.NewEncoder(&e.buf)
+	return e
+}
+
+// Encode sends v. It may be called concurrently
+func (e *GobEncoder) Encode(v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.buf.Reset()
+	err := e.enc.Encode(v)
+	if err != nil && e.buf.Len() == 0 {
+		return err
+	}
+	// the types sent before the failure are buffered, the decoder needs them
+	if werr := e.fw.WriteFrame(e.buf.Bytes()); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// SetBatch batches the values as FramedWriter.SetBatch batches the frames
+func (e *GobEncoder) SetBatch(size int, interval time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fw.SetBatch(size, interval)
+}
+
+// Flush sends the batched values
+func (e *GobEncoder) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fw.Flush()
+}
+
+// Close sends the batched values and closes the pipe. The decoder gets
+// io.EOF once it decodes the sent values. The pipe is closed even if the
+// values fail to send, the first error is returned
+func (e *GobEncoder) Close() error {
+	err := e.Flush()
+	if cerr := e.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CloseWithError sends the batched values and closes the pipe. Decode
+// returns err after the sent values. The error is returned as by Close
+func (e *GobEncoder) CloseWithError(err error) error {
+	ferr := e.Flush()
+	if cerr := e.w.CloseWithError(err); ferr == nil {
+		ferr = cerr
+	}
+	return ferr
+}
+
+// GobDecoder receives values sent by GobEncoder
+type GobDecoder struct {
+	r   *Reader
+	dec *gob.Decoder
+}
+
+// NewDecoderPipe binds the gob decoder to the read end r
+func NewDecoderPipe(r *Reader) *GobDecoder {
+	return &GobDecoder{r: r, dec: gob.NewDecoder(&frameStream{fr: NewFramedReader(r, 0)})}
+}
+
+// Decode receives the next value into v. It must not be called concurrently
+func (d *GobDecoder) Decode(v interface{}) error {
+	return d.dec.Decode(v)
+}
+
+// Close closes the read end, the encoder fails with ErrReadClosed
+func (d *GobDecoder) Close() error {
+	return d.r.CloseRead()
+}
+
+// frameStream reads the payloads of successive frames as one stream
+type frameStream struct {
+	fr    *FramedReader
+	frame []byte // unread part of the current frame
+}
+
+func (s *frameStream) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if len(s.frame) == 0 {
+		frame, err := s.fr.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		s.frame = frame
+	}
+	n := copy(data, s.frame)
+	s.frame = s.frame[n:]
+	return n, nil
+}
+
+// ReadByte keeps the decoder from buffering the pipe beyond the current frame
+func (s *frameStream) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(s, b[:])
+	return b[0], err
+}
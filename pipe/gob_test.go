@@ -0,0 +1,36 @@
+package pipe
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGobPipe(t *testing.T) {
+	type msg struct {
+		N int
+		S string
+	}
+	r, w := Pipe(64)
+	enc := NewEncoderPipe(w)
+	dec := NewDecoderPipe(r)
+	require.Error(t, enc.Encode(func() {}))
+	go func() {
+		for i := 0; i < 100; i++ {
+			enc.Encode(msg{i, strings.Repeat("x", i)})
+		}
+		enc.Encode(msg{N: -1})
+		enc.CloseWithError(io.ErrClosedPipe)
+	}()
+	for i := 0; i < 100; i++ {
+		var m msg
+		require.NoError(t, dec.Decode(&m))
+		require.Equal(t, msg{i, strings.Repeat("x", i)}, m)
+	}
+	var m msg
+	require.NoError(t, dec.Decode(&m))
+	require.Equal(t, -1, m.N)
+	require.Equal(t, io.ErrClosedPipe, dec.Decode(&m))
+}
@@ -0,0 +1,105 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Group is errgroup.Group that closes the pipes attached to it once any of
+// its goroutines fails, so the rest blocked on them return instead of
+// waiting for the context
+type Group struct {
+	cancel func()
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+	done   bool
+	ends   []io.Closer
+}
+
+// NewGroup returns the group and its context, canceled once any member
+// fails or Wait returns. The cancellation of ctx fails the group with the
+// ctx error
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	gctx, cancel := context.WithCancel(ctx)
+	g := &Group{cancel: cancel}
+	go func() {
+		<-gctx.Done()
+		g.fail(ctx.Err())
+	}()
+	return g, gctx
+}
+
+// Attach attaches the pipe ends to the group. On failure the pipes are
+// closed with the error of the member, other closers are closed with
+// CloseWithError if they have it and with Close otherwise. The ends
+// attached to the failed group are closed at once
+func (g *Group) Attach(ends ...io.Closer) {
+	g.mu.Lock()
+	err := g.err
+	if err == nil {
+		g.ends = append(g.ends, ends...)
+	}
+	g.mu.Unlock()
+	if err != nil {
+		closeEnds(ends, err)
+	}
+}
+
+// Go attaches the ends and runs fn in the new goroutine. The first error
+// returned by a member fails the group
+func (g *Group) Go(fn func() error, ends ...io.Closer) {
+	g.Attach(ends...)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.fail(fn())
+	}()
+}
+
+// Wait waits for all the members and returns the error the group failed
+// with. The pipes of the group that didn't fail stay open
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	g.done = true
+	err := g.err
+	g.ends = nil
+	g.mu.Unlock()
+	g.cancel()
+	return err
+}
+
+// fail fails the group with the first non-nil err
+func (g *Group) fail(err error) {
+	if err == nil {
+		return
+	}
+	g.mu.Lock()
+	if g.err != nil || g.done {
+		g.mu.Unlock()
+		return
+	}
+	g.err = err
+	ends := g.ends
+	g.ends = nil
+	g.mu.Unlock()
+	g.cancel()
+	closeEnds(ends, err)
+}
+
+func closeEnds(ends []io.Closer, err error) {
+	for _, c := range ends {
+		switch c := c.(type) {
+		case *Reader:
+			c.closeWithError(err)
+		case *Writer:
+			c.closeWithError(err)
+		case interface{ CloseWithError(error) error }:
+			c.CloseWithError(err)
+		default:
+			c.Close()
+		}
+	}
+}
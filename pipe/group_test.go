@@ -0,0 +1,63 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroup(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	r, w := Pipe(16)
+	g.Go(func() error {
+		// blocks on the full pipe until the consumer fails
+		for {
+			if _, err := w.Write(make([]byte, 16)); err != nil {
+				return err
+			}
+		}
+	}, w)
+	r2, w2 := Pipe(16)
+	g.Go(func() error {
+		// blocks on the empty pipe
+		_, err := r2.Read(make([]byte, 1))
+		return err
+	}, r2)
+	g.Go(func() error {
+		r.Read(make([]byte, 16))
+		return errors.New("broken")
+	}, r)
+	require.EqualError(t, g.Wait(), "broken")
+	require.Error(t, ctx.Err())
+	require.True(t, w2.IsClosed())
+	_, err := r2.Read(make([]byte, 1))
+	require.EqualError(t, err, "broken")
+
+	// attaching to the failed group closes at once
+	_, w3 := Pipe(16)
+	g.Attach(w3)
+	require.True(t, w3.IsClosed())
+
+	// parent cancellation fails the group
+	pctx, cancel := context.WithCancel(context.Background())
+	g, _ = NewGroup(pctx)
+	r, _ = Pipe(16)
+	g.Go(func() error {
+		_, err := r.Read(make([]byte, 1))
+		return err
+	}, r)
+	cancel()
+	require.Equal(t, context.Canceled, g.Wait())
+
+	// the group that didn't fail leaves the pipes open
+	g, _ = NewGroup(context.Background())
+	r, w = Pipe(16)
+	g.Go(func() error {
+		_, err := w.Write([]byte("ok"))
+		return err
+	}, w, r)
+	require.NoError(t, g.Wait())
+	require.False(t, w.IsClosed())
+}
@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"sync/atomic"
+)
+
+// growth is the shared state of the auto-growing buffer. The writer replaces
+// the memory with the doubled copy when it would otherwise block, the reader
+// picks the new memory up in loadHeader. The old memory is never written
+// after the replace, so the reader may still finish reading from it
+type growth struct {
+	max int
+	mem atomic.Value // []byte
+}
+
+func (g *growth) load() []byte {
+	return g.mem.Load().([]byte)
+}
+
+// GrowPipe creates the synchronized pipe with buffer of initial bytes that
+// doubles up to max bytes when writers would block. Both sizes are rounded
+// up to power of two
+func GrowPipe(initial, max int) (*Reader, *Writer) {
+	r := &Reader{}
+	w := &Writer{}
+	r.init(initial, true)
+	if max < len(r.mem) {
+		max = len(r.mem)
+	} else if (max & (max - 1)) != 0 {
+		max = 1 << bitlen(uint(max))
+	}
+	r.grow = &growth{max: max}
+	r.grow.mem.Store(r.mem)
+	w.initFrom(&r.ringbuf, true)
+	return r, w
+}
+
+// loadMem switches to the current memory of the growing buffer
+func (b *ringbuf) loadMem() {
+	if mem := b.grow.load(); len(mem) != len(b.mem) {
+		b.mem = mem
+		b.mask = len(mem) - 1
+	}
+}
+
+// expand doubles the memory until n more bytes fit or the max size is reached.
+// It returns false if the memory was not replaced. Must be called by the
+// writer holding the lock
+func (w *Writer) expand(n int) bool {
+	if w.grow == nil {
+		return false
+	}
+	w.flushBatch()
+	wpos := atomic.LoadUint64(&w.hdr.wpos)
+	rpos := atomic.LoadUint64(&w.hdr.rpos)
+	sz := int(wpos - rpos)
+	size := len(w.mem)
+	for size < sz+n && size < w.grow.max {
+		size <<= 1
+	}
+	if size == len(w.mem) {
+		return false
+	}
+	mem := make([]byte, size)
+	// keep every byte at its counter position, so the counters stay valid
+	for pos := rpos; pos < wpos; {
+		from := w.offset(pos)
+		to := int(pos & uint64(size-1))
+		nc := copy(mem[to:], w.mem[from:minInt(from+int(wpos-pos), len(w.mem))])
+		pos += uint64(nc)
+	}
+	w.grow.mem.Store(mem)
+	w.mem = mem
+	w.mask = size - 1
+	return true
+}
@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrowPipe(t *testing.T) {
+	r, w := GrowPipe(8, 60)
+	require.Equal(t, 8, r.Cap())
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	w.Write(data[:5])
+	rdata := make([]byte, 3)
+	require.NoError(t, r.ReadFull(rdata))
+	// written data wraps around the end of memory before the growth
+	n, err := w.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, 64, r.Cap())
+	require.Equal(t, 38, r.Len())
+	rdata = make([]byte, 38)
+	require.NoError(t, r.ReadFull(rdata))
+	require.Equal(t, string(data[3:5])+string(data), string(rdata))
+
+	// no growth past max
+	w.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err = w.Write(make([]byte, 65))
+	require.Equal(t, 64, n)
+	require.True(t, err.(net.Error).Timeout())
+	require.Equal(t, 64, w.Cap())
+}
+
+func TestGrowPipeParallel(t *testing.T) {
+	r, w := GrowPipe(16, 1<<16)
+	const total = 1 << 20
+	go func() {
+		var b [1000]byte
+		for written := 0; written < total; {
+			n := minInt(rand.Intn(len(b))+1, total-written)
+			for i := 0; i < n; i++ {
+				b[i] = byte(written + i)
+			}
+			w.Write(b[:n])
+			written += n
+		}
+		w.Close()
+	}()
+	var b [777]byte
+	readed := 0
+	for {
+		n, err := r.Read(b[:rand.Intn(len(b))+1])
+		for i := 0; i < n; i++ {
+			if b[i] != byte(readed+i) {
+				t.Fatalf("wrong byte at %d", readed+i)
+			}
+		}
+		readed += n
+		if err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	require.Equal(t, total, readed)
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"io"
+	"sync"
+)
+
+const defaultChunkSize = 16 << 10
+
+// Stream is the bidirectional stream of messages M, e.g. the stream of the
+// gRPC service generated by protoc whose message carries the bytes field
+type Stream[M any] interface {
+	Send(M) error
+	Recv() (M, error)
+}
+
+// streamCloser half-closes the client stream once, the server stream is
+// ended by the return of its handler
+type streamCloser struct {
+	s    any
+	once sync.Once
+}
+
+func (c *streamCloser) Close() (err error) {
+	c.once.Do(func() {
+		if cs, ok := c.s.(interface{ CloseSend() error }); ok {
+			err = cs.CloseSend()
+		}
+	})
+	return err
+}
+
+// StreamPipe bridges the stream through the pipes of bufSize bytes. The
+// written data is sent in the messages made by wrap of up to chunkSize
+// bytes, non-positive chunkSize means 16KB. The data unwrapped from the
+// received messages is read in order, so the writes larger than the chunk
+// are reassembled. wrap must not keep the data after Send returns. The
+// close of the write end half-closes the client stream by CloseSend, the
+// server stream ends when its handler returns, e.g. after Wait
+func StreamPipe[M any](s Stream[M], bufSize, chunkSize int, wrap func(data []byte) M, unwrap func(M) []byte) *Duplex {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	d, rw, sr := newDuplex(bufSize, &streamCloser{s: s})
+	d.run(func() {
+		for {
+			m, err := s.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				d.endRecv(rw, err)
+				return
+			}
+			if _, err = rw.Write(unwrap(m)); err != nil {
+				return // the reader is closed
+			}
+		}
+	}, func() {
+		for {
+			view, err := sr.AcquireRead()
+			if err == io.EOF {
+				if err = d.c.Close(); err != nil {
+					d.setErr(err)
+				}
+				return
+			}
+			if err == nil {
+				n := minInt(len(view), chunkSize)
+				err = s.Send(wrap(view[:n]))
+				sr.ReleaseRead(n)
+			}
+			if err != nil {
+				d.endSend(sr, err)
+				return
+			}
+		}
+	})
+	return d
+}
@@ -0,0 +1,65 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type chunkMsg struct {
+	Data []byte
+}
+
+// chanStream is the end of the in-memory stream of chunkMsg
+type chanStream struct {
+	in, out chan *chunkMsg
+	sent    int
+}
+
+func (s *chanStream) Send(m *chunkMsg) error {
+	s.sent++
+	s.out <- &chunkMsg{Data: append([]byte(nil), m.Data...)}
+	return nil
+}
+
+func (s *chanStream) Recv() (*chunkMsg, error) {
+	m, ok := <-s.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return m, nil
+}
+
+func (s *chanStream) CloseSend() error {
+	close(s.out)
+	return nil
+}
+
+func TestStreamPipe(t *testing.T) {
+	c1, c2 := make(chan *chunkMsg, 64), make(chan *chunkMsg, 64)
+	client := &chanStream{in: c1, out: c2}
+	server := &chanStream{in: c2, out: c1}
+	wrap := func(data []byte) *chunkMsg { return &chunkMsg{Data: data} }
+	unwrap := func(m *chunkMsg) []byte { return m.Data }
+	dc := StreamPipe[*chunkMsg](client, 1024, 100, wrap, unwrap)
+	ds := StreamPipe[*chunkMsg](server, 1024, 100, wrap, unwrap)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		dc.Write(data)
+		dc.CloseWrite()
+	}()
+	b, err := io.ReadAll(ds)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	require.GreaterOrEqual(t, client.sent, 10)
+	ds.CloseWrite()
+	b, err = io.ReadAll(dc)
+	require.NoError(t, err)
+	require.Len(t, b, 0)
+	require.NoError(t, dc.Wait())
+	require.NoError(t, ds.Wait())
+}
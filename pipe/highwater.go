@@ -0,0 +1,51 @@
+package pipe
+
+import "sync/atomic"
+
+// occupancy is the maximum of bytes buffered in the pipe, shared by its ends
+type occupancy struct {
+	max  int64
+	peak int64 // since the last ResetPeak
+}
+
+func maxInt64(p *int64, n int64) {
+	for {
+		m := atomic.LoadInt64(p)
+		if n <= m || atomic.CompareAndSwapInt64(p, m, n) {
+			return
+		}
+	}
+}
+
+func (o *occupancy) observe(n int64) {
+	if n > atomic.LoadInt64(&o.peak) {
+		maxInt64(&o.peak, n)
+		maxInt64(&o.max, n)
+	}
+}
+
+// Occupancy tells how full the buffer gets, to size its capacity
+type Occupancy struct {
+	Buffered  int // bytes buffered now
+	Cap       int
+	HighWater int // the most bytes ever buffered
+	Peak      int // the most bytes buffered since the last ResetPeak
+}
+
+// Occupancy returns the occupancy of the buffer. The maximums are observed
+// by the writer when it publishes the data, for the pipe shared with other
+// processes they are of the writers in this one
+func (b *ringbuf) Occupancy() Occupancy {
+	return Occupancy{
+		Buffered:  b.Buffered(),
+		Cap:       b.Cap(),
+		HighWater: int(atomic.LoadInt64(&b.occ.max)),
+		Peak:      int(atomic.LoadInt64(&b.occ.peak)),
+	}
+}
+
+// ResetPeak starts the new window of Peak and returns the peak of the
+// previous one, e.g. to report the maximum of every minute
+func (b *ringbuf) ResetPeak() int {
+	return int(atomic.SwapInt64(&b.occ.peak, int64(b.Buffered())))
+}
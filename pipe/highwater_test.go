@@ -0,0 +1,22 @@
+package pipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOccupancy(t *testing.T) {
+	r, w := Pipe(16)
+	w.WriteString("0123456789")
+	r.Read(make([]byte, 8))
+	w.WriteString("abc")
+	require.Equal(t, Occupancy{Buffered: 5, Cap: 16, HighWater: 10, Peak: 10}, r.Occupancy())
+	require.Equal(t, 10, w.ResetPeak())
+	w.WriteString("de")
+	require.Equal(t, Occupancy{Buffered: 7, Cap: 16, HighWater: 10, Peak: 7}, w.Occupancy())
+
+	e := NewExporter()
+	e.Register("p", r, nil)
+	require.Equal(t, 10, e.Total().HighWater)
+}
@@ -0,0 +1,83 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// requestBody is the read end as the body, its close stops the writer
+type requestBody struct {
+	*Reader
+}
+
+func (b requestBody) Close() error {
+	return b.CloseRead()
+}
+
+// NewRequest creates the request streaming its body from r, the body is
+// sent chunked as it is written. The close of the body by the transport
+// closes the read end, so the writer stops
+func NewRequest(ctx context.Context, method, url string, r *Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, url, requestBody{r})
+}
+
+// StreamResponse writes the data read from r to the response until the
+// close of r or the cancellation of ctx. The response is flushed once
+// watermark bytes are written since the last flush, or when r has no more
+// data buffered, so the client gets the data without delay. Non-positive
+// watermark flushes every write
+func StreamResponse(ctx context.Context, w http.ResponseWriter, r *Reader, watermark int) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	var written int64
+	unflushed := 0
+	for {
+		if err := r.ReadWaitWithContext(ctx, 1); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return written, err
+		}
+		view, err := r.AcquireRead()
+		if err != nil {
+			return written, err
+		}
+		n, err := w.Write(view)
+		r.ReleaseRead(n)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if unflushed += n; flusher != nil && (unflushed >= watermark || r.Buffered() == 0) {
+			flusher.Flush()
+			unflushed = 0
+		}
+	}
+}
+
+// ReceiveBody copies the body of the request into w and closes w, io.EOF
+// ends the data and other errors close w with them. The full pipe stops
+// reading the body, so the client is held back by the flow control of the
+// connection. The cancellation of ctx, e.g. the request context, closes the
+// body and w with ctx.Err()
+func ReceiveBody(ctx context.Context, w *Writer, body io.ReadCloser) (int64, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.CloseWithError(ctx.Err())
+			body.Close()
+		case <-stop:
+		}
+	}()
+	n, err := w.ReadFrom(body)
+	if cerr := ctx.Err(); cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		w.CloseWithError(err)
+		return n, err
+	}
+	return n, w.Close()
+}
@@ -0,0 +1,45 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPStreaming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// HTTP/1 server can't read the body once the response is written
+		r, w := Pipe(1024)
+		ReceiveBody(req.Context(), w, req.Body)
+		StreamResponse(req.Context(), rw, r, 16)
+	}))
+	defer srv.Close()
+	r, w := Pipe(64)
+	req, err := NewRequest(context.Background(), "POST", srv.URL, r)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+
+	// the cancellation stops the response
+	r, _ = Pipe(64)
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = StreamResponse(ctx, rec, r, 0)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
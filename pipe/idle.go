@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrIdle = errors.New("Pipe is idle")
+
+// idleWatch closes the pipe that moves no data, see SetIdleTimeout
+type idleWatch struct {
+	mu    sync.Mutex
+	b     *ringbuf
+	d     time.Duration
+	timer *time.Timer
+	pos   uint64 // rpos+wpos seen last
+	since time.Time
+}
+
+// SetIdleTimeout closes the pipe with ErrIdle once no bytes are written or
+// read for d, so the pipes of abandoned sessions don't leak. The readers get
+// ErrIdle after the buffered data, the writers at once. The pipe is watched
+// every quarter of d, so it is closed up to d/4 later. The watch ends with
+// the close of the pipe, non-positive d stops it
+func (b *ringbuf) SetIdleTimeout(d time.Duration) {
+	if b.idle != nil {
+		b.idle.stop()
+		b.idle = nil
+	}
+	if d <= 0 {
+		return
+	}
+	w := &idleWatch{b: b, d: d, pos: b.moved(), since: time.Now()}
+	w.mu.Lock()
+	w.timer = time.AfterFunc(w.period(), w.check)
+	w.mu.Unlock()
+	b.idle = w
+}
+
+func (w *idleWatch) period() time.Duration {
+	if p := w.d / 4; p > 0 {
+		return p
+	}
+	return w.d
+}
+
+func (w *idleWatch) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer == nil || w.b.IsClosed() {
+		return
+	}
+	now := time.Now()
+	if pos := w.b.moved(); pos != w.pos {
+		w.pos, w.since = pos, now
+	} else if now.Sub(w.since) >= w.d {
+		w.b.closeWithError(ErrIdle)
+		return
+	}
+	w.timer.Reset(w.period())
+}
+
+func (w *idleWatch) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// moved returns the number of bytes written and read
+func (b *ringbuf) moved() uint64 {
+	return atomic.LoadUint64(&b.hdr.wpos) + atomic.LoadUint64(&b.hdr.rpos)
+}
@@ -0,0 +1,40 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	r, w := Pipe(16)
+	w.SetIdleTimeout(40 * time.Millisecond)
+	// the flowing data keeps the pipe open
+	buf := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		time.Sleep(15 * time.Millisecond)
+		w.Write([]byte{1})
+		r.Read(buf)
+	}
+	require.False(t, w.IsClosed())
+	w.Write([]byte{2})
+	start := time.Now()
+	_, err := w.Write(make([]byte, 16))
+	require.Equal(t, ErrIdle, err)
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, byte(2), buf[0])
+	_, err = r.Read(make([]byte, 15))
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.Equal(t, ErrIdle, err)
+
+	// the watch is stopped
+	r, w = Pipe(16)
+	r.SetIdleTimeout(10 * time.Millisecond)
+	r.SetIdleTimeout(0)
+	time.Sleep(30 * time.Millisecond)
+	require.False(t, r.IsClosed())
+}
@@ -0,0 +1,107 @@
+package pipe
+
+import (
+	"io"
+	"sync"
+)
+
+// ioPipeState keeps the errors of the ends of IOPipe, the first one of each
+// end wins
+type ioPipeState struct {
+	mu   sync.Mutex
+	rerr error // the reader's close error, returned to the writer
+	werr bool  // the writer is closed
+}
+
+// IOPipeReader is the read end of IOPipe with the methods of io.PipeReader
+type IOPipeReader struct {
+	r *Reader
+	s *ioPipeState
+}
+
+// IOPipeWriter is the write end of IOPipe with the methods of io.PipeWriter
+type IOPipeWriter struct {
+	w *Writer
+	s *ioPipeState
+}
+
+// IOPipe is the drop-in replacement for io.Pipe with the buffer of the
+// default size, the writes return once the data is buffered. The errors are
+// those of io.Pipe, except that the reader gets the writer's close error
+// after the buffered data
+func IOPipe() (*IOPipeReader, *IOPipeWriter) {
+	return IOPipeSize(0)
+}
+
+// IOPipeSize is IOPipe with the buffer of at least max bytes
+func IOPipeSize(max int) (*IOPipeReader, *IOPipeWriter) {
+	r, w := SyncPipe(max)
+	s := &ioPipeState{}
+	return &IOPipeReader{r: r, s: s}, &IOPipeWriter{w: w, s: s}
+}
+
+// Read reads the buffered data or waits for the writer. Once the writer is
+// closed and the data is drained it returns the writer's close error, after
+// the reader's own close io.ErrClosedPipe
+func (p *IOPipeReader) Read(data []byte) (int, error) {
+	n, err := p.r.Read(data)
+	if err == ErrReadClosed {
+		err = io.ErrClosedPipe
+	}
+	return n, err
+}
+
+// Close closes the reader, the writes fail with io.ErrClosedPipe
+func (p *IOPipeReader) Close() error {
+	return p.CloseWithError(nil)
+}
+
+// CloseWithError closes the reader, the writes fail with err, nil err
+// means io.ErrClosedPipe. It never overwrites the previous error and
+// always returns nil
+func (p *IOPipeReader) CloseWithError(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	p.s.mu.Lock()
+	if p.s.rerr == nil {
+		p.s.rerr = err
+	}
+	p.s.mu.Unlock()
+	p.r.CloseRead()
+	return nil
+}
+
+// Write writes data into the buffer, waiting for space while it is full.
+// It fails with the reader's close error, after the writer's own close
+// with io.ErrClosedPipe
+func (p *IOPipeWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	if err != nil {
+		p.s.mu.Lock()
+		switch {
+		case p.s.rerr != nil:
+			err = p.s.rerr
+		case p.s.werr:
+			err = io.ErrClosedPipe
+		}
+		p.s.mu.Unlock()
+	}
+	return n, err
+}
+
+// Close closes the writer, the reader gets io.EOF after the buffered data
+func (p *IOPipeWriter) Close() error {
+	return p.CloseWithError(nil)
+}
+
+// CloseWithError closes the writer, the reader gets err after the buffered
+// data, nil err means io.EOF. It never overwrites the previous error and
+// always returns nil
+func (p *IOPipeWriter) CloseWithError(err error) error {
+	p.s.mu.Lock()
+	p.s.werr = true
+	p.s.mu.Unlock()
+	p.w.CloseWithError(err)
+	return nil
+}
@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIOPipe(t *testing.T) {
+	r, w := IOPipe()
+	n, err := w.Write([]byte("hello")) // buffered, no reader needed
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	w.CloseWithError(io.ErrUnexpectedEOF)
+	w.Close() // the first error is kept
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, io.ErrClosedPipe, err)
+	data, err := io.ReadAll(r)
+	require.Equal(t, "hello", string(data))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	r, w = IOPipeSize(8)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 100))
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	myErr := errors.New("gone")
+	r.CloseWithError(myErr)
+	r.Close()
+	require.Equal(t, myErr, <-errc)
+	_, err = r.Read(make([]byte, 1))
+	require.Equal(t, io.ErrClosedPipe, err)
+
+	r, w = IOPipe()
+	r.Close()
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, io.ErrClosedPipe, err)
+}
@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// JSONWriter writes values as JSON lines
+type JSONWriter struct {
+	w     *Writer
+	max   int
+	reuse bool
+	mu    sync.Mutex // serializes WriteJSON
+	buf   bytes.Buffer
+}
+
+// NewJSONWriter creates the writer of JSON lines of up to max bytes to w.
+// Non-positive max means no limit. With reuse the encoding buffer is kept
+// across messages, otherwise it's released after each of them
+func NewJSONWriter(w *Writer, max int, reuse bool) *JSONWriter {
+	return &JSONWriter{w: w, max: maxFrameSize(max), reuse: reuse}
+}
+
+// WriteJSON writes v as one line. It fails with *FrameSizeError if the line
+// exceeds the maximum size, nothing is written then. It may be called
+// concurrently
+func (j *JSONWriter) WriteJSON(v interface{}) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.reuse {
+		defer func() { j.buf = bytes.Buffer{} }()
+	}
+	j.buf.Reset()
+	if err := json.NewEncoder(&j.buf).Encode(v); err != nil {
+		return err
+	}
+	if size := j.buf.Len() - 1; size > j.max {
+		return &FrameSizeError{Size: size, Max: j.max}
+	}
+	_, err := j.w.Write(j.buf.Bytes())
+	return err
+}
+
+// Close closes the pipe. The reader gets io.EOF once it reads the lines
+func (j *JSONWriter) Close() error {
+	return j.w.Close()
+}
+
+// JSONReader reads values written as JSON lines
+type JSONReader struct {
+	d     *DelimitedReader
+	reuse bool
+}
+
+// NewJSONReader creates the reader of JSON lines of up to max bytes from r,
+// max is limited by the buffer size. Lines are decoded straight from the ring
+// memory, the ones wrapped around its end are copied into the buffer that is
+// kept across messages with reuse
+func NewJSONReader(r *Reader, max int, reuse bool) *JSONReader {
+	return &JSONReader{d: NewDelimitedReader(r, []byte{'\n'}, max), reuse: reuse}
+}
+
+// ReadJSON reads the next line into v, empty lines are skipped. It must not
+// be called concurrently
+func (j *JSONReader) ReadJSON(v interface{}) error {
+	for {
+		line, err := j.d.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		err = json.Unmarshal(line, v)
+		if !j.reuse {
+			j.d.buf = nil
+		}
+		return err
+	}
+}
+
+// Close closes the read end
+func (j *JSONReader) Close() error {
+	return j.d.r.CloseRead()
+}
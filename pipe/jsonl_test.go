@@ -0,0 +1,33 @@
+package pipe
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONLines(t *testing.T) {
+	type event struct {
+		ID   int    `json:"id"`
+		Text string `json:"text"`
+	}
+	r, w := Pipe(64)
+	jw := NewJSONWriter(w, 40, true)
+	jr := NewJSONReader(r, 0, false)
+	require.Equal(t, &FrameSizeError{Size: 41, Max: 40}, jw.WriteJSON(event{Text: strings.Repeat("x", 23)}))
+	go func() {
+		for i := 0; i < 100; i++ {
+			jw.WriteJSON(event{i, "line\n" + strings.Repeat("x", i%10)})
+		}
+		jw.Close()
+	}()
+	for i := 0; i < 100; i++ {
+		var e event
+		require.NoError(t, jr.ReadJSON(&e))
+		require.Equal(t, event{i, "line\n" + strings.Repeat("x", i%10)}, e)
+	}
+	var e event
+	require.Equal(t, io.EOF, jr.ReadJSON(&e))
+}
@@ -0,0 +1,215 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrPeerLost = errors.New("Peer missed keepalive beats")
+
+const keepaliveEnd = 1<<32 - 1 // the length of the frame sent by CloseWrite
+
+// KeepaliveConn is the connection that sends the heartbeat frames to the
+// peer and strips those of the peer, see Keepalive
+type KeepaliveConn struct {
+	c        io.ReadWriteCloser
+	interval time.Duration
+	missed   int
+
+	mu      sync.Mutex // serializes the frames sent
+	hdr     [frameHeaderSize]byte
+	halfway bool // CloseWrite is called, the beats are stopped
+
+	rhdr    [frameHeaderSize]byte
+	rest    int   // unread bytes of the received frame
+	eof     bool  // the end frame is received
+	reading int32 // the Read waits for the peer
+	seen    int64 // unix nanoseconds of the last receive or Read start
+	lost    int32
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Keepalive wraps the connection to the peer that wraps its end as well.
+// The data is sent in frames with 4-byte big-endian length prefix. The
+// empty frame is the heartbeat sent every interval while the connection is
+// idle, the frame of length 0xffffffff ends the data. The reads of the peer that sent nothing, not even the heartbeat,
+// for missed intervals fail with ErrPeerLost and the connection is closed,
+// so the half-open connection doesn't hang the reader. The Read that isn't
+// called doesn't detect, so the slow consumer doesn't lose the peer. The
+// result is passed to Bridge or an adapter, e.g.
+//
+//	c, err := net.DialUnix("unix", nil, addr)
+//	d := Bridge(Keepalive(c, time.Second, 3), 0)
+func Keepalive(c io.ReadWriteCloser, interval time.Duration, missed int) *KeepaliveConn {
+	if missed < 1 {
+		missed = 1
+	}
+	k := &KeepaliveConn{c: c, interval: interval, missed: missed, stop: make(chan struct{})}
+	atomic.StoreInt64(&k.seen, time.Now().UnixNano())
+	go k.beat()
+	return k
+}
+
+// beat sends the heartbeats and watches the peer
+func (k *KeepaliveConn) beat() {
+	t := time.NewTicker(k.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case now := <-t.C:
+			if atomic.LoadInt32(&k.reading) != 0 &&
+				now.Sub(time.Unix(0, atomic.LoadInt64(&k.seen))) > time.Duration(k.missed)*k.interval {
+				atomic.StoreInt32(&k.lost, 1)
+				k.Close()
+				return
+			}
+			// the frame being sent is as good as the heartbeat
+			if k.mu.TryLock() {
+				if !k.halfway {
+					binary.BigEndian.PutUint32(k.hdr[:], 0)
+					k.c.Write(k.hdr[:])
+				}
+				k.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Read reads the data of the frames sent by the peer
+func (k *KeepaliveConn) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if k.eof {
+		return 0, io.EOF
+	}
+	atomic.StoreInt64(&k.seen, time.Now().UnixNano())
+	atomic.StoreInt32(&k.reading, 1)
+	defer atomic.StoreInt32(&k.reading, 0)
+	for k.rest == 0 {
+		if _, err := io.ReadFull(k.c, k.rhdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, k.err(err)
+		}
+		atomic.StoreInt64(&k.seen, time.Now().UnixNano())
+		size := binary.BigEndian.Uint32(k.rhdr[:])
+		if size == keepaliveEnd {
+			k.eof = true
+			return 0, io.EOF
+		}
+		k.rest = int(size)
+	}
+	n, err := k.c.Read(data[:minInt(len(data), k.rest)])
+	k.rest -= n
+	if n > 0 {
+		atomic.StoreInt64(&k.seen, time.Now().UnixNano())
+	}
+	if err == io.EOF && k.rest > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		err = k.err(err)
+	}
+	return n, err
+}
+
+// Write sends data as one frame
+func (k *KeepaliveConn) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	binary.BigEndian.PutUint32(k.hdr[:], uint32(len(data)))
+	bufs := net.Buffers{k.hdr[:], data}
+	n, err := bufs.WriteTo(k.c)
+	if n -= frameHeaderSize; n < 0 {
+		n = 0
+	}
+	if err != nil {
+		err = k.err(err)
+	}
+	return int(n), err
+}
+
+// CloseWrite sends the end frame, so the peer reads io.EOF instead of
+// waiting for the heartbeats, stops the heartbeats and half-closes the
+// connection if it supports it by CloseWrite
+func (k *KeepaliveConn) CloseWrite() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.halfway {
+		return nil
+	}
+	k.halfway = true
+	binary.BigEndian.PutUint32(k.hdr[:], keepaliveEnd)
+	if _, err := k.c.Write(k.hdr[:]); err != nil {
+		return k.err(err)
+	}
+	if cw, ok := k.c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Close stops the heartbeats and closes the connection
+func (k *KeepaliveConn) Close() error {
+	err := net.ErrClosed
+	k.once.Do(func() {
+		close(k.stop)
+		err = k.c.Close()
+	})
+	return err
+}
+
+// err returns ErrPeerLost in place of the error of the connection closed
+// by the watch
+func (k *KeepaliveConn) err(err error) error {
+	if atomic.LoadInt32(&k.lost) != 0 {
+		return ErrPeerLost
+	}
+	return err
+}
+
+// keepaliveListener wraps the accepted connections by Keepalive
+type keepaliveListener struct {
+	net.Listener
+	interval time.Duration
+	missed   int
+}
+
+// keepaliveNetConn is KeepaliveConn of the net.Conn
+type keepaliveNetConn struct {
+	net.Conn
+	k *KeepaliveConn
+}
+
+func (c keepaliveNetConn) Read(data []byte) (int, error)  { return c.k.Read(data) }
+func (c keepaliveNetConn) Write(data []byte) (int, error) { return c.k.Write(data) }
+func (c keepaliveNetConn) CloseWrite() error              { return c.k.CloseWrite() }
+func (c keepaliveNetConn) Close() error                   { return c.k.Close() }
+
+// KeepaliveListener wraps the connections accepted by l as Keepalive does,
+// e.g. for Serve
+func KeepaliveListener(l net.Listener, interval time.Duration, missed int) net.Listener {
+	return &keepaliveListener{Listener: l, interval: interval, missed: missed}
+}
+
+func (l *keepaliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return keepaliveNetConn{Conn: c, k: Keepalive(c, l.interval, l.missed)}, nil
+}
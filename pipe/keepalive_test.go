@@ -0,0 +1,54 @@
+package pipe
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepalive(t *testing.T) {
+	c1, c2 := net.Pipe()
+	d1 := Bridge(Keepalive(c1, 5*time.Millisecond, 3), 64)
+	d2 := Bridge(Keepalive(c2, 5*time.Millisecond, 3), 64)
+	// the heartbeats keep the idle peers
+	time.Sleep(50 * time.Millisecond)
+	_, err := d1.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = d2.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+	d1.CloseWrite()
+	_, err = d2.Read(buf)
+	require.Equal(t, io.EOF, err)
+	d2.Close()
+	d1.Close()
+
+	// the silent peer is lost
+	c1, c2 = net.Pipe()
+	go io.Copy(io.Discard, c2)
+	d1 = Bridge(Keepalive(c1, 5*time.Millisecond, 3), 64)
+	start := time.Now()
+	_, err = d1.Read(buf)
+	require.Equal(t, ErrPeerLost, err)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, ErrPeerLost, d1.Wait())
+	c2.Close()
+
+	// so is the peer of the listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	lost := make(chan error, 1)
+	go Serve(KeepaliveListener(l, 5*time.Millisecond, 3), func(d *Duplex) {
+		_, err := d.Read(make([]byte, 1))
+		lost <- err
+	})
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, ErrPeerLost, <-lost)
+}
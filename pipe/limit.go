@@ -0,0 +1,92 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimit is the token bucket throttling the end of the pipe, a token is
+// a byte
+type rateLimit struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimit(bytesPerSec, burst int) *rateLimit {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		// 10ms worth of traffic
+		burst = bytesPerSec / 100
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &rateLimit{rate: float64(bytesPerSec), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// take takes up to n tokens. If fewer than min(n, burst) tokens are
+// available, it takes none and returns the time until they are
+func (l *rateLimit) take(n int) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	want := float64(n)
+	if want > l.burst {
+		want = l.burst
+	}
+	if l.tokens < want {
+		return 0, time.Duration((want - l.tokens) / l.rate * float64(time.Second))
+	}
+	if float64(n) > l.tokens {
+		n = int(l.tokens)
+	}
+	l.tokens -= float64(n)
+	return n, 0
+}
+
+// put returns n tokens taken but not used
+func (l *rateLimit) put(n int) {
+	if n > 0 {
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+	}
+}
+
+// throttle returns up to n bytes the limit lets through now. If it lets
+// none, it returns the channel that fires when it does
+func (b *ringbuf) throttle(n int) (int, <-chan time.Time) {
+	if b.limit == nil || n == 0 {
+		return n, nil
+	}
+	n, wait := b.limit.take(n)
+	if n == 0 {
+		return 0, time.After(wait)
+	}
+	return n, nil
+}
+
+// SetWriteLimit throttles the writer to bytesPerSec with bursts of up to
+// burst bytes, burst below 1 means 10ms worth of bytes. Writes wait for the
+// tokens as they wait for free space. Non-positive bytesPerSec removes the
+// limit. It must not be called concurrently with writes. The MPMC writer is
+// not throttled
+func (w *Writer) SetWriteLimit(bytesPerSec, burst int) {
+	w.limit = newRateLimit(bytesPerSec, burst)
+}
+
+// SetReadLimit throttles the reader as SetWriteLimit throttles the writer.
+// The MPMC reader is not throttled
+func (r *Reader) SetReadLimit(bytesPerSec, burst int) {
+	r.limit = newRateLimit(bytesPerSec, burst)
+}
@@ -0,0 +1,41 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	r, w := Pipe(1024)
+	w.SetWriteLimit(10000, 1000)
+	start := time.Now()
+	go func() {
+		w.Write(make([]byte, 3000))
+		w.ReadFrom(bytes.NewReader(make([]byte, 2000)))
+		w.Close()
+	}()
+	n, err := io.Copy(io.Discard, r)
+	require.NoError(t, err)
+	require.Equal(t, int64(5000), n)
+	elapsed := time.Since(start)
+	require.True(t, elapsed > 350*time.Millisecond && elapsed < 2*time.Second, elapsed)
+
+	r, w = Pipe(1024)
+	r.SetReadLimit(20000, 0)
+	start = time.Now()
+	go func() {
+		w.Write(make([]byte, 4000))
+		w.Close()
+	}()
+	n, err = io.Copy(io.Discard, r)
+	require.NoError(t, err)
+	require.Equal(t, int64(4000), n)
+	elapsed = time.Since(start)
+	require.True(t, elapsed > 150*time.Millisecond && elapsed < 2*time.Second, elapsed)
+	r.SetReadLimit(0, 0)
+	require.Nil(t, r.limit)
+}
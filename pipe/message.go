@@ -0,0 +1,120 @@
+package pipe
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// msgHeaderSize is the length of the big-endian message size stored before
+// each message in the ring
+const msgHeaderSize = 4
+
+// MessageReader is the read end of the message pipe
+type MessageReader struct {
+	r    *Reader
+	lock chan struct{} // serializes readers, a message is read under it
+	hdr  [msgHeaderSize]byte
+	size int // length of the next message, -1 until its header is read
+}
+
+// MessageWriter is the write end of the message pipe
+type MessageWriter struct {
+	w *Writer
+}
+
+// MessagePipe creates the pipe that keeps write boundaries: each Write is
+// one message and each Read returns exactly one message. The message and its
+// header must fit into the buffer of at least max bytes. Both ends may be
+// used by many goroutines
+func MessagePipe(max int) (*MessageReader, *MessageWriter) {
+	r, w := pipe(max, false, true)
+	return &MessageReader{r: r, lock: make(chan struct{}, 1), size: -1}, &MessageWriter{w: w}
+}
+
+func (m *MessageReader) Read(data []byte) (int, error) {
+	return m.ReadContext(context.Background(), data)
+}
+
+// ReadContext waits for the message and copies it into data. It fails with
+// io.ErrShortBuffer and leaves the message in the pipe if data is too short
+func (m *MessageReader) ReadContext(ctx context.Context, data []byte) (int, error) {
+	select {
+	case m.lock <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-m.lock }()
+	size, err := m.next(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < size {
+		return 0, io.ErrShortBuffer
+	}
+	// the message is published together with its header, so it never blocks
+	if err = m.r.ReadFullWithContext(ctx, data[:size]); err != nil {
+		return 0, err
+	}
+	m.size = -1
+	return size, nil
+}
+
+// NextLen waits for the next message and returns its length without reading it
+func (m *MessageReader) NextLen() (int, error) {
+	m.lock <- struct{}{}
+	defer func() { <-m.lock }()
+	return m.next(context.Background())
+}
+
+// next reads the header of the next message unless it has been read already
+func (m *MessageReader) next(ctx context.Context) (int, error) {
+	if m.size < 0 {
+		if err := m.r.ReadFullWithContext(ctx, m.hdr[:]); err != nil {
+			return 0, err
+		}
+		m.size = int(binary.BigEndian.Uint32(m.hdr[:]))
+	}
+	return m.size, nil
+}
+
+// SetReadDeadline sets the deadline for blocked and future reads
+func (m *MessageReader) SetReadDeadline(deadline time.Time) error {
+	return m.r.SetReadDeadline(deadline)
+}
+
+// Close closes the read end, the queued messages are discarded
+func (m *MessageReader) Close() error {
+	return m.r.CloseRead()
+}
+
+// Write writes data as one message. It fails with ErrOvercap if the message
+// with its header doesn't fit into the buffer
+func (m *MessageWriter) Write(data []byte) (int, error) {
+	if len(data)+msgHeaderSize > m.w.Cap() || uint64(len(data)) > 0xffffffff {
+		return 0, m.w.overcap(len(data))
+	}
+	var hdr [msgHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	if _, err := m.w.WriteBuffers(net.Buffers{hdr[:], data}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetWriteDeadline sets the deadline for blocked and future writes
+func (m *MessageWriter) SetWriteDeadline(deadline time.Time) error {
+	return m.w.SetWriteDeadline(deadline)
+}
+
+// Close closes the pipe. The reader gets io.EOF once the queued messages are read
+func (m *MessageWriter) Close() error {
+	return m.w.Close()
+}
+
+// CloseWithError closes the pipe. Reads return err after the queued messages
+func (m *MessageWriter) CloseWithError(err error) error {
+	return m.w.CloseWithError(err)
+}
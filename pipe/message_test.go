@@ -0,0 +1,36 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagePipe(t *testing.T) {
+	r, w := MessagePipe(64)
+	_, err := w.Write(make([]byte, 61))
+	require.Equal(t, ErrOvercap, err)
+	go func() {
+		for i := 0; i < 100; i++ {
+			w.Write(bytes.Repeat([]byte{byte(i)}, i%20))
+		}
+		w.Close()
+	}()
+	buf := make([]byte, 20)
+	for i := 0; i < 100; i++ {
+		if i%20 > 0 {
+			_, err = r.Read(buf[:i%20-1])
+			require.Equal(t, io.ErrShortBuffer, err)
+		}
+		l, err := r.NextLen()
+		require.NoError(t, err)
+		require.Equal(t, i%20, l)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, i%20), buf[:n])
+	}
+	_, err = r.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,255 @@
+package pipe
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var ErrBadMapping = errors.New("File is not a pipe mapping")
+
+const mmapMagic = 0x50495045 // "PIPE"
+const mmapVersion = 2
+
+// mmapPollInterval is how often the mapped pipe checks for the changes made
+// by other processes where it can't be woken by them
+const mmapPollInterval = time.Millisecond
+
+// mmapLayout is the start of the first page of the mapped file, the ring
+// memory starts at the second page
+type mmapLayout struct {
+	magic   uint32
+	version uint32
+	size    uint64 // ring memory size
+	_       [6]uint64
+	hdr     header
+	data    xsig // bumped when data is written or the pipe is closed
+	space   xsig // bumped when data is read or the pipe is closed
+}
+
+// xsig is the wakeup word in the shared memory. seq changes on every event,
+// waiters counts the processes that sleep on it
+type xsig struct {
+	seq     uint32
+	waiters uint32
+}
+
+// remote wakes the waiters in other processes sharing the mapped pipe
+type remote struct {
+	data, space     *xsig
+	dataEv, spaceEv event
+}
+
+func (x *remote) wake(s *xsig, ev event) {
+	atomic.AddUint32(&s.seq, 1)
+	if atomic.LoadUint32(&s.waiters) != 0 {
+		ev.wake(&s.seq)
+	}
+}
+
+func (x *remote) wakeData() {
+	x.wake(x.data, x.dataEv)
+}
+
+func (x *remote) wakeSpace() {
+	x.wake(x.space, x.spaceEv)
+}
+
+// MappedPipe is the pipe placed in the memory-mapped file, so cooperating
+// processes that map the same file share it. Each of them may use either end.
+// Waiters are woken across processes by futex on Linux and by named events on
+// Windows, other systems poll the shared memory
+type MappedPipe struct {
+	r    *Reader
+	w    *Writer
+	x    *remote
+	f    *os.File
+	m    []byte
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// MapFile maps the pipe in the file at path. The file is created with the
+// buffer of size bytes (rounded up to power of two) if it doesn't exist,
+// otherwise the layout of the existing file is verified and size is ignored.
+// The file appears at path initialized, so the processes that start
+// together map the same pipe
+func MapFile(path string, size int) (*MappedPipe, error) {
+	f, m, err := openMapping(path, size, initMapped)
+	if err != nil {
+		return nil, err
+	}
+	page := os.Getpagesize()
+	flen := len(m)
+	l := (*mmapLayout)(unsafe.Pointer(&m[0]))
+	if atomic.LoadUint32(&l.magic) != mmapMagic || l.version != mmapVersion || l.size != uint64(flen-page) {
+		munmap(m)
+		f.Close()
+		return nil, ErrBadMapping
+	}
+	x := &remote{data: &l.data, space: &l.space}
+	if x.dataEv, err = newEvent(path, "data"); err == nil {
+		if x.spaceEv, err = newEvent(path, "space"); err != nil {
+			x.dataEv.close()
+		}
+	}
+	if err != nil {
+		munmap(m)
+		f.Close()
+		return nil, err
+	}
+	r := &Reader{}
+	r.initWith(m[page:flen], false)
+	r.hdr = &l.hdr
+	r.remote = x
+	w := &Writer{}
+	w.initFrom(&r.ringbuf, false)
+	p := &MappedPipe{r: r, w: w, x: x, f: f, m: m, done: make(chan struct{})}
+	p.wg.Add(2)
+	// the changes made after return must reach the forwarders
+	go p.forward(x.data, x.dataEv, atomic.LoadUint32(&x.data.seq), r.wsig)
+	go p.forward(x.space, x.spaceEv, atomic.LoadUint32(&x.space.seq), r.rsig)
+	return p, nil
+}
+
+// Reader returns the read end
+func (p *MappedPipe) Reader() *Reader {
+	return p.r
+}
+
+// Writer returns the write end
+func (p *MappedPipe) Writer() *Writer {
+	return p.w
+}
+
+// Close unmaps the file. The pipe is left open for other processes, the ends
+// must not be used after Close
+func (p *MappedPipe) Close() error {
+	close(p.done)
+	// forwarders recheck done once seq changes
+	p.x.wakeData()
+	p.x.wakeSpace()
+	p.wg.Wait()
+	p.x.dataEv.close()
+	p.x.spaceEv.close()
+	err := munmap(p.m)
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openMapping maps the file at path, created by createMapping with init if
+// it doesn't exist
+func openMapping(path string, size int, init func(m []byte, size int) error) (*os.File, []byte, error) {
+	f, m, err := mapExisting(path)
+	if !os.IsNotExist(err) {
+		return f, m, err
+	}
+	if err = createMapping(path, size, init); err != nil && !os.IsExist(err) {
+		return nil, nil, err
+	}
+	// created by this or the other process
+	return mapExisting(path)
+}
+
+// mapExisting maps the existing file at path
+func mapExisting(path string) (*os.File, []byte, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var m []byte
+	st, err := f.Stat()
+	if err == nil && int(st.Size()) <= os.Getpagesize() {
+		err = ErrBadMapping
+	}
+	if err == nil {
+		m, err = mmap(f, int(st.Size()))
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, m, nil
+}
+
+// createMapping creates the file at path with the page of the layout
+// followed by the ring of size bytes (rounded up to power of two), the
+// layout is set by init. The file is initialized and synced under the
+// temporary name and linked to path, so nobody maps it half-made. It fails
+// with the error satisfying os.IsExist if the file exists
+func createMapping(path string, size int, init func(m []byte, size int) error) error {
+	page := os.Getpagesize()
+	size = bufferSize(size)
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var m []byte
+	if err = f.Truncate(int64(page + size)); err == nil {
+		m, err = mmap(f, page+size)
+	}
+	if err == nil {
+		err = init(m, size)
+		if uerr := munmap(m); err == nil {
+			err = uerr
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Link(f.Name(), path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+	}
+	return err
+}
+
+// initMapped sets the layout of the mapped pipe
+func initMapped(m []byte, size int) error {
+	l := (*mmapLayout)(unsafe.Pointer(&m[0]))
+	l.version = mmapVersion
+	l.size = uint64(size)
+	l.magic = mmapMagic
+	return nil
+}
+
+// forward sleeps on the wakeup word and passes its changes to c. While the
+// previous signal is not taken by the local waiter it doesn't sleep on the
+// word, so the other processes don't wake it while the local side is busy
+func (p *MappedPipe) forward(s *xsig, ev event, last uint32, c chan struct{}) {
+	defer p.wg.Done()
+	for {
+		atomic.AddUint32(&s.waiters, 1)
+		seq := atomic.LoadUint32(&s.seq)
+		if seq == last {
+			ev.wait(&s.seq, seq)
+			seq = atomic.LoadUint32(&s.seq)
+		}
+		atomic.AddUint32(&s.waiters, ^uint32(0))
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		if seq != last {
+			last = seq
+			select {
+			case c <- struct{}{}:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
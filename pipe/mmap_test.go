@@ -0,0 +1,107 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipe")
+	p1, err := MapFile(path, 100)
+	require.NoError(t, err)
+	defer p1.Close()
+	require.Equal(t, 128, p1.Writer().Cap())
+	// second mapping stands for the other process
+	p2, err := MapFile(path, 0)
+	require.NoError(t, err)
+	defer p2.Close()
+	require.Equal(t, 128, p2.Reader().Cap())
+
+	data := make([]byte, 1000)
+	rand.Read(data)
+	go func() {
+		p1.Writer().Write(data)
+		p1.Writer().Close()
+	}()
+	rdata, err := io.ReadAll(p2.Reader())
+	require.NoError(t, err)
+	require.Equal(t, data, rdata)
+
+	bad := filepath.Join(t.TempDir(), "bad")
+	require.NoError(t, os.WriteFile(bad, make([]byte, 2*os.Getpagesize()), 0600))
+	_, err = MapFile(bad, 0)
+	require.Equal(t, ErrBadMapping, err)
+}
+
+func TestMapFileTogether(t *testing.T) {
+	// the mappings starting together all get the same pipe
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pipe")
+	ps := make([]*MappedPipe, 8)
+	errs := make([]error, len(ps))
+	var wg sync.WaitGroup
+	for i := range ps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ps[i], errs[i] = MapFile(path, 64)
+		}(i)
+	}
+	wg.Wait()
+	for i, p := range ps {
+		require.NoError(t, errs[i])
+		require.Equal(t, 64, p.Reader().Cap())
+	}
+	ps[0].Writer().Write([]byte("hello"))
+	require.Equal(t, 5, ps[len(ps)-1].Reader().Buffered())
+	for _, p := range ps {
+		p.Close()
+	}
+	files, _ := os.ReadDir(dir)
+	require.Len(t, files, 1) // no temporary files left
+}
+
+func TestMapFileProcess(t *testing.T) {
+	if path := os.Getenv("PIPE_MAP_FILE"); path != "" {
+		// child process: echo the stream back in the second pipe
+		in, err := MapFile(path, 0)
+		require.NoError(t, err)
+		out, err := MapFile(path+".back", 0)
+		require.NoError(t, err)
+		_, err = io.Copy(out.Writer(), in.Reader())
+		require.NoError(t, err)
+		out.Writer().Close()
+		in.Close()
+		out.Close()
+		return
+	}
+	path := filepath.Join(t.TempDir(), "pipe")
+	in, err := MapFile(path, 1024)
+	require.NoError(t, err)
+	defer in.Close()
+	out, err := MapFile(path+".back", 1024)
+	require.NoError(t, err)
+	defer out.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMapFileProcess$")
+	cmd.Env = append(os.Environ(), "PIPE_MAP_FILE="+path)
+	require.NoError(t, cmd.Start())
+
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	go func() {
+		in.Writer().Write(data)
+		in.Writer().Close()
+	}()
+	rdata, err := io.ReadAll(out.Reader())
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, rdata))
+	require.NoError(t, cmd.Wait())
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package pipe
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func munmap(m []byte) error {
+	return syscall.Munmap(m)
+}
+
+// msync writes the changes of the mapped memory to the file
+func msync(m []byte) error {
+	_, _, e := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&m[0])), uintptr(len(m)), syscall.MS_SYNC)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build windows
+// +build windows
+
+package pipe
+
+import (
+	"os"
+	"reflect"
+	"syscall"
+	"unsafe"
+)
+
+func mmap(f *os.File, size int) ([]byte, error) {
+	h, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READWRITE, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := syscall.MapViewOfFile(h, syscall.FILE_MAP_WRITE, 0, 0, uintptr(size))
+	syscall.CloseHandle(h)
+	if err != nil {
+		return nil, err
+	}
+	var m []byte
+	sh := (*reflect.SliceHeader)(unsafe.Pointer(&m))
+	sh.Data = addr
+	sh.Len = size
+	sh.Cap = size
+	return m, nil
+}
+
+func munmap(m []byte) error {
+	return syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&m[0])))
+}
+
+// msync writes the changes of the mapped memory to the file
+func msync(m []byte) error {
+	return syscall.FlushViewOfFile(uintptr(unsafe.Pointer(&m[0])), uintptr(len(m)))
+}
@@ -0,0 +1,161 @@
+package pipe
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// mpmcState holds the reservation counters of the MPMC pipe. Writers reserve
+// regions past wres and fill them concurrently, then commit them to wpos in
+// reservation order. Readers reserve committed bytes past rres the same way
+// and release them to rpos in order
+type mpmcState struct {
+	wres   uint64
+	_      [7]uint64
+	rres   uint64
+	record int // longest write placed contiguously
+}
+
+// MPMCPipe creates the pipe for many writing and many reading goroutines
+// that don't serialize on the lock. Each Write of up to Cap bytes is placed
+// contiguously. Read returns as soon as some data is available and consumes
+// a contiguous part of the stream. The ends support Read, Write and their
+// variants, but not the methods that access the ring memory otherwise
+func MPMCPipe(max int) (*Reader, *Writer) {
+	r, w := pipe(max, false, false)
+	r.mpmc = &mpmcState{record: r.Cap()}
+	w.mpmc = r.mpmc
+	return r, w
+}
+
+// FanIn creates the pipe for many writing goroutines and one reader. Each
+// Write of up to maxRecord bytes reaches the reader contiguously, without
+// the writers serializing on the lock. Longer writes are split into
+// maxRecord parts. The read end is the regular one
+func FanIn(max, maxRecord int) (*Reader, *Writer) {
+	r, w := pipe(max, false, false)
+	if maxRecord <= 0 || maxRecord > w.Cap() {
+		maxRecord = w.Cap()
+	}
+	w.mpmc = &mpmcState{record: maxRecord}
+	return r, w
+}
+
+// writeReserved is writeUnlocked of the MPMC pipe
+func (w *Writer) writeReserved(ctx context.Context, data []byte) (int, error) {
+	if len(data) == 0 {
+		if w.IsClosed() {
+			return 0, w.writeErr()
+		}
+		return 0, nil
+	}
+	timeoutChan, exceed := w.timeoutChan()
+	if exceed {
+		return 0, timeoutError
+	}
+	written := 0
+	var parked parking
+	defer parked.leave()
+	for written < len(data) {
+		if w.IsClosed() {
+			return written, w.writeErr()
+		}
+		n := minInt(len(data)-written, w.mpmc.record)
+		res := atomic.LoadUint64(&w.mpmc.wres)
+		free := len(w.mem) - int(res-atomic.LoadUint64(&w.hdr.rpos))
+		if free >= n {
+			if !atomic.CompareAndSwapUint64(&w.mpmc.wres, res, res+uint64(n)) {
+				continue
+			}
+			if free > n {
+				notify(w.rsig) // resume other writers (if any)
+			}
+			w.copyAt(w.offset(res), data[written:written+n])
+			// commit after the writers that reserved before
+			for atomic.LoadUint64(&w.hdr.wpos) != res {
+				spin()
+			}
+			w.publish(n)
+			written += n
+			continue
+		}
+		if !w.park(&parked, &w.waiting.space) {
+			continue // check again before the first wait
+		}
+		start := w.waitStart()
+		select {
+		case <-w.rsig:
+		case <-timeoutChan:
+			if timeoutChan, exceed = w.timeoutChan(); exceed {
+				return written, timeoutError
+			}
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+		w.writeBlocked(ctx, start)
+	}
+	return written, nil
+}
+
+// readReserved is ReadContext of the MPMC pipe
+func (r *Reader) readReserved(ctx context.Context, data []byte) (int, error) {
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
+		return 0, timeoutError
+	}
+	var parked parking
+	defer parked.leave()
+	for {
+		flags := atomic.LoadUint64(&r.hdr.flags)
+		if (flags & readCloseFlag) != 0 {
+			return 0, ErrReadClosed
+		}
+		if (flags & abortFlag) != 0 {
+			return 0, r.closeErr()
+		}
+		res := atomic.LoadUint64(&r.mpmc.rres)
+		avail := int(atomic.LoadUint64(&r.hdr.wpos) - res)
+		if avail > 0 {
+			n := minInt(avail, len(data))
+			if !atomic.CompareAndSwapUint64(&r.mpmc.rres, res, res+uint64(n)) {
+				continue
+			}
+			if avail > n {
+				notify(r.wsig) // resume other readers (if any)
+			}
+			pos := r.offset(res)
+			if pos > len(r.mem)-n {
+				// wrapped
+				ll := len(r.mem) - pos
+				copy(data[:ll], r.mem[pos:])
+				copy(data[ll:n], r.mem[:n-ll])
+			} else {
+				copy(data, r.mem[pos:pos+n])
+			}
+			// release after the readers that reserved before
+			for atomic.LoadUint64(&r.hdr.rpos) != res {
+				spin()
+			}
+			r.advance(n)
+			return n, nil
+		}
+		if (flags & closeFlag) != 0 {
+			notify(r.wsig) // resume other readers (if any)
+			return 0, r.closeErr()
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return 0, timeoutError
+			}
+		}
+		r.readBlocked(ctx, start)
+	}
+}
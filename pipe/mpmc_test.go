@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMPMCPipe(t *testing.T) {
+	const writers, readers, records = 4, 4, 20000
+	r, w := MPMCPipe(256)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			var b [5 * 8]byte
+			for seq := 0; seq < records; {
+				n := minInt(rand.Intn(5)+1, records-seq)
+				for j := 0; j < n; j++ {
+					binary.LittleEndian.PutUint32(b[j*8:], uint32(id))
+					binary.LittleEndian.PutUint32(b[j*8+4:], uint32(seq))
+					seq++
+				}
+				w.Write(b[:n*8])
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+	got := make(chan [writers]int, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			var counts [writers]int
+			last := [writers]int{-1, -1, -1, -1}
+			var b [3 * 8]byte
+			for {
+				n, err := r.Read(b[:])
+				for j := 0; j < n; j += 8 {
+					id := binary.LittleEndian.Uint32(b[j:])
+					seq := int(binary.LittleEndian.Uint32(b[j+4:]))
+					if seq <= last[id] {
+						panic("records out of order")
+					}
+					last[id] = seq
+					counts[id]++
+				}
+				if err != nil {
+					break
+				}
+			}
+			got <- counts
+		}()
+	}
+	var total [writers]int
+	for i := 0; i < readers; i++ {
+		counts := <-got
+		for j := range total {
+			total[j] += counts[j]
+		}
+	}
+	require.Equal(t, [writers]int{records, records, records, records}, total)
+}
+
+func TestFanIn(t *testing.T) {
+	const writers, records = 8, 5000
+	r, w := FanIn(1024, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id byte) {
+			defer wg.Done()
+			b := make([]byte, 100)
+			for j := 0; j < records; j++ {
+				n := rand.Intn(99) + 2
+				b[0] = byte(n)
+				for k := 1; k < n; k++ {
+					b[k] = id
+				}
+				w.Write(b[:n])
+			}
+		}(byte(i))
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+	var counts [writers]int
+	b := make([]byte, 100)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		require.NoError(t, r.ReadFull(b[:c-1]))
+		for _, id := range b[1 : c-1] {
+			require.Equal(t, b[0], id)
+		}
+		counts[b[0]]++
+	}
+	for _, n := range counts {
+		require.Equal(t, records, n)
+	}
+}
@@ -0,0 +1,212 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// MultiPipeWriter writes to many pipes, the policy tells what to do with
+// the pipe that has no space for the write
+type MultiPipeWriter struct {
+	mu      sync.Mutex
+	ws      []*Writer
+	policy  OverrunPolicy
+	skipped int64
+	err     error // the error of the last removed pipe
+}
+
+// MultiWriter creates the writer duplicating the writes to the pipes. The
+// pipe closed by its reader is removed, the writes fail once no pipe is
+// left. With BlockWriter the write waits for the slowest pipe, with
+// DropSubscriber the full pipe is closed with ErrDropped and removed, with
+// SkipData the full pipe misses the write
+func MultiWriter(policy OverrunPolicy, pipes ...*Writer) *MultiPipeWriter {
+	return &MultiPipeWriter{ws: append([]*Writer(nil), pipes...), policy: policy}
+}
+
+func (m *MultiPipeWriter) Write(data []byte) (int, error) {
+	return m.WriteContext(context.Background(), data)
+}
+
+// WriteContext writes data to every pipe, each pipe gets the whole data or
+// nothing of it: with BlockWriter the space for data is awaited before it is
+// written, so the write cancelled by ctx leaves the pipe it waits for
+// untouched. The data exceeding the capacity of the pipe is the exception,
+// it is written in parts and the cancel may cut it. It may be called
+// concurrently
+func (m *MultiPipeWriter) WriteContext(ctx context.Context, data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	live := m.ws[:0]
+	for i, w := range m.ws {
+		if m.policy != BlockWriter && w.Free() < len(data) && !w.IsClosed() {
+			if m.policy == DropSubscriber {
+				w.CloseWithError(ErrDropped)
+				m.err = ErrDropped
+				continue
+			}
+			m.skipped++
+			live = append(live, w)
+			continue
+		}
+		err := m.reserve(ctx, w, len(data))
+		if err == nil {
+			_, err = w.WriteContext(ctx, data)
+		}
+		if err != nil {
+			if !w.IsClosed() {
+				// e.g. cancelled, the pipe stays
+				live = append(live, m.ws[i:]...)
+				m.ws = live
+				return 0, err
+			}
+			m.err = err
+			continue
+		}
+		live = append(live, w)
+	}
+	m.ws = live
+	if len(live) == 0 {
+		return 0, m.err
+	}
+	return len(data), nil
+}
+
+// reserve waits for n bytes of space in the pipe, unless they exceed its
+// capacity
+func (m *MultiPipeWriter) reserve(ctx context.Context, w *Writer, n int) error {
+	if m.policy != BlockWriter || n > w.Cap() {
+		return nil
+	}
+	return w.WriteWaitWithContext(ctx, n)
+}
+
+// Len returns number of pipes left
+func (m *MultiPipeWriter) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ws)
+}
+
+// Skipped returns number of writes missed by the full pipes with SkipData
+func (m *MultiPipeWriter) Skipped() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.skipped
+}
+
+// Close closes all pipes
+func (m *MultiPipeWriter) Close() error {
+	return m.CloseWithError(nil)
+}
+
+// CloseWithError closes all pipes with err
+func (m *MultiPipeWriter) CloseWithError(err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.ws {
+		w.CloseWithError(err)
+	}
+	m.ws = nil
+	return nil
+}
+
+// MultiPipeReader reads from many pipes whichever has data
+type MultiPipeReader struct {
+	mu   sync.Mutex
+	rs   []*Reader
+	next int // the pipe to try first, for fairness
+}
+
+// MultiReader creates the reader merging the pipes: each read returns the
+// data of the pipe that has it, so the stuck pipe doesn't hold the others.
+// The pipe closed by its writer is removed once drained, its close error
+// other than io.EOF is returned once. The reads return io.EOF when no pipe
+// is left. The reads of the pipes are not interleaved within one read
+func MultiReader(pipes ...*Reader) *MultiPipeReader {
+	return &MultiPipeReader{rs: append([]*Reader(nil), pipes...)}
+}
+
+func (m *MultiPipeReader) Read(data []byte) (int, error) {
+	return m.ReadContext(context.Background(), data)
+}
+
+// ReadContext waits for data in any pipe and reads it. It may be called
+// concurrently
+func (m *MultiPipeReader) ReadContext(ctx context.Context, data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var cases []reflect.SelectCase
+	var parked []parking
+	defer func() {
+		for i := range parked {
+			parked[i].leave()
+		}
+	}()
+	for {
+		if len(m.rs) == 0 {
+			return 0, io.EOF
+		}
+		for k := 0; k < len(m.rs); k++ {
+			i := (m.next + k) % len(m.rs)
+			r := m.rs[i]
+			closed, sz := r.loadAvail()
+			if sz == 0 && !closed {
+				continue
+			}
+			if closed {
+				sz++ // past the data to get the close error
+			}
+			n, err := r.ReadContext(ctx, data[:minInt(len(data), sz)])
+			m.next = i + 1
+			if err != nil && r.IsClosed() && r.Buffered() == 0 {
+				m.rs = append(m.rs[:i:i], m.rs[i+1:]...)
+				if err == io.EOF {
+					err = nil
+				}
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+		}
+		if len(m.rs) == 0 {
+			continue
+		}
+		if parked == nil {
+			parked = make([]parking, len(m.rs))
+			for i, r := range m.rs {
+				parked[i].enter(&r.waiting.data)
+			}
+			continue // check again before the first wait
+		}
+		// wait for the signal of any pipe
+		cases = cases[:0]
+		for _, r := range m.rs {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.wsig)})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		if i, _, _ := reflect.Select(cases); i == len(m.rs) {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Len returns number of pipes left
+func (m *MultiPipeReader) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rs)
+}
+
+// Close closes the read ends of all pipes
+func (m *MultiPipeReader) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rs {
+		r.CloseRead()
+	}
+	m.rs = nil
+	return nil
+}
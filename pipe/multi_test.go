@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiPipe(t *testing.T) {
+	r1, w1 := Pipe(16)
+	r2, w2 := Pipe(16)
+	mw := MultiWriter(SkipData, w1, w2)
+	_, err := mw.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := r1.Read(buf[:10])
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf[:n]))
+	// the second pipe is full
+	_, err = mw.Write([]byte("abcdefghij"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), mw.Skipped())
+	n, err = r2.Read(buf[:10])
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf[:n]))
+	n, err = r1.Read(buf[:10])
+	require.NoError(t, err)
+	require.Equal(t, "abcdefghij", string(buf[:n]))
+
+	// closed pipes are removed
+	r1.CloseRead()
+	_, err = mw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, 1, mw.Len())
+	r2.CloseRead()
+	_, err = mw.Write([]byte("x"))
+	require.Equal(t, ErrReadClosed, err)
+
+	r1, w1 = Pipe(16)
+	r2, w2 = Pipe(16)
+	mw = MultiWriter(DropSubscriber, w1, w2)
+	_, err = w2.Write(make([]byte, 10))
+	require.NoError(t, err)
+	_, err = mw.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, 1, mw.Len())
+	_, err = io.ReadAll(r2)
+	require.Equal(t, ErrDropped, err)
+
+	// the stuck pipe doesn't hold the others
+	r3, w3 := Pipe(16)
+	mr := MultiReader(r3, r1)
+	n, err = mr.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf[:n]))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w3.Write([]byte("late"))
+		w3.Close()
+	}()
+	n, err = mr.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "late", string(buf[:n]))
+	mw.Close()
+	_, err = mr.Read(buf)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 0, mr.Len())
+}
+
+func TestMultiPipeCancel(t *testing.T) {
+	r1, w1 := Pipe(16)
+	r2, w2 := Pipe(16)
+	mw := MultiWriter(BlockWriter, w1, w2)
+	w2.Write(make([]byte, 10))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := mw.WriteContext(ctx, []byte("0123456789"))
+	require.Equal(t, context.DeadlineExceeded, err)
+	// the pipe it waited for got nothing of the data
+	require.Equal(t, 10, r1.Buffered())
+	require.Equal(t, 10, r2.Buffered())
+	require.Equal(t, 2, mw.Len())
+}
@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+// nagle defers the wakeups of the reader, see SetNagle
+type nagle struct {
+	mu      sync.Mutex
+	n       int
+	delay   time.Duration
+	wsig    chan struct{}
+	waiting *waiting
+	timer   *time.Timer
+	pending bool // the wakeup is deferred
+}
+
+// SetNagle defers the wakeup of the reader until at least n bytes are
+// buffered or maxDelay passes since the first deferred write, which cuts
+// the signals to the reader of the producer writing tiny chunks. The data
+// is visible to the reader at once, only the blocked reader waits. Flush
+// wakes it before the time, the writer waiting for space does too. n below
+// 2 or non-positive maxDelay removes the option. It must not be called
+// concurrently with writes
+func (w *Writer) SetNagle(n int, maxDelay time.Duration) {
+	if w.nagle != nil {
+		w.nagle.flush()
+	}
+	if n < 2 || maxDelay <= 0 {
+		w.nagle = nil
+		return
+	}
+	w.nagle = &nagle{n: minInt(n, w.maxCap()), delay: maxDelay, wsig: w.wsig, waiting: w.waiting}
+}
+
+// hold reports whether the wakeup for sz buffered bytes is deferred
+func (g *nagle) hold(sz int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if sz >= g.n {
+		g.stop()
+		return false
+	}
+	if !g.pending {
+		g.pending = true
+		if g.timer == nil {
+			g.timer = time.AfterFunc(g.delay, g.flush)
+		} else {
+			g.timer.Reset(g.delay)
+		}
+	}
+	return true
+}
+
+// flush wakes the reader if the wakeup is deferred
+func (g *nagle) flush() {
+	g.mu.Lock()
+	pending := g.pending
+	g.stop()
+	g.mu.Unlock()
+	if pending {
+		notify(g.wsig)
+		g.waiting.wakeClones()
+		if g.waiting.cond != nil {
+			g.waiting.cond.data.wake()
+		}
+	}
+}
+
+func (g *nagle) stop() {
+	if g.pending {
+		g.pending = false
+		g.timer.Stop()
+	}
+}
@@ -0,0 +1,42 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNagle(t *testing.T) {
+	r, w := Pipe(64)
+	w.SetNagle(8, 50*time.Millisecond)
+	wait := func(write func()) (string, time.Duration) {
+		got := make(chan string)
+		go func() {
+			b, _ := r.AcquireRead()
+			s := string(b)
+			r.ReleaseRead(len(b))
+			got <- s
+		}()
+		time.Sleep(10 * time.Millisecond)
+		start := time.Now()
+		write()
+		s := <-got
+		return s, time.Since(start)
+	}
+	// the wakeup is deferred below the threshold
+	s, d := wait(func() { w.Write([]byte("ab")) })
+	require.Equal(t, "ab", s)
+	require.GreaterOrEqual(t, d, 40*time.Millisecond)
+	// not at it
+	s, d = wait(func() { w.Write([]byte("abcdefgh")) })
+	require.Equal(t, "abcdefgh", s)
+	require.Less(t, d, 40*time.Millisecond)
+	// flushed
+	s, d = wait(func() {
+		w.Write([]byte("ab"))
+		require.NoError(t, w.Flush())
+	})
+	require.Equal(t, "ab", s)
+	require.Less(t, d, 40*time.Millisecond)
+}
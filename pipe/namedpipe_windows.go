@@ -0,0 +1,347 @@
+//go:build windows
+// +build windows
+
+package pipe
+
+import (
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateNamedPipeW        = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe        = kernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipeW          = kernel32.NewProc("WaitNamedPipeW")
+	procSetNamedPipeHandleState = kernel32.NewProc("SetNamedPipeHandleState")
+	procGetOverlappedResult     = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex       = 0x3
+	pipeTypeMessage        = 0x4
+	pipeReadmodeMessage    = 0x2
+	pipeUnlimitedInstances = 255
+	errorPipeBusy          = syscall.Errno(231)
+	errorNoData            = syscall.Errno(232)
+	errorPipeNotConnected  = syscall.Errno(233)
+	errorPipeConnected     = syscall.Errno(535)
+	nmpwaitWaitForever     = 0xffffffff
+)
+
+// overlapped is the pending operation on the handle opened with
+// FILE_FLAG_OVERLAPPED, completed by its own event, so the reads and the
+// writes of the handle run concurrently
+type overlapped struct {
+	o syscall.Overlapped
+}
+
+func newOverlapped() (*overlapped, error) {
+	// manual reset, the operation resets it when it starts
+	h, _, e := procCreateEventW.Call(0, 1, 0, 0)
+	if h == 0 {
+		return nil, e
+	}
+	return &overlapped{syscall.Overlapped{HEvent: syscall.Handle(h)}}, nil
+}
+
+// wait waits for the operation started with the err to complete
+func (o *overlapped) wait(h syscall.Handle, n uint32, err error) (uint32, error) {
+	if err != syscall.ERROR_IO_PENDING {
+		return n, err
+	}
+	if ok, _, e := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(&o.o)), uintptr(unsafe.Pointer(&n)), 1); ok == 0 {
+		return n, e
+	}
+	return n, nil
+}
+
+func (o *overlapped) close() {
+	syscall.CloseHandle(o.o.HEvent)
+}
+
+// namedPipeConn is the end of the named pipe in the message mode. The
+// pending operations are cancelled by Close, the zero-length message sent
+// by CloseWrite is the end of the stream for the peer
+type namedPipeConn struct {
+	h syscall.Handle
+
+	rmu sync.Mutex
+	ro  *overlapped
+	eof bool
+
+	wmu    sync.Mutex
+	wo     *overlapped
+	closew bool
+
+	mu      sync.Mutex
+	pending sync.WaitGroup
+	closed  bool
+}
+
+func newNamedPipeConn(h syscall.Handle) (*namedPipeConn, error) {
+	ro, err := newOverlapped()
+	if err != nil {
+		return nil, err
+	}
+	wo, err := newOverlapped()
+	if err != nil {
+		ro.close()
+		return nil, err
+	}
+	return &namedPipeConn{h: h, ro: ro, wo: wo}, nil
+}
+
+// start starts the operation unless the pipe is closed, Close cancels only
+// the started ones
+func (c *namedPipeConn) start(o *overlapped, op func(n *uint32, o *syscall.Overlapped) error) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	c.pending.Add(1)
+	var n uint32
+	err := op(&n, &o.o)
+	c.mu.Unlock()
+	n, err = o.wait(c.h, n, err)
+	c.pending.Done()
+	if err == syscall.ERROR_OPERATION_ABORTED {
+		err = net.ErrClosed
+	}
+	return int(n), err
+}
+
+func (c *namedPipeConn) Read(data []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.eof {
+		return 0, io.EOF
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	n, err := c.start(c.ro, func(n *uint32, o *syscall.Overlapped) error {
+		return syscall.ReadFile(c.h, data, n, o)
+	})
+	switch {
+	case err == syscall.ERROR_MORE_DATA:
+		// the rest of the message is read next
+		return n, nil
+	case err == syscall.ERROR_BROKEN_PIPE || err == errorPipeNotConnected:
+		// the peer closed its handle
+		c.eof = true
+		return n, io.EOF
+	case err == nil && n == 0:
+		// the zero-length message of CloseWrite
+		c.eof = true
+		return 0, io.EOF
+	}
+	return n, err
+}
+
+func (c *namedPipeConn) Write(data []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closew {
+		return 0, io.ErrClosedPipe
+	}
+	if len(data) == 0 {
+		// it would be read as the end of the stream
+		return 0, nil
+	}
+	n, err := c.start(c.wo, func(n *uint32, o *syscall.Overlapped) error {
+		return syscall.WriteFile(c.h, data, n, o)
+	})
+	if err == syscall.ERROR_BROKEN_PIPE || err == errorNoData {
+		err = io.ErrClosedPipe
+	}
+	return n, err
+}
+
+// CloseWrite sends the zero-length message, the peer reads io.EOF after the
+// data written before
+func (c *namedPipeConn) CloseWrite() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closew {
+		return nil
+	}
+	c.closew = true
+	_, err := c.start(c.wo, func(n *uint32, o *syscall.Overlapped) error {
+		return syscall.WriteFile(c.h, []byte{}, n, o)
+	})
+	return err
+}
+
+// Close cancels the pending operations and closes the handle, the data
+// written before stays readable by the peer
+func (c *namedPipeConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	syscall.CancelIoEx(c.h, nil)
+	c.mu.Unlock()
+	c.pending.Wait()
+	c.ro.close()
+	c.wo.close()
+	return syscall.CloseHandle(c.h)
+}
+
+// NamedPipeServer accepts the clients of the Windows named pipe, e.g.
+// `\\.\pipe\name`, each of them as the Duplex
+type NamedPipeServer struct {
+	name    string
+	bufSize int
+
+	amu sync.Mutex // one Accept at a time, they share the instance
+
+	mu        sync.Mutex
+	next      syscall.Handle // the instance waiting for the client
+	accepting bool
+	closed    bool
+}
+
+// ListenNamedPipe creates the named pipe, the conversations are buffered by
+// the pipes of bufSize bytes
+func ListenNamedPipe(name string, bufSize int) (*NamedPipeServer, error) {
+	h, err := createNamedPipe(name)
+	if err != nil {
+		return nil, err
+	}
+	return &NamedPipeServer{name: name, bufSize: bufSize, next: h}, nil
+}
+
+func createNamedPipe(name string) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	h, _, e := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(p)), pipeAccessDuplex|syscall.FILE_FLAG_OVERLAPPED,
+		pipeTypeMessage|pipeReadmodeMessage, pipeUnlimitedInstances, defaultBufferSize, defaultBufferSize, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, e
+	}
+	return syscall.Handle(h), nil
+}
+
+// Accept waits for the client and returns the conversation with it. It
+// fails with net.ErrClosed after Close
+func (s *NamedPipeServer) Accept() (*Duplex, error) {
+	o, err := newOverlapped()
+	if err != nil {
+		return nil, err
+	}
+	defer o.close()
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, net.ErrClosed
+	}
+	h := s.next
+	s.accepting = true
+	// started under the lock, so Close cancels it
+	ok, _, e := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(&o.o)))
+	s.mu.Unlock()
+	if ok != 0 {
+		e = nil
+	}
+	if _, e = o.wait(h, 0, e); e == errorPipeConnected {
+		e = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accepting = false
+	if s.closed {
+		syscall.CloseHandle(h)
+		s.next = syscall.InvalidHandle
+		return nil, net.ErrClosed
+	}
+	if e != nil {
+		return nil, e
+	}
+	next, err := createNamedPipe(s.name)
+	if err != nil {
+		syscall.CloseHandle(h)
+		s.next = syscall.InvalidHandle
+		return nil, err
+	}
+	s.next = next
+	c, err := newNamedPipeConn(h)
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return Bridge(c, s.bufSize), nil
+}
+
+// Close stops accepting the clients, the accepted conversations stay
+func (s *NamedPipeServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.accepting {
+		// the blocked Accept closes the instance
+		syscall.CancelIoEx(s.next, nil)
+		return nil
+	}
+	if s.next != syscall.InvalidHandle {
+		syscall.CloseHandle(s.next)
+		s.next = syscall.InvalidHandle
+	}
+	return nil
+}
+
+func openNamedPipe(name string) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	for {
+		h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			// the zero-length message of CloseWrite is read as such
+			mode := uint32(pipeReadmodeMessage)
+			if ok, _, e := procSetNamedPipeHandleState.Call(uintptr(h), uintptr(unsafe.Pointer(&mode)), 0, 0); ok == 0 {
+				syscall.CloseHandle(h)
+				return syscall.InvalidHandle, e
+			}
+			return h, nil
+		}
+		if err != errorPipeBusy {
+			return h, err
+		}
+		// all instances are taken, wait for the next one
+		if ok, _, e := procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(p)), nmpwaitWaitForever); ok == 0 {
+			return syscall.InvalidHandle, e
+		}
+	}
+}
+
+// DialNamedPipe connects to the named pipe server and returns the
+// conversation buffered by the pipes of bufSize bytes. The pipe is in the
+// message mode, CloseWrite of the Duplex sends the zero-length message the
+// peer reads as io.EOF
+func DialNamedPipe(name string, bufSize int) (*Duplex, error) {
+	h, err := openNamedPipe(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newNamedPipeConn(h)
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return Bridge(c, bufSize), nil
+}
@@ -0,0 +1,102 @@
+//go:build windows
+// +build windows
+
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPipeName(t *testing.T) string {
+	return fmt.Sprintf(`\\.\pipe\goal-%s-%d`, t.Name(), os.Getpid())
+}
+
+func TestNamedPipe(t *testing.T) {
+	name := testPipeName(t)
+	s, err := ListenNamedPipe(name, 64)
+	require.NoError(t, err)
+	defer s.Close()
+	go func() {
+		d, err := s.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(d, d) // echo until the client half-closes
+		d.CloseWrite()
+		d.Wait()
+	}()
+	d, err := DialNamedPipe(name, 64)
+	require.NoError(t, err)
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		d.Write(data)
+		d.CloseWrite()
+	}()
+	b, err := io.ReadAll(d)
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+	require.NoError(t, d.Wait())
+}
+
+func TestNamedPipeClose(t *testing.T) {
+	name := testPipeName(t)
+	s, err := ListenNamedPipe(name, 64)
+	require.NoError(t, err)
+	accepted := make(chan *Duplex, 1)
+	go func() {
+		d, _ := s.Accept()
+		accepted <- d
+	}()
+	c, err := DialNamedPipe(name, 64)
+	require.NoError(t, err)
+	d := <-accepted
+	require.NotNil(t, d)
+
+	// Close ends the pending read of the idle peer
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(d, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		c.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs")
+	}
+	// the server reads the end of the stream
+	_, err = d.Read(buf)
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, d.Close())
+
+	// Close wakes the blocked Accept
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s.Accept()
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, s.Close())
+	select {
+	case err := <-errc:
+		require.Equal(t, net.ErrClosed, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept is not woken")
+	}
+}
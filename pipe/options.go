@@ -0,0 +1,104 @@
+package pipe
+
+// Option configures the pipe created by New
+type Option func(*options)
+
+type options struct {
+	max          int
+	rsync, wsync bool
+	overflow     OverflowPolicy // zero unless the writer never waits
+	stats        Stats
+	alloc        func(size int) []byte
+	retain       bool
+	spin         *SpinPolicy
+	cond         bool
+}
+
+// Capacity makes the buffer of at least max bytes, the default size
+// otherwise
+func Capacity(max int) Option {
+	return func(o *options) { o.max = max }
+}
+
+// Synchronized makes the read or write end or both synchronized as in
+// SyncPipe, so each of them may be shared by goroutines
+func Synchronized(read, write bool) Option {
+	return func(o *options) { o.rsync, o.wsync = read, write }
+}
+
+// Overflow makes the writer that never waits for the slow reader as in
+// OverflowPipe, the writer is synchronized
+func Overflow(policy OverflowPolicy) Option {
+	return func(o *options) { o.overflow = policy }
+}
+
+// WithStats makes both ends report their events to s, see SetStats
+func WithStats(s Stats) Option {
+	return func(o *options) { o.stats = s }
+}
+
+// Allocator takes the buffer of the size rounded as for max from alloc,
+// e.g. Slab.Alloc. The buffer of other length is used as InitWith does
+func Allocator(alloc func(size int) []byte) Option {
+	return func(o *options) { o.alloc = alloc }
+}
+
+// Retain marks the read position of the new pipe, so the data read is
+// retained for Rewind from the start, see Mark
+func Retain() Option {
+	return func(o *options) { o.retain = true }
+}
+
+// Spin sets the spin policy of the locks of both ends, see SetSpinPolicy
+func Spin(p SpinPolicy) Option {
+	return func(o *options) { o.spin = &p }
+}
+
+// CondParking makes the plain reads and writes of the pipe park on the
+// condition variables instead of the channels while they wait for the other
+// end, so the wakeup costs no channel send and select. The waits that can
+// also end by the context, the deadline or the throttling keep waiting on
+// the channels
+func CondParking() Option {
+	return func(o *options) { o.cond = true }
+}
+
+// New creates a pipe configured by opts and returns its ends, by default
+// with the buffer of the default size and unsynchronized ends. The ends
+// share only the ring memory and header, so each of them can be handed to
+// an independent goroutine
+func New(opts ...Option) (*PipeReader, *PipeWriter) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.overflow != 0 {
+		o.wsync = true
+	}
+	var r *Reader
+	var w *Writer
+	if o.alloc != nil {
+		r, w = InitWith(o.alloc(bufferSize(o.max)), o.rsync, o.wsync)
+	} else {
+		r, w = pipe(o.max, o.rsync, o.wsync)
+	}
+	if o.cond {
+		r.waiting.cond = newCondParking()
+	}
+	if o.overflow != 0 {
+		r.overflow = &overflowState{policy: o.overflow}
+		w.overflow = r.overflow
+	}
+	if o.stats != nil {
+		r.SetStats(o.stats)
+		w.SetStats(o.stats)
+	}
+	if o.spin != nil {
+		r.SetSpinPolicy(*o.spin)
+		w.SetSpinPolicy(*o.spin)
+	}
+	if o.retain {
+		r.Mark()
+	}
+	return r, w
+}
@@ -0,0 +1,33 @@
+package pipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	r, w := New()
+	require.Equal(t, defaultBufferSize, r.Cap())
+	require.False(t, r.synchronized || w.synchronized)
+
+	var c Counters
+	slab := NewSlab(0)
+	r, w = New(Capacity(100), Synchronized(true, true), WithStats(&c), Allocator(slab.Alloc), Retain())
+	require.Equal(t, 128, r.Cap())
+	require.True(t, r.synchronized && w.synchronized)
+	require.Equal(t, 1, slab.Regions())
+	w.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	r.Read(buf)
+	n, err := r.Rewind()
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.EqualValues(t, 5, c.Load().Written)
+	require.EqualValues(t, 5, c.Load().Read)
+
+	r, w = New(Capacity(8), Overflow(DropOldest))
+	require.True(t, w.synchronized)
+	w.Write([]byte("0123456789"))
+	require.EqualValues(t, 2, w.Dropped())
+}
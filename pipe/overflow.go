@@ -0,0 +1,183 @@
+package pipe
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy tells the writer what to do with the write that doesn't fit
+// into the free space, instead of waiting for it
+type OverflowPolicy int
+
+const (
+	// DropWrite silently drops the write that doesn't fit
+	DropWrite OverflowPolicy = iota + 1
+	// DropOldest discards the oldest unread data to make room for the write
+	DropOldest
+	// FailWrite writes the part that fits and fails with *OverflowError
+	FailWrite
+)
+
+// OverflowError is returned by the FailWrite writer for the write that
+// doesn't fit into the free space
+type OverflowError struct {
+	Dropped int // number of bytes not written
+}
+
+func (e *OverflowError) Error() string {
+	return "Buffer overflow, " + strconv.Itoa(e.Dropped) + " bytes dropped"
+}
+
+// overflowState is shared by the ends of the pipe with the overflow policy
+type overflowState struct {
+	policy  OverflowPolicy
+	dropped uint64     // total bytes dropped
+	mu      sync.Mutex // held by the DropOldest writer overwriting the unread data
+}
+
+// OverflowPipe creates the pipe whose writers never block on the slow reader,
+// the writes that don't fit are handled by policy. The writer is
+// synchronized. Under DropOldest the ends support Read, Write and their
+// variants, but not the methods that access the ring memory otherwise
+func OverflowPipe(max int, policy OverflowPolicy) (*Reader, *Writer) {
+	r, w := pipe(max, false, true)
+	r.overflow = &overflowState{policy: policy}
+	w.overflow = r.overflow
+	return r, w
+}
+
+// Dropped returns number of bytes dropped by the overflow policy
+func (b *ringbuf) Dropped() int64 {
+	if b.overflow == nil {
+		return 0
+	}
+	return int64(atomic.LoadUint64(&b.overflow.dropped))
+}
+
+// writeOverflow is writeUnlocked of the pipe with the overflow policy
+func (w *Writer) writeOverflow(data []byte) (int, error) {
+	_, closed, _, sz := w.loadHeader()
+	if closed {
+		return 0, w.writeErr()
+	}
+	n := len(data)
+	switch free := len(w.mem) - sz; {
+	case n <= free:
+	case w.overflow.policy == DropWrite:
+		atomic.AddUint64(&w.overflow.dropped, uint64(n))
+		return n, nil
+	case w.overflow.policy == FailWrite:
+		nw := w.writeNoWait(data[:free])
+		atomic.AddUint64(&w.overflow.dropped, uint64(n-nw))
+		return nw, &OverflowError{Dropped: n - nw}
+	default:
+		w.overflow.mu.Lock()
+		defer w.overflow.mu.Unlock()
+		if n > len(w.mem) {
+			// only the tail fits
+			atomic.AddUint64(&w.overflow.dropped, uint64(n-len(w.mem)))
+			data = data[n-len(w.mem):]
+		}
+		w.discard(len(data))
+	}
+	w.writeNoWait(data)
+	return n, nil
+}
+
+// discard moves the read position forward over the oldest unread data, so n
+// bytes fit into the free space
+func (w *Writer) discard(n int) {
+	for {
+		rpos := atomic.LoadUint64(&w.hdr.rpos)
+		over := int(atomic.LoadUint64(&w.hdr.wpos)-rpos) + n - len(w.mem)
+		if over <= 0 {
+			return
+		}
+		// the reader that moves rpos concurrently retries
+		if atomic.CompareAndSwapUint64(&w.hdr.rpos, rpos, rpos+uint64(over)) {
+			atomic.AddUint64(&w.overflow.dropped, uint64(over))
+			return
+		}
+	}
+}
+
+// overwrites tells if the writer may move the read position
+func (b *ringbuf) overwrites() bool {
+	return b.overflow != nil && b.overflow.policy == DropOldest
+}
+
+// readOverwritten is ReadContext of the DropOldest pipe
+func (r *Reader) readOverwritten(ctx context.Context, data []byte) (int, error) {
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
+		return 0, timeoutError
+	}
+	var parked parking
+	defer parked.leave()
+	for {
+		flags := atomic.LoadUint64(&r.hdr.flags)
+		if (flags & readCloseFlag) != 0 {
+			return 0, ErrReadClosed
+		}
+		if (flags & abortFlag) != 0 {
+			return 0, r.closeErr()
+		}
+		if n := r.copyOverwritten(data); n > 0 {
+			return n, nil
+		}
+		if (flags & closeFlag) != 0 {
+			return 0, r.closeErr()
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return 0, timeoutError
+			}
+		}
+		r.readBlocked(ctx, start)
+	}
+}
+
+// copyOverwritten reads up to len(data) buffered bytes of the DropOldest
+// pipe. The data is copied under the lock of the writer overwriting it, the
+// writer that only moves the read position is detected by CAS
+func (r *Reader) copyOverwritten(data []byte) int {
+	r.overflow.mu.Lock()
+	defer r.overflow.mu.Unlock()
+	for {
+		wpos := atomic.LoadUint64(&r.hdr.wpos)
+		rpos := atomic.LoadUint64(&r.hdr.rpos)
+		if wpos <= rpos || len(data) == 0 {
+			return 0
+		}
+		n := minInt(int(wpos-rpos), len(data))
+		pos := r.offset(rpos)
+		if pos > len(r.mem)-n {
+			// wrapped
+			ll := len(r.mem) - pos
+			copy(data[:ll], r.mem[pos:])
+			copy(data[ll:n], r.mem[:n-ll])
+		} else {
+			copy(data, r.mem[pos:pos+n])
+		}
+		if atomic.CompareAndSwapUint64(&r.hdr.rpos, rpos, rpos+uint64(n)) {
+			if r.tee != nil {
+				r.tee.Write(data[:n])
+			}
+			notify(r.rsig)
+			if r.stats != nil {
+				r.stats.Read(n)
+			}
+			return n
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverflowPipe(t *testing.T) {
+	r, w := OverflowPipe(16, DropWrite)
+	w.WriteString("0123456789")
+	n, err := w.WriteString("abcdefghij")
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	require.Equal(t, int64(10), w.Dropped())
+	require.Equal(t, 10, r.Buffered())
+
+	r, w = OverflowPipe(16, FailWrite)
+	w.WriteString("0123456789")
+	n, err = w.WriteString("abcdefghij")
+	require.Equal(t, 6, n)
+	require.Equal(t, &OverflowError{Dropped: 4}, err)
+	buf := make([]byte, 16)
+	require.NoError(t, r.ReadFull(buf))
+	require.Equal(t, "0123456789abcdef", string(buf))
+
+	r, w = OverflowPipe(16, DropOldest)
+	w.WriteString("0123456789")
+	w.WriteString("abcdefghij")
+	require.Equal(t, int64(4), r.Dropped())
+	n, _ = r.Read(buf)
+	require.Equal(t, "456789abcdefghij", string(buf[:n]))
+	w.WriteString(strings.Repeat("x", 20) + "0123456789abcdef")
+	w.WriteString("ghij")
+	n, _ = r.Read(buf[:3])
+	require.Equal(t, "456", string(buf[:n]))
+	require.Equal(t, 13, r.Buffered())
+	w.Close()
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "789abcdefghij", string(res))
+
+	// the reader never sees the record torn by the overwriting writer
+	r, w = OverflowPipe(64, DropOldest)
+	go func() {
+		var rec [8]byte
+		for i := uint64(1); i <= 100000; i++ {
+			binary.BigEndian.PutUint64(rec[:], i)
+			w.Write(rec[:])
+		}
+		w.Close()
+	}()
+	requireRecords(t, r)
+}
+
+// requireRecords reads the 8 byte records of the increasing counter until
+// io.EOF
+func requireRecords(t *testing.T, r io.Reader) {
+	var rec [8]byte
+	last := uint64(0)
+	for {
+		n, err := r.Read(rec[:])
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err)
+		require.Equal(t, 8, n)
+		i := binary.BigEndian.Uint64(rec[:])
+		require.Greater(t, i, last)
+		last = i
+	}
+}
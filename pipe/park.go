@@ -0,0 +1,205 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// waiting counts the goroutines parked on the signals of the pipe. The
+// busy pipe, whose ends rarely wait, publishes and consumes with one
+// atomic load instead of the channel send per wakeup
+type waiting struct {
+	data  int32 // parked on wsig, or on the signals of the reader clones
+	space int32 // parked on rsig
+
+	clones atomic.Value // []chan struct{}, the data signals of the reader clones
+
+	cond *condParking // nil unless the pipe is made with CondParking
+}
+
+// condParking holds the condition variables the plain reads and writes of
+// the pipe made with CondParking park on instead of wsig and rsig
+type condParking struct {
+	data  condSignal
+	space condSignal
+}
+
+func newCondParking() *condParking {
+	c := &condParking{}
+	c.data.cond.L = &c.data.mu
+	c.space.cond.L = &c.space.mu
+	return c
+}
+
+// condSignal is the condition variable of the waiters on one side. Every
+// wakeup moves the generation and wakes all of them, each waiter sleeps
+// until the generation moves past the one it saw, so the wakeup between its
+// check and its wait is not lost
+type condSignal struct {
+	waiters int32
+	gen     uint64
+	mu      sync.Mutex
+	cond    sync.Cond
+}
+
+// wake wakes the waiters, if any
+func (s *condSignal) wake() {
+	if atomic.LoadInt32(&s.waiters) == 0 {
+		return
+	}
+	s.mu.Lock()
+	atomic.AddUint64(&s.gen, 1)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// wait blocks until the generation moves past the one seen by p
+func (s *condSignal) wait(p *parking) {
+	s.mu.Lock()
+	for atomic.LoadUint64(&s.gen) == p.seen {
+		s.cond.Wait()
+	}
+	p.seen = atomic.LoadUint64(&s.gen)
+	s.mu.Unlock()
+}
+
+// wakeCond wakes the goroutines parked on the condition variables, if any
+func (w *waiting) wakeCond() {
+	if w.cond != nil {
+		w.cond.data.wake()
+		w.cond.space.wake()
+	}
+}
+
+// wakeClones wakes the clones of the reader, they wait on their own signals
+func (w *waiting) wakeClones() {
+	sigs, _ := w.clones.Load().([]chan struct{})
+	for _, c := range sigs {
+		notify(c)
+	}
+}
+
+// wakeWaiters wakes every waiter of the ends spuriously, they check again
+func (b *ringbuf) wakeWaiters() {
+	signal(b.wsig)
+	signal(b.rsig)
+	sigs, _ := b.waiting.clones.Load().([]chan struct{})
+	for _, c := range sigs {
+		signal(c)
+	}
+	b.waiting.wakeCond()
+}
+
+// wakeLock wakes the waiter for the lock spuriously, it checks again
+func (b *ringbuf) wakeLock() {
+	signal(b.lsig)
+}
+
+// parking is the registration of the goroutine as the waiter on the
+// counter, from its first wait until the operation returns
+type parking struct {
+	c    *int32
+	cs   *condSignal // the condition variable to park on instead of the channel
+	seen uint64      // the generation of cs seen by the waiter
+
+	b      *ringbuf  // the adaptive end, nil unless it spun or parked
+	budget int       // the spins allowed to the operation
+	spins  int       // the spins done
+	since  time.Time // when it parked
+	until  time.Time // the end of the busy poll, zero unless it polled
+}
+
+// enter registers the waiter on c before it blocks. It returns false the
+// first time, then the caller checks its condition once more before
+// blocking: the notifier that found no waiters has published its update
+// before that check. The waiter on the condition variable is counted by it
+func (p *parking) enter(c *int32) bool {
+	if p.c != nil {
+		return true
+	}
+	if p.cs != nil {
+		p.seen = atomic.LoadUint64(&p.cs.gen)
+		c = &p.cs.waiters
+	}
+	p.c = c
+	atomic.AddInt32(c, 1)
+	return false
+}
+
+// waitCond parks the waiter on the condition variable. Once the deadline of
+// b is set, it moves the waiter to the channels, the next park registers it
+// on their counter
+func (p *parking) waitCond(b *ringbuf) {
+	if !b.deadline.isSet() {
+		p.cs.wait(p)
+	}
+	if b.deadline.isSet() {
+		atomic.AddInt32(p.c, -1)
+		p.c, p.cs = nil, nil
+	}
+}
+
+// leave unregisters the waiter, it is deferred by the operation
+func (p *parking) leave() {
+	if p.b != nil {
+		p.b.adapt.learn(&p.b.adapt.wait, p.since, p.b.spinPolicy.Spins)
+	}
+	if p.c != nil {
+		atomic.AddInt32(p.c, -1)
+		p.c = nil
+	}
+}
+
+// park prepares the wait of the operation on the waiter counter c. It
+// returns false while the end polls or the adaptive end spins instead of
+// parking, and once when it registers the waiter: the caller checks its
+// condition again each time. It returns true when the caller is to block
+func (b *ringbuf) park(p *parking, c *int32) bool {
+	if p.c == nil && b.busyPoll > 0 && b.poll(p) {
+		return false
+	}
+	if p.c != nil || b.adapt == nil {
+		return p.enter(c)
+	}
+	if p.b == nil {
+		p.b = b
+		p.budget = int(atomic.LoadInt32(&b.adapt.wait))
+	}
+	if p.spins < p.budget {
+		b.spinPolicy.pause(nil, p.spins)
+		p.spins++
+		return false
+	}
+	p.since = time.Now()
+	return p.enter(c)
+}
+
+// notifyData wakes the reader waiting for data, if any
+func (b *ringbuf) notifyData() {
+	if atomic.LoadInt32(&b.waiting.data) > 0 {
+		notify(b.wsig)
+		b.waiting.wakeClones()
+	}
+	if b.waiting.cond != nil {
+		b.waiting.cond.data.wake()
+	}
+}
+
+// notifySpace wakes the writer waiting for space, if any
+func (b *ringbuf) notifySpace() {
+	if atomic.LoadInt32(&b.waiting.space) > 0 {
+		notify(b.rsig)
+	}
+	if b.waiting.cond != nil {
+		b.waiting.cond.space.wake()
+	}
+}
+
+// condWaits returns true if the wait of the operation may park on the
+// condition variable: the pipe is made with CondParking and only the other
+// end ends the wait, not ctx, the deadline or the throttling
+func (b *ringbuf) condWaits(ctx context.Context) bool {
+	return b.waiting.cond != nil && ctx.Done() == nil && !b.deadline.isSet() && b.limit == nil
+}
@@ -0,0 +1,157 @@
+package pipe
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParking(t *testing.T) {
+	r, w := Pipe(64)
+
+	// nobody waits, the writes don't signal
+	w.Write([]byte("hello"))
+	require.Equal(t, 0, len(r.wsig))
+	buf := make([]byte, 5)
+	r.Read(buf)
+	require.Equal(t, 0, len(w.rsig))
+
+	// the blocked reader is counted and woken
+	done := make(chan int)
+	go func() {
+		n, _ := r.Read(buf)
+		done <- n
+	}()
+	for atomic.LoadInt32(&r.waiting.data) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	w.Write([]byte("world"))
+	require.Equal(t, 5, <-done)
+	require.EqualValues(t, 0, atomic.LoadInt32(&r.waiting.data))
+
+	// the blocked writer is counted and woken
+	w.Write(make([]byte, 64))
+	go func() {
+		n, _ := w.Write([]byte("x"))
+		done <- n
+	}()
+	for atomic.LoadInt32(&w.waiting.space) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.Read(buf[:1])
+	require.Equal(t, 1, <-done)
+	require.EqualValues(t, 0, atomic.LoadInt32(&w.waiting.space))
+}
+
+func TestCondParking(t *testing.T) {
+	r, w := New(Capacity(64), CondParking())
+	cond := r.waiting.cond
+
+	// the blocked reader parks on the condition variable
+	buf := make([]byte, 5)
+	done := make(chan int)
+	go func() {
+		n, _ := r.Read(buf)
+		done <- n
+	}()
+	for atomic.LoadInt32(&cond.data.waiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	require.EqualValues(t, 0, atomic.LoadInt32(&r.waiting.data))
+	w.Write([]byte("hello"))
+	require.Equal(t, 5, <-done)
+	require.Equal(t, 0, len(r.wsig))
+	require.EqualValues(t, 0, atomic.LoadInt32(&cond.data.waiters))
+
+	// so does the blocked writer
+	w.Write(make([]byte, 64))
+	go func() {
+		n, _ := w.Write([]byte("x"))
+		done <- n
+	}()
+	for atomic.LoadInt32(&cond.space.waiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.Read(buf[:1])
+	require.Equal(t, 1, <-done)
+	require.Equal(t, 0, len(w.rsig))
+	r.Read(make([]byte, 64))
+
+	// the deadline set while parked moves the reader to the channels
+	errc := make(chan error)
+	go func() {
+		_, err := r.Read(buf)
+		errc <- err
+	}()
+	for atomic.LoadInt32(&cond.data.waiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	require.True(t, (<-errc).(net.Error).Timeout())
+	r.SetReadDeadline(time.Time{})
+
+	// the stream is intact
+	const total = 1 << 20
+	go func() {
+		b := make([]byte, 7)
+		for i := 0; i < total; i += len(b) {
+			for j := range b {
+				b[j] = byte(i + j)
+			}
+			w.Write(b[:minInt(len(b), total-i)])
+		}
+		w.Close()
+	}()
+	b := make([]byte, 13)
+	for i := 0; ; {
+		n, err := r.Read(b)
+		for j := 0; j < n; j++ {
+			require.Equal(t, byte(i+j), b[j])
+		}
+		i += n
+		if err != nil {
+			require.Equal(t, total, i)
+			break
+		}
+	}
+
+	// the close wakes the parked ends
+	r, w = New(Capacity(64), CondParking())
+	go func() {
+		_, err := r.Read(buf)
+		errc <- err
+	}()
+	for atomic.LoadInt32(&r.waiting.cond.data.waiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	w.Close()
+	require.Equal(t, io.EOF, <-errc)
+}
+
+func BenchmarkParking(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"chan", []Option{Capacity(64)}},
+		{"cond", []Option{Capacity(64), CondParking()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			r, w := New(bc.opts...)
+			go func() {
+				var msg [8]byte
+				for i := 0; i < b.N; i++ {
+					w.Write(msg[:])
+				}
+			}()
+			var msg [8]byte
+			for i := 0; i < b.N; i++ {
+				r.Read(msg[:])
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package pipe
+
+import (
+	"net"
+	"syscall"
+)
+
+func peerCred(c *net.UnixConn) (PeerCred, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var cred *syscall.Ucred
+	var cerr error
+	if err = rc.Control(func(fd uintptr) {
+		cred, cerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, err
+	}
+	if cerr != nil {
+		return PeerCred{}, cerr
+	}
+	return PeerCred{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}, nil
+}
@@ -1,5 +1,18 @@
 package pipe
 
+import "io"
+
+// PipeReader is the read end of a pipe created by New
+type PipeReader = Reader
+
+// PipeWriter is the write end of a pipe created by New
+type PipeWriter = Writer
+
+var (
+	_ io.Reader = (*PipeReader)(nil)
+	_ io.Writer = (*PipeWriter)(nil)
+)
+
 func pipe(max int, rsync, wsync bool) (*Reader, *Writer) {
 	r := &Reader{}
 	w := &Writer{}
@@ -8,6 +21,21 @@ func pipe(max int, rsync, wsync bool) (*Reader, *Writer) {
 	return r, w
 }
 
+// InitWith creates a pipe that uses mem as its buffer, e.g. the memory taken
+// from a pool. mem may be of any non-zero length and must not be touched by
+// the caller while the pipe is in use. rsync and wsync make the ends
+// synchronized as in SyncPipe
+func InitWith(mem []byte, rsync, wsync bool) (*Reader, *Writer) {
+	if len(mem) == 0 {
+		panic("pipe memory is empty")
+	}
+	r := &Reader{}
+	w := &Writer{}
+	r.initWith(mem, rsync)
+	w.initFrom(&r.ringbuf, wsync)
+	return r, w
+}
+
 func Pipe(max int) (*Reader, *Writer) {
 	return pipe(max, false, false)
 }
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	. "github.com/pi/goal/pipe/_testing"
@@ -24,6 +27,8 @@ type TestWriterInterface interface {
 	io.Writer
 	io.ByteWriter
 	io.ReaderFrom
+	io.StringWriter
+	WriteContext(ctx context.Context, data []byte) (int, error)
 	WriteAll(data ...[]byte) (int64, error)
 	WriteAllWithContext(ctx context.Context, data ...[]byte) (int64, error)
 	WriteWait(n int) error
@@ -36,9 +41,14 @@ type TestReaderInterface interface {
 	io.WriterTo
 	io.ByteReader
 	ReadWithContext(ctx context.Context, data []byte) (int, error)
+	ReadContext(ctx context.Context, data []byte) (int, error)
 	Skip(toSkip int) (int, error)
+	Discard(n int) (int, error)
+	ReadAtLeast(buf []byte, min int) (int, error)
+	ReadFull(buf []byte) error
 	SkipWithContext(ctx context.Context, toSkip int) (int, error)
-	Peek(buf []byte) (int, error)
+	Peek(n int) ([]byte, error)
+	PeekInto(buf []byte) (int, error)
 	Len() int
 	ReadWait(n int) error
 	ReadWaitWithContext(ctx context.Context, n int) error
@@ -77,7 +87,7 @@ func TestRing(t *testing.T) {
 	}
 	var p = make([]byte, 1)
 	for {
-		n, _ := r.Peek(p)
+		n, _ := r.PeekInto(p)
 		if n > 0 {
 			r.Read(rm)
 		} else {
@@ -613,3 +623,770 @@ func TestInterface(t *testing.T) {
 	}
 	it()
 }
+
+func TestNew(t *testing.T) {
+	r, w := New(Capacity(64))
+	data := make([]byte, 1000)
+	rand.Read(data)
+	go func() {
+		var dst io.Writer = w
+		dst.Write(data)
+		w.Close()
+	}()
+	var src io.Reader = r
+	rdata, err := io.ReadAll(src)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, rdata))
+}
+
+func TestCloseWithError(t *testing.T) {
+	myErr := errors.New("my error")
+	r, w := New(Capacity(16))
+	w.Write([]byte("abc"))
+	w.CloseWithError(myErr)
+	w.CloseWithError(errors.New("other error"))
+	w.Close()
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ab", string(buf[:n]))
+	n, err = r.Read(buf)
+	require.Equal(t, myErr, err)
+	require.Equal(t, "c", string(buf[:n]))
+	_, err = r.Read(buf)
+	require.Equal(t, myErr, err)
+	_, err = r.Skip(1)
+	require.Equal(t, myErr, err)
+
+	r, w = New(Capacity(16))
+	w.CloseWithError(nil)
+	_, err = r.Read(buf)
+	require.Equal(t, io.EOF, err)
+
+	r, w = New(Capacity(16))
+	w.Write([]byte("abc"))
+	w.CloseWithError(myErr)
+	bw := bytes.NewBuffer(nil)
+	nc, err := r.WriteTo(bw)
+	require.Equal(t, myErr, err)
+	require.EqualValues(t, 3, nc)
+}
+
+func TestHalfClose(t *testing.T) {
+	r, w := New(Capacity(16))
+	w.Write([]byte("abc"))
+	require.NoError(t, w.CloseWrite())
+	_, err := w.Write([]byte("d"))
+	require.Equal(t, io.EOF, err)
+	rdata, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(rdata))
+
+	r, w = New(Capacity(16))
+	w.Write([]byte("abc"))
+	require.NoError(t, r.CloseRead())
+	require.True(t, w.IsClosed())
+	_, err = w.Write([]byte("d"))
+	require.Equal(t, ErrReadClosed, err)
+	require.Equal(t, ErrReadClosed, w.WriteByte(1))
+	require.Equal(t, 0, r.Len())
+	_, err = r.Read(make([]byte, 1))
+	require.Equal(t, ErrReadClosed, err)
+
+	// blocked writer is released
+	r, w = New(Capacity(8))
+	w.Write(make([]byte, 8))
+	ec := make(chan error)
+	go func() {
+		_, err := w.Write(make([]byte, 8))
+		ec <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.CloseRead()
+	require.Equal(t, ErrReadClosed, <-ec)
+}
+
+func TestPeek(t *testing.T) {
+	r, w := New(Capacity(8))
+	w.Write([]byte("abcdef"))
+	r.Skip(5)
+	w.Write([]byte("ghijk"))
+	// wrapped
+	p, err := r.Peek(4)
+	require.NoError(t, err)
+	require.Equal(t, "fghi", string(p))
+	p, err = r.Peek(100)
+	require.NoError(t, err)
+	require.Equal(t, "fghijk", string(p))
+	r.Skip(3)
+	// contiguous
+	p, err = r.Peek(2)
+	require.NoError(t, err)
+	require.Equal(t, "ij", string(p))
+	require.Equal(t, 3, r.Len())
+
+	// blocks until data arrives
+	r, w = New(Capacity(8))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("x"))
+	}()
+	p, err = r.Peek(1)
+	require.NoError(t, err)
+	require.Equal(t, "x", string(p))
+	w.Close()
+	p, err = r.Peek(2)
+	require.Equal(t, "x", string(p))
+	require.Equal(t, io.EOF, err)
+	r.Skip(1)
+	_, err = r.Peek(1)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestDiscard(t *testing.T) {
+	r, w := New(Capacity(8))
+	go func() {
+		w.Write([]byte("0123456789abcdef"))
+		w.Close()
+	}()
+	n, err := r.Discard(12)
+	require.NoError(t, err)
+	require.Equal(t, 12, n)
+	rdata, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "cdef", string(rdata))
+	n, err = r.Discard(1)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 0, n)
+}
+
+func TestByteReadWrite(t *testing.T) {
+	for _, ctr := range []func(int) (*Reader, *Writer){Pipe, SyncWritePipe, SyncPipe} {
+		data := make([]byte, 10000)
+		rand.Read(data)
+		r, w := ctr(16)
+		go func() {
+			for _, b := range data {
+				w.WriteByte(b)
+			}
+			w.Close()
+		}()
+		rdata := make([]byte, 0, len(data))
+		for {
+			b, err := r.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			rdata = append(rdata, b)
+		}
+		require.True(t, bytes.Equal(data, rdata))
+	}
+}
+
+func BenchmarkByteReadWrite(b *testing.B) {
+	r, w := Pipe(BS)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			w.WriteByte(byte(i))
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		r.ReadByte()
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	r, w := New(Capacity(BS))
+	n, err := w.WriteString("hello")
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	p, _ := r.Peek(5)
+	require.Equal(t, "hello", string(p))
+	r.Discard(5)
+
+	s := "some string"
+	allocs := testing.AllocsPerRun(100, func() {
+		w.WriteString(s)
+		r.Discard(len(s))
+	})
+	require.Zero(t, allocs)
+}
+
+func TestReadFromShortReads(t *testing.T) {
+	for _, wrap := range []func(io.Reader) io.Reader{iotest.OneByteReader, iotest.HalfReader, iotest.DataErrReader} {
+		data := make([]byte, 5000)
+		rand.Read(data)
+		r, w := New(Capacity(64))
+		w.Write(make([]byte, 30))
+		r.Discard(30)
+		go func() {
+			n, err := w.ReadFrom(wrap(bytes.NewReader(data)))
+			require.NoError(t, err)
+			require.EqualValues(t, len(data), n)
+			w.Close()
+		}()
+		rdata, err := io.ReadAll(iotest.HalfReader(r))
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(data, rdata))
+	}
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	max   int
+	limit int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > lw.max {
+		p = p[:lw.max]
+	}
+	if lw.limit >= 0 && len(p) > lw.limit {
+		p = p[:lw.limit]
+	}
+	n, _ := lw.w.Write(p)
+	if lw.limit >= 0 {
+		lw.limit -= n
+		if lw.limit == 0 {
+			return n, io.ErrClosedPipe
+		}
+	}
+	return n, nil
+}
+
+func TestWriteToPartialWrites(t *testing.T) {
+	r, w := New(Capacity(16))
+	w.Write(make([]byte, 10))
+	r.Discard(10)
+	w.Write([]byte("0123456789"))
+	w.Close()
+	bw := bytes.NewBuffer(nil)
+	// wrapped data, destination fails in the middle of the first region
+	n, err := r.WriteTo(&limitedWriter{w: bw, max: 100, limit: 3})
+	require.Equal(t, io.ErrClosedPipe, err)
+	require.EqualValues(t, 3, n)
+	require.Equal(t, 7, r.Len())
+	// short write without error
+	n, err = r.WriteTo(&limitedWriter{w: bw, max: 2, limit: -1})
+	require.Equal(t, io.ErrShortWrite, err)
+	require.EqualValues(t, 2, n)
+	n, err = r.WriteTo(bw)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, n)
+	require.Equal(t, "0123456789", bw.String())
+}
+
+func TestReadAtLeast(t *testing.T) {
+	r, w := New(Capacity(8))
+	buf := make([]byte, 6)
+	go func() {
+		w.Write([]byte("ab"))
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("cdef"))
+	}()
+	n, err := r.ReadAtLeast(buf, 3)
+	require.NoError(t, err)
+	require.True(t, n >= 3)
+	require.Equal(t, "abcdef"[:n], string(buf[:n]))
+	require.NoError(t, r.ReadFull(buf[n:]))
+	require.Equal(t, "abcdef", string(buf))
+
+	_, err = r.ReadAtLeast(buf[:2], 3)
+	require.Equal(t, io.ErrShortBuffer, err)
+
+	// header larger than the buffer capacity
+	data := make([]byte, 100)
+	rand.Read(data)
+	go func() {
+		w.Write(data)
+		w.Write([]byte("x"))
+		w.Close()
+	}()
+	rdata := make([]byte, 100)
+	require.NoError(t, r.ReadFull(rdata))
+	require.True(t, bytes.Equal(data, rdata))
+	require.Equal(t, io.ErrUnexpectedEOF, r.ReadFull(rdata[:2]))
+	require.Equal(t, io.EOF, r.ReadFull(rdata[:2]))
+}
+
+func TestTryReadWrite(t *testing.T) {
+	r, w := SyncPipe(8)
+	buf := make([]byte, 16)
+	n, ok := r.TryRead(buf)
+	require.True(t, ok)
+	require.Equal(t, 0, n)
+	n, ok = w.TryWrite([]byte("0123456789"))
+	require.True(t, ok)
+	require.Equal(t, 8, n)
+	n, ok = w.TryWrite([]byte("89"))
+	require.True(t, ok)
+	require.Equal(t, 0, n)
+	n, ok = r.TryRead(buf[:3])
+	require.True(t, ok)
+	require.Equal(t, "012", string(buf[:n]))
+	n, ok = w.TryWrite([]byte("89"))
+	require.True(t, ok)
+	require.Equal(t, 2, n)
+
+	// lock is busy
+	require.NoError(t, r.lock())
+	n, ok = r.TryRead(buf)
+	require.True(t, ok)
+	require.Equal(t, 0, n)
+	r.unlock()
+
+	w.Close()
+	n, ok = w.TryWrite([]byte("x"))
+	require.False(t, ok)
+	require.Equal(t, 0, n)
+	n, ok = r.TryRead(buf)
+	require.True(t, ok)
+	require.Equal(t, "3456789", string(buf[:n]))
+	n, ok = r.TryRead(buf)
+	require.False(t, ok)
+	require.Equal(t, 0, n)
+}
+
+func TestBufferedFree(t *testing.T) {
+	r, w := New(Capacity(16))
+	require.Equal(t, 0, r.Buffered())
+	require.Equal(t, 16, w.Free())
+	w.Write([]byte("hello"))
+	require.Equal(t, 5, r.Buffered())
+	require.Equal(t, 5, w.Buffered())
+	require.Equal(t, 11, r.Free())
+	r.Discard(2)
+	require.Equal(t, 3, w.Buffered())
+	require.Equal(t, 13, w.Free())
+}
+
+func TestReset(t *testing.T) {
+	r, w := SyncPipe(16)
+	mem := &r.mem[0]
+	require.Equal(t, ErrNotClosed, r.Reset())
+	w.Write([]byte("abc"))
+	w.CloseWithError(errors.New("my error"))
+	require.NoError(t, w.Reset())
+	require.False(t, r.IsClosed())
+	require.False(t, w.IsClosed())
+	require.Equal(t, 0, r.Len())
+	require.True(t, mem == &r.mem[0])
+
+	w.Write([]byte("xyz"))
+	w.Close()
+	rdata, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "xyz", string(rdata))
+
+	r.CloseRead()
+	require.NoError(t, r.Reset())
+	n, err := w.Write([]byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// the blocked stragglers fail with the close instead of waking up in the
+	// reopened pipe
+	r, w = SyncPipe(16)
+	errc := make(chan error)
+	for i := 0; i < 100; i++ {
+		go func() {
+			_, err := r.Read(make([]byte, 1))
+			errc <- err
+		}()
+		for atomic.LoadInt32(&r.waiting.data) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Close()
+		require.NoError(t, w.Reset())
+		require.Equal(t, io.EOF, <-errc)
+
+		w.Write(make([]byte, 16))
+		go func() {
+			_, err := w.Write([]byte("x"))
+			errc <- err
+		}()
+		for atomic.LoadInt32(&w.waiting.space) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		w.Abort(nil)
+		require.NoError(t, r.Reset())
+		require.Equal(t, ErrAborted, <-errc)
+		require.Equal(t, 0, r.Len())
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	r, _ := SyncPipe(8)
+	_, w := SyncPipe(8)
+	ctx, cancel := context.WithCancel(context.Background())
+	ec := make(chan error, 3)
+	go func() {
+		_, err := r.ReadContext(ctx, make([]byte, 1))
+		ec <- err
+	}()
+	go func() {
+		// waits for the lock held by the reader above
+		time.Sleep(5 * time.Millisecond)
+		_, err := r.PeekWithContext(ctx, 1)
+		ec <- err
+	}()
+	w.Write(make([]byte, 8))
+	go func() {
+		_, err := w.WriteContext(ctx, make([]byte, 16))
+		ec <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	for i := 0; i < 3; i++ {
+		require.Equal(t, context.Canceled, <-ec)
+	}
+
+	r, w = New(Capacity(8))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, r.ReadFullWithContext(ctx, make([]byte, 1)))
+	w.WriteString("abc")
+	n, err := r.ReadContext(context.Background(), make([]byte, 3))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+}
+
+func TestWriteBuffers(t *testing.T) {
+	r, w := SyncPipe(16)
+	w.Write(make([]byte, 10))
+	r.Discard(10)
+	// wraps around the end of memory
+	n, err := w.WriteBuffers(net.Buffers{[]byte("head"), nil, []byte("payload")})
+	require.NoError(t, err)
+	require.EqualValues(t, 11, n)
+	require.Equal(t, 11, r.Len())
+
+	// waits for space for all buffers
+	done := make(chan struct{})
+	go func() {
+		n, err := w.WriteBuffers(net.Buffers{[]byte("abc"), []byte("def")})
+		require.NoError(t, err)
+		require.EqualValues(t, 6, n)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 11, r.Len())
+	buf := make([]byte, 11)
+	require.NoError(t, r.ReadFull(buf))
+	require.Equal(t, "headpayload", string(buf))
+	<-done
+	require.NoError(t, r.ReadFull(buf[:6]))
+	require.Equal(t, "abcdef", string(buf[:6]))
+
+	// larger than capacity
+	data := make([]byte, 40)
+	rand.Read(data)
+	go func() {
+		w.WriteBuffers(net.Buffers{data[:30], data[30:]})
+		w.Close()
+	}()
+	rdata, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, rdata))
+}
+
+func TestAcquireWrite(t *testing.T) {
+	r, w := SyncWritePipe(16)
+	buf, err := w.AcquireWrite(10)
+	require.NoError(t, err)
+	require.Len(t, buf, 10)
+	n := binary.PutUvarint(buf, 300)
+	w.CommitWrite(n)
+	v, err := binary.ReadUvarint(r)
+	require.NoError(t, err)
+	require.EqualValues(t, 300, v)
+
+	// wrapped region
+	w.Write(make([]byte, 12))
+	r.Discard(12)
+	buf, err = w.AcquireWrite(8)
+	require.NoError(t, err)
+	require.Len(t, buf, 2)
+	copy(buf, "ab")
+	w.CommitWrite(2)
+	buf, err = w.AcquireWrite(6)
+	require.NoError(t, err)
+	require.Len(t, buf, 6)
+	copy(buf, "cdef")
+	w.CommitWrite(4)
+	rbuf := make([]byte, 6)
+	require.NoError(t, r.ReadFull(rbuf))
+	require.Equal(t, "abcdef", string(rbuf))
+
+	_, err = w.AcquireWrite(17)
+	require.Equal(t, ErrOvercap, err)
+	// lock is released after commit
+	_, err = w.Write([]byte("x"))
+	require.NoError(t, err)
+	w.Close()
+	_, err = w.AcquireWrite(1)
+	require.Equal(t, io.EOF, err)
+	require.Panics(t, func() { w.CommitWrite(1) })
+}
+
+func TestAcquireRead(t *testing.T) {
+	r, w := SyncPipe(16)
+	w.Write(make([]byte, 12))
+	r.Discard(12)
+	w.Write([]byte("abcdef"))
+	// wrapped data
+	view, err := r.AcquireRead()
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(view))
+	r.ReleaseRead(3)
+	view, err = r.AcquireRead()
+	require.NoError(t, err)
+	require.Equal(t, "d", string(view))
+	r.ReleaseRead(1)
+	view, err = r.AcquireRead()
+	require.NoError(t, err)
+	require.Equal(t, "ef", string(view))
+	r.ReleaseRead(0)
+	require.Equal(t, 2, r.Len())
+
+	// zero-copy transfer
+	data := make([]byte, 1000)
+	rand.Read(data)
+	go func() {
+		p := data
+		for len(p) > 0 {
+			buf, err := w.AcquireWrite(minInt(len(p), 7))
+			if err != nil {
+				break
+			}
+			n := copy(buf, p)
+			w.CommitWrite(n)
+			p = p[n:]
+		}
+		w.Close()
+	}()
+	r.Discard(2)
+	var rdata []byte
+	for {
+		view, err := r.AcquireRead()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		rdata = append(rdata, view...)
+		r.ReleaseRead(len(view))
+	}
+	require.True(t, bytes.Equal(data, rdata))
+}
+
+func TestCounterPast32Bits(t *testing.T) {
+	r, w := Pipe(16)
+	// move both counters close to 2^32 and past 31-bit positions
+	r.hdr.rpos = 1<<32 - 5
+	r.hdr.wpos = 1<<32 - 5
+	data := []byte("0123456789abcdef")
+	for i := 0; i < 100; i++ {
+		n, err := w.Write(data[:11])
+		require.NoError(t, err)
+		require.Equal(t, 11, n)
+		require.Equal(t, 11, r.Buffered())
+		require.Equal(t, 5, w.Free())
+		rdata := make([]byte, 11)
+		_, err = io.ReadFull(r, rdata)
+		require.NoError(t, err)
+		require.Equal(t, data[:11], rdata)
+	}
+	require.True(t, r.hdr.rpos > 1<<32)
+}
+
+func TestInitWith(t *testing.T) {
+	mem := make([]byte, 10)
+	r, w := InitWith(mem, false, true)
+	require.Equal(t, 10, r.Cap())
+	require.True(t, &mem[0] == &r.mem[0])
+	go func() {
+		var b [13]byte
+		c := 0
+		for i := 0; i < 1000; i++ {
+			n := rand.Intn(len(b)) + 1
+			for j := 0; j < n; j++ {
+				b[j] = byte(c)
+				c++
+			}
+			switch i % 3 {
+			case 0:
+				w.Write(b[:n])
+			case 1:
+				w.WriteBuffers(net.Buffers{b[:n/2], b[n/2 : n]})
+			default:
+				for j := 0; j < n; j++ {
+					w.WriteByte(b[j])
+				}
+			}
+		}
+		w.Close()
+	}()
+	c := 0
+	var b [7]byte
+	for i := 0; ; i++ {
+		var n int
+		var err error
+		if i%2 == 0 {
+			n, err = r.Read(b[:rand.Intn(len(b))+1])
+		} else {
+			b[0], err = r.ReadByte()
+			if err == nil {
+				n = 1
+			}
+		}
+		for j := 0; j < n; j++ {
+			require.Equal(t, byte(c), b[j])
+			c++
+		}
+		if err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	require.Panics(t, func() { InitWith(nil, false, false) })
+}
+
+func TestTee(t *testing.T) {
+	r, w := Pipe(16)
+	var captured bytes.Buffer
+	r.Tee(&captured)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	var out bytes.Buffer
+	b := make([]byte, 5)
+	r.ReadFull(b)
+	out.Write(b)
+	c, _ := r.ReadByte()
+	out.WriteByte(c)
+	r.Skip(4)
+	out.Write(data[6:10])
+	r.WriteTo(&out)
+	require.Equal(t, data, out.Bytes())
+	require.Equal(t, data, captured.Bytes())
+}
+
+func TestCallTimeout(t *testing.T) {
+	r, w := SyncPipe(16)
+	start := time.Now()
+	_, err := r.ReadTimeout(make([]byte, 1), 50*time.Millisecond)
+	require.True(t, err.(net.Error).Timeout())
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+	n, err := w.WriteTimeout(make([]byte, 20), 50*time.Millisecond)
+	require.Equal(t, 16, n)
+	require.True(t, err.(net.Error).Timeout())
+	n, err = r.ReadTimeout(make([]byte, 16), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 16, n)
+	// the timeouts don't stick
+	go w.WriteString("a")
+	n, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+func TestPartialWrites(t *testing.T) {
+	r, w := Pipe(16)
+	_, err := w.AcquireWrite(20)
+	require.Equal(t, ErrOvercap, err)
+	w.SetPartialWrites(true)
+	n, err := w.WriteString("0123456789")
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	n, err = w.WriteString("abcdefghij")
+	require.Equal(t, io.ErrShortWrite, err)
+	require.Equal(t, 6, n)
+	buf := make([]byte, 4)
+	r.Read(buf)
+	region, err := w.AcquireWrite(20)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(region))
+	w.CommitWrite(0)
+	w.SetPartialWrites(false)
+	go w.WriteString("ghijkl")
+	res := make([]byte, 16)
+	require.NoError(t, r.ReadFull(res))
+	require.Equal(t, "456789abcdefghij", string(res))
+}
+
+func TestCloseAfterDrain(t *testing.T) {
+	r, w := SyncPipe(16)
+	w.Write([]byte("hello"))
+	closed := make(chan error, 1)
+	go func() {
+		closed <- w.CloseAfterDrain(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.False(t, r.IsClosed())
+	// the writes wait for the close
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("x"))
+		written <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	buf := make([]byte, 5)
+	_, err := r.Read(buf)
+	require.NoError(t, err)
+	require.NoError(t, <-closed)
+	require.Equal(t, io.EOF, <-written)
+	require.True(t, r.IsClosed())
+	_, err = r.Read(buf[:1])
+	require.Equal(t, io.EOF, err)
+
+	// ctx gives up the drain
+	r, w = Pipe(16)
+	w.Write([]byte("hello"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, w.CloseAfterDrain(ctx))
+	require.True(t, r.IsClosed())
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	_, err = r.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestAbort(t *testing.T) {
+	r, w := Pipe(16)
+	w.Write([]byte("stale"))
+	bad := errors.New("protocol violation")
+	require.NoError(t, w.Abort(bad))
+	_, err := r.Read(make([]byte, 1))
+	require.Equal(t, bad, err)
+	require.Equal(t, 0, r.Buffered())
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, bad, err)
+
+	// the blocked reader wakes
+	r, w = Pipe(16)
+	w.Write([]byte("ab"))
+	got := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 4))
+		got <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Abort(nil)
+	require.Equal(t, ErrAborted, <-got)
+
+	// the closed pipe loses its data too
+	r, w = Pipe(16)
+	w.Write([]byte("stale"))
+	w.Close()
+	w.Abort(bad)
+	_, err = r.Read(make([]byte, 1))
+	require.Equal(t, bad, err)
+	require.NoError(t, r.Reset())
+	w.Write([]byte("a"))
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+}
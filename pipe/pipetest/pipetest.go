@@ -0,0 +1,225 @@
+// Package pipetest drives pipes from fuzz inputs to check the code built on
+// them against the real ring behavior
+package pipetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pi/goal/pipe"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Harness runs the scripts decoded from fuzz inputs on the pipe. The first
+// byte of the input selects the capacity, the byte pairs after it are the
+// operations: writes and reads of 1-256 bytes, yields and closes of either
+// end. The writer and the reader run their operations concurrently, the
+// writer closes the pipe after its last one and the reader then drains it.
+// The bytes read must be the bytes written in order, all of them unless the
+// reader closed the pipe first, and both ends must finish within Timeout
+type Harness struct {
+	// Wrap returns the ends the data is written to and read from, e.g. the
+	// framed writer and reader. The writer must not buffer data, the reader
+	// must accept the reads of any size, nil Wrap uses the pipe ends
+	Wrap func(r *pipe.Reader, w *pipe.Writer) (io.Reader, io.Writer)
+	// Timeout for both ends to finish, zero means 5s
+	Timeout time.Duration
+}
+
+// OpKind is the kind of the scripted operation
+type OpKind int
+
+const (
+	Write OpKind = iota + 1
+	Read
+	Yield
+	CloseWrite
+	CloseRead
+)
+
+// Op is the scripted operation, N is the size of the read or the write
+type Op struct {
+	Kind OpKind
+	N    int
+}
+
+// Decode returns the capacity and the operations of the input
+func Decode(input []byte) (capacity int, ops []Op) {
+	capacity = 8
+	if len(input) > 0 {
+		capacity <<= input[0] % 8
+		input = input[1:]
+	}
+	for ; len(input) >= 2; input = input[2:] {
+		op := Op{N: int(input[1]) + 1}
+		switch input[0] % 8 {
+		case 0, 1, 2:
+			op.Kind = Write
+		case 3, 4, 5:
+			op.Kind = Read
+		case 6:
+			op.Kind = Yield
+		default:
+			op.Kind = CloseWrite
+			if op.N%2 == 0 {
+				op.Kind = CloseRead
+			}
+		}
+		ops = append(ops, op)
+	}
+	return capacity, ops
+}
+
+// pattern returns n bytes of the stream at off, so misordered data is detected
+func pattern(off, n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte((off + i) % 251)
+	}
+	return p
+}
+
+// Run runs the script of the input and returns the violation, if any
+func (h *Harness) Run(input []byte) error {
+	capacity, ops := Decode(input)
+	r, w := pipe.Pipe(capacity)
+	var src io.Reader = r
+	var dst io.Writer = w
+	if h.Wrap != nil {
+		src, dst = h.Wrap(r, w)
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	written := make(chan int, 1)
+	go func() {
+		total := 0
+		defer func() {
+			w.Close()
+			written <- total
+		}()
+		for _, op := range ops {
+			switch op.Kind {
+			case Write:
+				n, err := dst.Write(pattern(total, op.N))
+				total += n
+				if err != nil {
+					return
+				}
+			case Yield:
+				runtime.Gosched()
+			case CloseWrite:
+				return
+			}
+		}
+	}()
+
+	type result struct {
+		data   []byte
+		closed bool
+		err    error
+	}
+	read := make(chan result, 1)
+	go func() {
+		var res result
+		var b bytes.Buffer
+		defer func() {
+			res.data = b.Bytes()
+			read <- res
+		}()
+		buf := make([]byte, 256)
+		readN := func(n int) error {
+			nr, err := src.Read(buf[:n])
+			b.Write(buf[:nr])
+			return err
+		}
+		for _, op := range ops {
+			switch op.Kind {
+			case Read:
+				if err := readN(op.N); err != nil {
+					if err != io.EOF {
+						res.err = err
+					}
+					return
+				}
+			case Yield:
+				runtime.Gosched()
+			case CloseRead:
+				r.CloseRead()
+				res.closed = true
+				return
+			}
+		}
+		for {
+			if err := readN(256); err != nil {
+				if err != io.EOF {
+					res.err = err
+				}
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var total int
+	select {
+	case total = <-written:
+	case <-timer.C:
+		return deadlock("writer", r, w)
+	}
+	var res result
+	select {
+	case res = <-read:
+	case <-timer.C:
+		return deadlock("reader", r, w)
+	}
+
+	if res.err != nil {
+		return fmt.Errorf("read failed after %d bytes: %w", len(res.data), res.err)
+	}
+	if len(res.data) > total || (!res.closed && len(res.data) != total) {
+		return fmt.Errorf("%d bytes written, %d bytes read", total, len(res.data))
+	}
+	if i := mismatch(res.data); i >= 0 {
+		return fmt.Errorf("byte %d read out of order", i)
+	}
+	return nil
+}
+
+func mismatch(data []byte) int {
+	for i, c := range data {
+		if c != byte(i%251) {
+			return i
+		}
+	}
+	return -1
+}
+
+func deadlock(end string, r *pipe.Reader, w *pipe.Writer) error {
+	var b strings.Builder
+	w.Dump(&b)
+	r.CloseRead() // release the ends
+	return fmt.Errorf("%s deadlocked: %s", end, strings.TrimSpace(b.String()))
+}
+
+// Fuzz adds the seed corpus to f and fuzzes the harness with it
+func (h *Harness) Fuzz(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{1, 0, 10, 3, 4, 0, 200, 3, 200})
+	f.Add([]byte{2, 0, 255, 6, 0, 3, 7, 0, 255, 7, 1})
+	f.Add([]byte{7, 0, 100, 3, 1, 7, 2, 0, 100})
+	f.Fuzz(func(t *testing.T, input []byte) {
+		if err := h.Run(input); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
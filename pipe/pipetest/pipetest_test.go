@@ -0,0 +1,50 @@
+package pipetest
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/pi/goal/pipe"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	capacity, ops := Decode([]byte{3, 0, 9, 3, 0, 6, 1, 7, 1, 7, 2})
+	require.Equal(t, 64, capacity)
+	require.Equal(t, []Op{{Write, 10}, {Read, 1}, {Yield, 2}, {CloseRead, 2}, {CloseWrite, 3}}, ops)
+}
+
+func TestRun(t *testing.T) {
+	var h Harness
+	require.NoError(t, h.Run([]byte{0, 0, 255, 0, 255, 3, 3}))
+	require.NoError(t, h.Run([]byte{0, 0, 10, 7, 1, 0, 255}))
+	require.NoError(t, h.Run([]byte{0, 0, 255, 7, 3, 0, 255}))
+
+	// the writer that drops data
+	h.Wrap = func(r *pipe.Reader, w *pipe.Writer) (io.Reader, io.Writer) {
+		return r, lossyWriter{w}
+	}
+	require.Error(t, h.Run([]byte{0, 0, 10, 0, 10}))
+}
+
+type lossyWriter struct {
+	w io.Writer
+}
+
+func (l lossyWriter) Write(data []byte) (int, error) {
+	l.w.Write(data[1:])
+	return len(data), nil
+}
+
+func FuzzPipe(f *testing.F) {
+	(&Harness{}).Fuzz(f)
+}
+
+func FuzzFraming(f *testing.F) {
+	h := Harness{Wrap: func(r *pipe.Reader, w *pipe.Writer) (io.Reader, io.Writer) {
+		// bufio reads whole frames into its buffer
+		return bufio.NewReader(pipe.NewFramedReader(r, 0)), pipe.NewFramedWriter(w, 0)
+	}}
+	h.Fuzz(f)
+}
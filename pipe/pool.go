@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+)
+
+// pooledPipe is the pipe kept in the pool of its size
+type pooledPipe struct {
+	r *Reader
+	w *Writer
+}
+
+var pipePools [64]sync.Pool // by bit length of the buffer size
+
+// Get returns the pipe with buffer of at least max bytes from the pool of
+// its size class, or creates it as Pipe does. Return it by Put
+func Get(max int) (*Reader, *Writer) {
+	max = bufferSize(max)
+	if p, _ := pipePools[bitlen(uint(max))].Get().(*pooledPipe); p != nil {
+		return p.r, p.w
+	}
+	return pipe(max, false, false)
+}
+
+// Put closes and resets the pipe and returns it to the pool for Get. The
+// pipe must be quiescent as for Reset, its ends must not be used after Put.
+// The settings of the ends are cleared. The pipes Get doesn't return, e.g.
+// synchronized or growing, are left to the garbage collector
+func Put(r *Reader, w *Writer) {
+	if r == nil || w == nil || r.hdr != w.hdr || r.synchronized || w.synchronized ||
+		r.mask < 0 || r.grow != nil || r.remote != nil || r.spsc != nil || r.mpmc != nil || r.overflow != nil ||
+		(r.window != nil && r.window.cursors != nil) {
+		return
+	}
+	r.CloseRead()
+	if r.Reset() != nil {
+		return
+	}
+	// the timers of the settings refer to the ends
+	for _, b := range []*ringbuf{&r.ringbuf, &w.ringbuf} {
+		b.setDeadline(time.Time{})
+		b.SetIdleTimeout(0)
+	}
+	mem := r.mem
+	*r, *w = Reader{}, Writer{}
+	r.initWith(mem, false)
+	w.initFrom(&r.ringbuf, false)
+	pipePools[bitlen(uint(len(r.mem)))].Put(&pooledPipe{r: r, w: w})
+}
@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	r, w := Get(30000)
+	require.Equal(t, 32768, w.Cap())
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.SetWriteDeadline(time.Now().Add(-time.Second))
+	w.SetSpinPolicy(SpinPolicy{Spins: 1})
+	r.Mark()
+	Put(r, w)
+
+	r2, w2 := Get(32768)
+	require.Equal(t, 32768, w2.Cap())
+	require.False(t, w2.IsClosed())
+	require.Equal(t, 0, r2.Buffered())
+	require.Equal(t, DefaultSpin, w2.SpinPolicy())
+	_, err = r2.Rewind()
+	require.Equal(t, ErrNoMark, err)
+	_, err = w2.Write([]byte("world"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	n, err := r2.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(buf[:n]))
+	require.Equal(t, 5, r2.Occupancy().HighWater)
+	Put(r2, w2)
+
+	// other classes and unpooled pipes
+	r3, w3 := Get(100)
+	require.Equal(t, 128, w3.Cap())
+	Put(r3, w3)
+	sr, sw := SyncPipe(100)
+	Put(sr, sw)
+	require.False(t, sw.IsClosed())
+}
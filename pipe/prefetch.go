@@ -0,0 +1,44 @@
+package pipe
+
+import "io"
+
+// prefetcher reads what the pump has read ahead
+type prefetcher struct {
+	r   *Reader
+	src io.Reader
+}
+
+// Prefetch returns the reader of src that reads ahead up to bufSize bytes
+// in the background, so the reads of the slow source, e.g. the network,
+// are served from memory while it is read. The reads return the buffered
+// data without waiting to fill the buffer and return the error of src once
+// the data read before it is consumed. Close stops the read ahead and
+// closes src if it is io.Closer
+func Prefetch(src io.Reader, bufSize int) io.ReadCloser {
+	r, w := pipe(bufSize, false, false)
+	go func() {
+		_, err := w.ReadFrom(src)
+		w.CloseWithError(err)
+	}()
+	return &prefetcher{r: r, src: src}
+}
+
+func (p *prefetcher) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	return p.r.ReadAtLeast(data, 1)
+}
+
+// WriteTo writes the data without copying it to the buffer of the caller
+func (p *prefetcher) WriteTo(w io.Writer) (int64, error) {
+	return p.r.WriteTo(w)
+}
+
+func (p *prefetcher) Close() error {
+	p.r.CloseRead()
+	if c, ok := p.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
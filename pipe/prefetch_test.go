@@ -0,0 +1,54 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// slowReader returns one chunk per read after the delay
+type slowReader struct {
+	data  []byte
+	chunk int
+	delay time.Duration
+	reads int32
+}
+
+func (s *slowReader) Read(data []byte) (int, error) {
+	if len(s.data) == 0 {
+		return 0, errors.New("broken")
+	}
+	time.Sleep(s.delay)
+	atomic.AddInt32(&s.reads, 1)
+	n := copy(data[:minInt(len(data), s.chunk)], s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+func TestPrefetch(t *testing.T) {
+	src := &slowReader{data: bytes.Repeat([]byte("x"), 100), chunk: 10, delay: time.Millisecond}
+	p := Prefetch(src, 64)
+	time.Sleep(50 * time.Millisecond)
+	// read ahead up to the buffer
+	require.GreaterOrEqual(t, atomic.LoadInt32(&src.reads), int32(6))
+	buf := make([]byte, 100)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, 60)
+	rest, err := io.ReadAll(p)
+	require.EqualError(t, err, "broken")
+	require.Equal(t, 100, n+len(rest))
+	require.NoError(t, p.Close())
+
+	// close stops the read ahead
+	r, w := Pipe(16)
+	p = Prefetch(r, 16)
+	p.Close()
+	_, err = w.Write(make([]byte, 32))
+	require.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// ProtoWriter writes protobuf messages with uvarint size prefix, the stream
+// is compatible with protodelim. The package doesn't depend on protobuf, the
+// functions of google.golang.org/protobuf are passed to the constructor:
+//
+//	pipe.NewProtoWriter(w, max, proto.Size, proto.MarshalOptions{}.MarshalAppend)
+type ProtoWriter[M any] struct {
+	w       *Writer
+	max     int
+	size    func(M) int
+	marshal func([]byte, M) ([]byte, error)
+	hdr     [binary.MaxVarintLen64]byte
+	buf     []byte // messages that don't fit into the free region are marshaled here
+}
+
+// NewProtoWriter creates the writer of messages of up to max bytes to w,
+// non-positive max means no limit. size returns the marshaled size of the
+// message, marshalAppend appends the marshaled message to the slice
+func NewProtoWriter[M any](w *Writer, max int, size func(M) int, marshalAppend func([]byte, M) ([]byte, error)) *ProtoWriter[M] {
+	return &ProtoWriter[M]{w: w, max: maxFrameSize(max), size: size, marshal: marshalAppend}
+}
+
+// WriteMessage writes m. The message is marshaled right into the ring memory
+// unless it wraps around the end of memory or exceeds the buffer. It fails
+// with *FrameSizeError if the message exceeds the maximum size. It must not
+// be called concurrently
+func (p *ProtoWriter[M]) WriteMessage(m M) error {
+	n := p.size(m)
+	if n > p.max {
+		return &FrameSizeError{Size: n, Max: p.max}
+	}
+	hdr := p.hdr[:binary.PutUvarint(p.hdr[:], uint64(n))]
+	total := len(hdr) + n
+	if total <= p.w.maxCap() {
+		region, err := p.w.AcquireWrite(total)
+		if err != nil {
+			return err
+		}
+		if len(region) == total {
+			copy(region, hdr)
+			data, err := p.marshal(region[len(hdr):len(hdr):total], m)
+			if err != nil {
+				p.w.CommitWrite(0)
+				return err
+			}
+			if len(data) == n {
+				// marshaled in place unless the size was wrong
+				if n > 0 && &data[0] != &region[len(hdr)] {
+					copy(region[len(hdr):], data)
+				}
+				p.w.CommitWrite(total)
+				return nil
+			}
+		}
+		p.w.CommitWrite(0)
+	}
+	data, err := p.marshal(p.buf[:0], m)
+	if err != nil {
+		return err
+	}
+	p.buf = data[:0]
+	if len(data) != n {
+		n = len(data)
+		hdr = p.hdr[:binary.PutUvarint(p.hdr[:], uint64(n))]
+	}
+	if n > p.max {
+		return &FrameSizeError{Size: n, Max: p.max}
+	}
+	_, err = p.w.WriteBuffers(net.Buffers{hdr, data})
+	return err
+}
+
+// Close closes the pipe
+func (p *ProtoWriter[M]) Close() error {
+	return p.w.Close()
+}
+
+// ProtoReader reads protobuf messages written by ProtoWriter or protodelim:
+//
+//	pipe.NewProtoReader(r, max, proto.Unmarshal)
+type ProtoReader[M any] struct {
+	r         *Reader
+	max       int
+	unmarshal func([]byte, M) error
+	buf       []byte // messages wrapped around the end of memory are copied here
+	err       error  // sticky, the stream is out of sync after the oversized message
+}
+
+// NewProtoReader creates the reader of messages of up to max bytes from r,
+// non-positive max means no limit. unmarshal must not keep the data it's
+// given, it's the ring memory
+func NewProtoReader[M any](r *Reader, max int, unmarshal func([]byte, M) error) *ProtoReader[M] {
+	return &ProtoReader[M]{r: r, max: maxFrameSize(max), unmarshal: unmarshal}
+}
+
+// ReadMessage reads the next message into m. It's unmarshaled right from the
+// ring memory unless it wraps around the end of memory or exceeds the
+// buffer. The size prefix may span the wrap. It must not be called
+// concurrently
+func (p *ProtoReader[M]) ReadMessage(m M) error {
+	if p.err != nil {
+		return p.err
+	}
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return err
+	}
+	if size > uint64(p.max) {
+		p.err = &FrameSizeError{Size: frameSize(size), Max: p.max}
+		return p.err
+	}
+	n := int(size)
+	if n > 0 && n <= p.r.maxCap() {
+		if err = p.r.ReadWait(n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		view, err := p.r.AcquireRead()
+		if err != nil {
+			return err
+		}
+		if len(view) >= n {
+			err = p.unmarshal(view[:n], m)
+			p.r.ReleaseRead(n)
+			return err
+		}
+		p.r.ReleaseRead(0)
+	}
+	if cap(p.buf) < n {
+		p.buf = make([]byte, n)
+	}
+	if err = p.r.ReadFull(p.buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return p.unmarshal(p.buf[:n], m)
+}
+
+// Close closes the read end
+func (p *ProtoReader[M]) Close() error {
+	return p.r.CloseRead()
+}
@@ -0,0 +1,35 @@
+package pipe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoPipe(t *testing.T) {
+	type msg struct{ b []byte }
+	size := func(m *msg) int { return len(m.b) }
+	marshal := func(b []byte, m *msg) ([]byte, error) { return append(b, m.b...), nil }
+	unmarshal := func(b []byte, m *msg) error {
+		m.b = append(m.b[:0], b...)
+		return nil
+	}
+	r, w := Pipe(64)
+	pw := NewProtoWriter(w, 100, size, marshal)
+	pr := NewProtoReader(r, 50, unmarshal)
+	go func() {
+		for i := 0; i < 200; i++ {
+			pw.WriteMessage(&msg{bytes.Repeat([]byte{byte(i)}, i%51)})
+		}
+		pw.WriteMessage(&msg{make([]byte, 51)})
+		pw.Close()
+	}()
+	var m msg
+	for i := 0; i < 200; i++ {
+		require.NoError(t, pr.ReadMessage(&m))
+		require.Equal(t, string(bytes.Repeat([]byte{byte(i)}, i%51)), string(m.b))
+	}
+	require.Equal(t, &FrameSizeError{Size: 51, Max: 50}, pr.ReadMessage(&m))
+	require.Equal(t, &FrameSizeError{Size: 101, Max: 100}, pw.WriteMessage(&msg{make([]byte, 101)}))
+}
@@ -0,0 +1,62 @@
+package pipe
+
+// publishBatch holds the bytes written but not yet published, so the
+// writes of tiny chunks update the shared header once per batch
+type publishBatch struct {
+	writes, bytes int // thresholds, zero if unused
+	pending       int // bytes written past the published write position
+	held          int // writes held
+}
+
+// SetPublishBatch makes the writer publish its writes with one header
+// update per batch: once writes are held, once bytes are held, on Flush
+// or when the writer waits or closes. Zero threshold is unused, both zero
+// publish every write. The held bytes are invisible to the reader, the
+// batch trades the latency for the traffic on the shared header of the
+// high-frequency small writes. It panics for the synchronized, MPMC and
+// cloned writers and the writer that never waits for space, those have no
+// single producer owning the write position. It must not be called
+// concurrently with the writes
+func (w *Writer) SetPublishBatch(writes, bytes int) {
+	if w.synchronized || w.mpmc != nil || w.overflow != nil || w.refs != nil {
+		panic("writer can't batch publication")
+	}
+	w.flushBatch()
+	w.batch = nil
+	if writes > 0 || bytes > 0 {
+		w.batch = &publishBatch{writes: writes, bytes: bytes}
+	}
+}
+
+// hold holds n written bytes, it returns the bytes to publish once the
+// batch is full or 0
+func (p *publishBatch) hold(n int) int {
+	p.pending += n
+	p.held++
+	if (p.writes > 0 && p.held >= p.writes) || (p.bytes > 0 && p.pending >= p.bytes) {
+		return p.take()
+	}
+	return 0
+}
+
+// take empties the batch and returns its bytes
+func (p *publishBatch) take() int {
+	n := p.pending
+	p.pending, p.held = 0, 0
+	return n
+}
+
+// unpublished returns the bytes held by the batch of the end
+func (b *ringbuf) unpublished() uint64 {
+	if b.batch == nil {
+		return 0
+	}
+	return uint64(b.batch.pending)
+}
+
+// flushBatch publishes the bytes held by the batch of the end
+func (b *ringbuf) flushBatch() {
+	if b.batch != nil && b.batch.pending > 0 {
+		b.advertise(b.batch.take())
+	}
+}
@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishBatch(t *testing.T) {
+	r, w := Pipe(64)
+	w.SetPublishBatch(3, 0)
+	w.Write([]byte("a"))
+	w.WriteByte('b')
+	require.Equal(t, 0, r.Buffered())
+	require.Equal(t, 62, w.Available())
+	w.Write([]byte("c"))
+	require.Equal(t, 3, r.Buffered())
+
+	w.SetPublishBatch(0, 4)
+	w.Write([]byte("de"))
+	require.Equal(t, 3, r.Buffered())
+	w.Write([]byte("fg"))
+	require.Equal(t, 7, r.Buffered())
+	w.Write([]byte("h"))
+	require.NoError(t, w.Flush())
+	require.Equal(t, 8, r.Buffered())
+
+	// the waiting writer publishes what the reader waits for
+	w.SetPublishBatch(1000, 0)
+	go func() {
+		w.Write(make([]byte, 100))
+		w.Write([]byte("end"))
+		w.Close()
+	}()
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, b, 111)
+	require.Equal(t, "abcdefghend", string(b[:8])+string(b[108:]))
+
+	_, sw := SyncPipe(64)
+	require.Panics(t, func() { sw.SetPublishBatch(1, 0) })
+}
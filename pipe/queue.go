@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+type queueSlot[T any] struct {
+	seq uint64
+	val T
+}
+
+// Queue is the bounded lock-free queue for many producers and consumers.
+// Slots carry sequence numbers, so producers and consumers claim them with
+// one CAS each (D. Vyukov's design). Blocking waits and close have the pipe
+// semantics
+type Queue[T any] struct {
+	enq   uint64
+	_     [7]uint64
+	deq   uint64
+	_     [7]uint64
+	flags uint64
+	slots []queueSlot[T]
+	mask  uint64
+	wsig  chan struct{} // element pushed
+	rsig  chan struct{} // element popped
+}
+
+// NewQueue creates the queue for at least size elements
+func NewQueue[T any](size int) *Queue[T] {
+	if size < 2 {
+		size = 2
+	} else if (size & (size - 1)) != 0 {
+		size = 1 << bitlen(uint(size))
+	}
+	q := &Queue[T]{
+		slots: make([]queueSlot[T], size),
+		mask:  uint64(size - 1),
+		wsig:  make(chan struct{}, 1),
+		rsig:  make(chan struct{}, 1),
+	}
+	for i := range q.slots {
+		q.slots[i].seq = uint64(i)
+	}
+	return q
+}
+
+func (q *Queue[T]) Push(v T) error {
+	return q.PushContext(context.Background(), v)
+}
+
+// PushContext waits for the free slot and pushes v. It fails with io.EOF if
+// the queue is closed
+func (q *Queue[T]) PushContext(ctx context.Context, v T) error {
+	for {
+		if q.IsClosed() {
+			notify(q.rsig) // resume other pushers (if any)
+			return io.EOF
+		}
+		if q.TryPush(v) {
+			return nil
+		}
+		select {
+		case <-q.rsig:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryPush pushes v if there is the free slot. It never blocks
+func (q *Queue[T]) TryPush(v T) bool {
+	for {
+		pos := atomic.LoadUint64(&q.enq)
+		slot := &q.slots[pos&q.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		if seq == pos {
+			if atomic.CompareAndSwapUint64(&q.enq, pos, pos+1) {
+				slot.val = v
+				atomic.StoreUint64(&slot.seq, pos+1)
+				notify(q.wsig)
+				if pos+1-atomic.LoadUint64(&q.deq) < uint64(len(q.slots)) {
+					notify(q.rsig) // resume other pushers (if any)
+				}
+				return true
+			}
+		} else if seq < pos {
+			// full
+			return false
+		} else {
+			spin()
+		}
+	}
+}
+
+func (q *Queue[T]) Pop() (T, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext waits for the element and pops it. It fails with io.EOF if the
+// queue is closed and drained
+func (q *Queue[T]) PopContext(ctx context.Context) (T, error) {
+	for {
+		closed := q.IsClosed()
+		if v, ok := q.TryPop(); ok {
+			return v, nil
+		}
+		if closed {
+			notify(q.wsig) // resume other poppers (if any)
+			var zero T
+			return zero, io.EOF
+		}
+		select {
+		case <-q.wsig:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// TryPop pops the element if there is one. It never blocks
+func (q *Queue[T]) TryPop() (v T, ok bool) {
+	for {
+		pos := atomic.LoadUint64(&q.deq)
+		slot := &q.slots[pos&q.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		if seq == pos+1 {
+			if atomic.CompareAndSwapUint64(&q.deq, pos, pos+1) {
+				var zero T
+				v = slot.val
+				slot.val = zero // don't keep the reference
+				atomic.StoreUint64(&slot.seq, pos+q.mask+1)
+				notify(q.rsig)
+				if atomic.LoadUint64(&q.enq) > pos+1 {
+					notify(q.wsig) // resume other poppers (if any)
+				}
+				return v, true
+			}
+		} else if seq < pos+1 {
+			// empty
+			return v, false
+		} else {
+			spin()
+		}
+	}
+}
+
+// Len returns number of queued elements, including the ones being pushed
+func (q *Queue[T]) Len() int {
+	deq := atomic.LoadUint64(&q.deq)
+	enq := atomic.LoadUint64(&q.enq)
+	if enq < deq {
+		return 0
+	}
+	return int(enq - deq)
+}
+
+// Cap returns capacity of the queue
+func (q *Queue[T]) Cap() int {
+	return len(q.slots)
+}
+
+// Close closes the queue. Queued elements can still be popped
+func (q *Queue[T]) Close() error {
+	for {
+		flags := atomic.LoadUint64(&q.flags)
+		if (flags & closeFlag) != 0 {
+			return nil
+		}
+		if atomic.CompareAndSwapUint64(&q.flags, flags, flags|closeFlag) {
+			notify(q.wsig)
+			notify(q.rsig)
+			return nil
+		}
+	}
+}
+
+func (q *Queue[T]) IsClosed() bool {
+	return (atomic.LoadUint64(&q.flags) & closeFlag) != 0
+}
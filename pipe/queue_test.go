@@ -0,0 +1,110 @@
+package pipe
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	q := NewQueue[int](5)
+	require.Equal(t, 8, q.Cap())
+	for i := 0; i < 8; i++ {
+		require.True(t, q.TryPush(i))
+	}
+	require.False(t, q.TryPush(8))
+	require.Equal(t, 8, q.Len())
+	v, ok := q.TryPop()
+	require.True(t, ok)
+	require.Equal(t, 0, v)
+	q.Close()
+	require.Equal(t, io.EOF, q.Push(8))
+	for i := 1; i < 8; i++ {
+		v, err := q.Pop()
+		require.NoError(t, err)
+		require.Equal(t, i, v)
+	}
+	_, err := q.Pop()
+	require.Equal(t, io.EOF, err)
+
+	const producers, consumers, n = 8, 8, 10000
+	q = NewQueue[int](64)
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= n; j++ {
+				q.Push(j)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		q.Close()
+	}()
+	sums := make(chan int)
+	for i := 0; i < consumers; i++ {
+		go func() {
+			sum := 0
+			for {
+				v, err := q.Pop()
+				if err != nil {
+					break
+				}
+				sum += v
+			}
+			sums <- sum
+		}()
+	}
+	total := 0
+	for i := 0; i < consumers; i++ {
+		total += <-sums
+	}
+	require.Equal(t, producers*n*(n+1)/2, total)
+}
+
+func benchmark8x8(b *testing.B, push func(int), pop func() bool, close func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < b.N/8; j++ {
+				push(j)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close()
+	}()
+	var cwg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			for pop() {
+			}
+		}()
+	}
+	cwg.Wait()
+}
+
+func BenchmarkQueue8x8(b *testing.B) {
+	q := NewQueue[int](1024)
+	benchmark8x8(b, func(v int) { q.Push(v) }, func() bool {
+		_, err := q.Pop()
+		return err == nil
+	}, func() { q.Close() })
+}
+
+func BenchmarkChan8x8(b *testing.B) {
+	c := make(chan int, 1024)
+	benchmark8x8(b, func(v int) { c <- v }, func() bool {
+		_, ok := <-c
+		return ok
+	}, func() { close(c) })
+}
@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+// QUICStream is the bidirectional QUIC stream, e.g. the few lines wrapping
+// the stream of quic-go. Its Read returns *StreamError when the peer resets
+// the stream
+type QUICStream interface {
+	io.Reader
+	io.Writer
+	// Close ends the sending direction with FIN
+	Close() error
+	// CancelWrite aborts the sending direction with RESET_STREAM
+	CancelWrite(code uint64)
+	// CancelRead asks the peer to stop sending with STOP_SENDING
+	CancelRead(code uint64)
+}
+
+// StreamError is the application error code of the reset stream
+type StreamError struct {
+	Code uint64
+}
+
+func (e *StreamError) Error() string {
+	return "stream reset with code " + strconv.FormatUint(e.Code, 10)
+}
+
+// quicCloser stops the receiving direction of the stream
+type quicCloser struct {
+	s QUICStream
+}
+
+func (c quicCloser) Close() error {
+	c.s.CancelRead(0)
+	return nil
+}
+
+// QUICPipe bridges the QUIC stream through the pipes of bufSize bytes. The
+// close of the write end sends FIN after the written data, CloseWithError
+// resets the stream with the code of *StreamError or with errCode for
+// other errors. The close of the read end stops the peer from sending
+func QUICPipe(s QUICStream, bufSize int, errCode uint64) *Duplex {
+	d, rw, sr := newDuplex(bufSize, quicCloser{s})
+	d.run(func() {
+		_, err := rw.ReadFrom(s)
+		if err == ErrReadClosed {
+			s.CancelRead(0)
+		}
+		d.endRecv(rw, err)
+	}, func() {
+		for {
+			view, err := sr.AcquireRead()
+			if err == io.EOF {
+				if err = s.Close(); err != nil {
+					d.setErr(err)
+				}
+				return
+			}
+			if err != nil {
+				// closed with the error by the writer
+				var se *StreamError
+				if errors.As(err, &se) {
+					s.CancelWrite(se.Code)
+				} else {
+					s.CancelWrite(errCode)
+				}
+				return
+			}
+			n, err := s.Write(view)
+			sr.ReleaseRead(n)
+			if err != nil {
+				d.endSend(sr, err)
+				return
+			}
+		}
+	})
+	return d
+}
@@ -0,0 +1,52 @@
+package pipe
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memQUICStream struct {
+	*Reader
+	w *Writer
+}
+
+func memQUICStreams() (*memQUICStream, *memQUICStream) {
+	r1, w1 := SyncPipe(64)
+	r2, w2 := SyncPipe(64)
+	return &memQUICStream{r1, w2}, &memQUICStream{r2, w1}
+}
+
+func (s *memQUICStream) Write(data []byte) (int, error) { return s.w.Write(data) }
+func (s *memQUICStream) Close() error                   { return s.w.Close() }
+func (s *memQUICStream) CancelWrite(code uint64)        { s.w.CloseWithError(&StreamError{Code: code}) }
+func (s *memQUICStream) CancelRead(code uint64)         { s.Reader.CloseRead() }
+
+func TestQUICPipe(t *testing.T) {
+	a, b := memQUICStreams()
+	da := QUICPipe(a, 64, 1)
+	db := QUICPipe(b, 64, 1)
+	go func() {
+		da.Write([]byte("hello"))
+		da.CloseWrite()
+	}()
+	data, err := io.ReadAll(db)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	db.Writer().CloseWithError(&StreamError{Code: 42})
+	_, err = io.ReadAll(da)
+	require.Equal(t, &StreamError{Code: 42}, err)
+	require.Equal(t, &StreamError{Code: 42}, da.Wait())
+	require.NoError(t, db.Wait())
+
+	a, b = memQUICStreams()
+	da = QUICPipe(a, 64, 7)
+	da.Writer().CloseWithError(errors.New("boom"))
+	_, err = io.ReadAll(b)
+	require.Equal(t, &StreamError{Code: 7}, err)
+}
+
+// memQUICStream is the end of the in-memory QUIC stream
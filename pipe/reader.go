@@ -3,25 +3,41 @@ package pipe
 import (
 	"context"
 	"io"
-	"runtime"
 	"sync/atomic"
+	"time"
 )
 
 type Reader struct {
 	ringbuf
+	peekBuf  []byte
+	acquired int      // length of the region returned by AcquireRead
+	minRead  *minRead // nil unless the reads coalesce
+	clone    bool     // made by Clone, its close drops it
 }
 
 func (r *Reader) Read(data []byte) (int, error) {
+	return r.ReadContext(context.Background(), data)
+}
+
+// ReadContext is Read that can be cancelled by ctx while waiting for the lock or data
+func (r *Reader) ReadContext(ctx context.Context, data []byte) (int, error) {
 	toRead := len(data)
 	if toRead == 0 {
 		return 0, r.checkDeadline()
 	}
+	if r.mpmc != nil {
+		return r.readReserved(ctx, data)
+	}
+	if r.overwrites() {
+		return r.readOverwritten(ctx, data)
+	}
 	if r.synchronized {
-		err := r.lock()
+		err := r.lockWithContext(ctx)
 		if err != nil {
 			return 0, err
 		}
 	}
+	readed := 0
 	timeoutChan, exceed := r.timeoutChan()
 	if exceed {
 		if r.synchronized {
@@ -29,46 +45,62 @@ func (r *Reader) Read(data []byte) (int, error) {
 		}
 		return 0, timeoutError
 	}
-	readed := 0
+	var parked parking
+	defer parked.leave()
+	if r.minRead == nil && r.condWaits(ctx) {
+		parked.cs = &r.waiting.cond.data
+	}
 	for readed < toRead {
-		hs, closed, head, sz := r.loadHeader()
+		_, closed, head, sz := r.loadHeader()
 		if closed && sz == 0 {
 			if r.synchronized {
 				r.unlock()
 			}
 			notify(r.wsig) // resume other readers (if any)
-			return readed, io.EOF
+			return readed, r.closeErr()
+		}
+		nr, limitChan := r.coalesce(closed, minInt(sz, toRead-readed), toRead-readed)
+		if nr > 0 {
+			nr, limitChan = r.throttle(nr)
 		}
-		nr := minInt(sz, toRead-readed)
 		if nr > 0 {
-			if head > r.Cap()-nr {
+			if head > len(r.mem)-nr {
 				// wrapped
-				ll := r.Cap() - head
+				ll := len(r.mem) - head
 				copy(data[readed:readed+ll], r.mem[head:])
 				copy(data[readed+ll:readed+nr], r.mem[:nr-ll])
 			} else {
 				copy(data[readed:readed+nr], r.mem[head:head+nr])
 			}
-			for {
-				head = (head + nr) & r.mask
-				sz -= nr
-				if atomic.CompareAndSwapUint64(r.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
-			}
+			r.advance(nr)
 			readed += nr
-			notify(r.rsig)
 		} else {
+			if !r.park(&parked, &r.waiting.data) {
+				continue // check again before the first wait
+			}
+			start := r.waitStart()
+			if parked.cs != nil {
+				parked.waitCond(&r.ringbuf)
+				r.readBlocked(ctx, start)
+				continue
+			}
 			select {
 			case <-r.wsig:
-			case <-timeoutChan:
+			case <-limitChan:
+			case <-ctx.Done():
 				if r.synchronized {
 					r.unlock()
 				}
-				return readed, timeoutError
+				return readed, ctx.Err()
+			case <-timeoutChan:
+				if timeoutChan, exceed = r.timeoutChan(); exceed {
+					if r.synchronized {
+						r.unlock()
+					}
+					return readed, timeoutError
+				}
 			}
+			r.readBlocked(ctx, start)
 		}
 	}
 	if r.synchronized {
@@ -78,78 +110,202 @@ func (r *Reader) Read(data []byte) (int, error) {
 }
 
 func (r *Reader) ReadWithContext(ctx context.Context, data []byte) (int, error) {
-	toRead := len(data)
-	if toRead == 0 {
-		return 0, r.checkDeadline()
+	return r.ReadContext(ctx, data)
+}
+
+// ReadTimeout is Read that gives up waiting after d. The timeout fails with
+// the error implementing net.Error with Timeout() == true
+func (r *Reader) ReadTimeout(data []byte, d time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	n, err := r.ReadContext(ctx, data)
+	cancel()
+	if err == context.DeadlineExceeded {
+		err = timeoutError
+	}
+	return n, err
+}
+
+// ReadAtLeast reads at least min bytes into data, blocking until they are
+// available or the pipe is closed. Bytes buffered beyond min are read too, up
+// to len(data), without waiting. If the pipe is closed after fewer than min
+// bytes were read, it returns io.ErrUnexpectedEOF
+func (r *Reader) ReadAtLeast(data []byte, min int) (int, error) {
+	return r.ReadAtLeastWithContext(context.Background(), data, min)
+}
+
+func (r *Reader) ReadAtLeastWithContext(ctx context.Context, data []byte, min int) (int, error) {
+	if len(data) < min {
+		return 0, io.ErrShortBuffer
+	}
+	n, err := r.ReadContext(ctx, data[:min])
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	if n < len(data) {
+		if r.synchronized {
+			if err = r.lockWithContext(ctx); err != nil {
+				return n, nil
+			}
+		}
+		n += r.readNoWait(data[n:])
+		if r.synchronized {
+			r.unlock()
+		}
 	}
+	return n, nil
+}
+
+// ReadFull reads exactly len(data) bytes, blocking until they are available
+// or the pipe is closed
+func (r *Reader) ReadFull(data []byte) error {
+	return r.ReadFullWithContext(context.Background(), data)
+}
+
+func (r *Reader) ReadFullWithContext(ctx context.Context, data []byte) error {
+	_, err := r.ReadAtLeastWithContext(ctx, data, len(data))
+	return err
+}
+
+// TryRead reads up to len(data) buffered bytes. It never blocks and never
+// waits for the lock of a synchronized reader. ok is false if the pipe is
+// closed and drained
+func (r *Reader) TryRead(data []byte) (n int, ok bool) {
+	if r.synchronized && !r.tryLock() {
+		return 0, true
+	}
+	n = r.readNoWait(data)
+	if r.synchronized {
+		r.unlock()
+	}
+	if n == 0 {
+		closed, sz := r.loadAvail()
+		return 0, !closed || sz > 0
+	}
+	return n, true
+}
+
+// readNoWait copies up to len(data) buffered bytes without waiting for more
+func (r *Reader) readNoWait(data []byte) int {
+	if r.overwrites() {
+		return r.copyOverwritten(data)
+	}
+	_, _, head, sz := r.loadHeader()
+	nr := minInt(sz, len(data))
+	if nr > 0 && r.limit != nil {
+		nr, _ = r.limit.take(nr)
+	}
+	if nr == 0 {
+		return 0
+	}
+	if head > len(r.mem)-nr {
+		// wrapped
+		ll := len(r.mem) - head
+		copy(data[:ll], r.mem[head:])
+		copy(data[ll:nr], r.mem[:nr-ll])
+	} else {
+		copy(data, r.mem[head:head+nr])
+	}
+	r.advance(nr)
+	return nr
+}
+
+// Peek returns up to n buffered bytes without advancing the read position.
+// It blocks until at least one byte is available. The returned slice is
+// valid until the next read
+func (r *Reader) Peek(n int) ([]byte, error) {
+	return r.PeekWithContext(context.Background(), n)
+}
+
+func (r *Reader) PeekWithContext(ctx context.Context, n int) ([]byte, error) {
+	return r.peek(ctx, n, 1)
+}
+
+// peek returns up to n buffered bytes once at least min of them are
+// buffered or the pipe is closed
+func (r *Reader) peek(ctx context.Context, n, min int) ([]byte, error) {
+	if n <= 0 {
+		return nil, r.checkDeadline()
+	}
+	if n > r.maxCap() {
+		n = r.maxCap()
+	}
+	min = minInt(min, n)
 	if r.synchronized {
 		err := r.lockWithContext(ctx)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
-	readed := 0
-	timeoutChan, exc := r.timeoutChan()
-	if exc {
+	timeoutChan, exceed := r.timeoutChan()
+	if exceed {
 		if r.synchronized {
 			r.unlock()
 		}
-		return 0, timeoutError
+		return nil, timeoutError
 	}
-	for readed < toRead {
-		hs, closed, head, sz := r.loadHeader()
-		if closed && sz == 0 {
+	var parked parking
+	defer parked.leave()
+	for {
+		_, closed, head, sz := r.loadHeader()
+		if sz >= min || (closed && sz > 0) {
+			nr := minInt(sz, n)
+			var data []byte
+			if head > len(r.mem)-nr {
+				// wrapped
+				if cap(r.peekBuf) < nr {
+					r.peekBuf = make([]byte, nr)
+				}
+				data = r.peekBuf[:nr]
+				ll := len(r.mem) - head
+				copy(data[:ll], r.mem[head:])
+				copy(data[ll:], r.mem[:nr-ll])
+			} else {
+				data = r.mem[head : head+nr]
+			}
 			if r.synchronized {
 				r.unlock()
 			}
-			notify(r.wsig) // resume other readers (if any)
-			return readed, io.EOF
+			if nr < n && closed {
+				return data, r.closeErr()
+			}
+			return data, nil
 		}
-		nr := minInt(sz, toRead-readed)
-		if nr > 0 {
-			if head > r.Cap()-nr {
-				// wrapped
-				ll := r.Cap() - head
-				copy(data[readed:readed+ll], r.mem[head:])
-				copy(data[readed+ll:readed+nr], r.mem[:nr-ll])
-			} else {
-				copy(data[readed:readed+nr], r.mem[head:head+nr])
+		if closed {
+			if r.synchronized {
+				r.unlock()
 			}
-			for {
-				head = (head + nr) & r.mask
-				sz -= nr
-				nhs := (hs & headerFlagMask) | (uint64(head) << 32) | uint64(sz)
-				if atomic.CompareAndSwapUint64(r.pbits, hs, nhs) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
+			notify(r.wsig) // resume other readers (if any)
+			return nil, r.closeErr()
+		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
+		select {
+		case <-r.wsig:
+		case <-ctx.Done():
+			if r.synchronized {
+				r.unlock()
 			}
-			readed += nr
-			notify(r.rsig)
-		} else {
-			select {
-			case <-r.wsig:
-			case <-ctx.Done():
-				if r.synchronized {
-					r.unlock()
-				}
-				return readed, ctx.Err()
-			case <-timeoutChan:
+			return nil, ctx.Err()
+		case <-timeoutChan:
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
 				if r.synchronized {
 					r.unlock()
 				}
-				return readed, timeoutError
+				return nil, timeoutError
 			}
 		}
+		r.readBlocked(ctx, start)
 	}
-	if r.synchronized {
-		r.unlock()
-	}
-	return readed, nil
 }
 
-func (r *Reader) Peek(data []byte) (int, error) {
+// PeekInto copies up to len(data) buffered bytes without advancing the read
+// position. It never blocks
+func (r *Reader) PeekInto(data []byte) (int, error) {
 	if r.synchronized {
 		err := r.lock()
 		if err != nil {
@@ -161,9 +317,9 @@ func (r *Reader) Peek(data []byte) (int, error) {
 		nr = len(data)
 	}
 	if nr > 0 {
-		if head > r.Cap()-nr {
+		if head > len(r.mem)-nr {
 			// wrapped
-			ll := r.Cap() - head
+			ll := len(r.mem) - head
 			copy(data[:ll], r.mem[head:])
 			copy(data[ll:nr], r.mem[:nr-ll])
 		} else {
@@ -174,20 +330,30 @@ func (r *Reader) Peek(data []byte) (int, error) {
 		r.unlock()
 	}
 	if closed {
-		return nr, io.EOF
+		return nr, r.closeErr()
 	}
 	return nr, nil
 }
 
 func (r *Reader) Skip(toSkip int) (int, error) {
+	return r.SkipWithContext(context.Background(), toSkip)
+}
+
+// Discard advances the read position by n bytes without copying them,
+// blocking until the bytes arrive. It mirrors bufio.Reader.Discard
+func (r *Reader) Discard(n int) (int, error) {
+	return r.Skip(n)
+}
+
+func (r *Reader) SkipWithContext(ctx context.Context, toSkip int) (int, error) {
 	if toSkip <= 0 {
 		if r.IsClosed() {
-			return 0, io.EOF
+			return 0, r.closeErr()
 		}
 		return 0, nil
 	}
 	if r.synchronized {
-		err := r.lock()
+		err := r.lockWithContext(ctx)
 		if err != nil {
 			return 0, err
 		}
@@ -200,37 +366,42 @@ func (r *Reader) Skip(toSkip int) (int, error) {
 		}
 		return 0, timeoutError
 	}
+	var parked parking
+	defer parked.leave()
 	for skipped < toSkip {
-		hs, closed, head, sz := r.loadHeader()
+		_, closed, _, sz := r.loadHeader()
 		if closed && sz == 0 {
 			if r.synchronized {
 				r.unlock()
 			}
-			notify(r.wsig) // resume ohter waiters (if any)
-			return skipped, io.EOF
+			notify(r.wsig) // resume other readers (if any)
+			return skipped, r.closeErr()
 		}
 		n := minInt(sz, toSkip-skipped)
 		if n > 0 {
-			for {
-				head = (head + n) & r.mask
-				sz -= n
-				if atomic.CompareAndSwapUint64(r.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
-			}
+			r.advance(n)
 			skipped += n
-			notify(r.rsig)
 		} else {
+			if !r.park(&parked, &r.waiting.data) {
+				continue // check again before the first wait
+			}
+			start := r.waitStart()
 			select {
 			case <-r.wsig:
-			case <-timeoutChan:
+			case <-ctx.Done():
 				if r.synchronized {
 					r.unlock()
 				}
-				return skipped, timeoutError
+				return skipped, ctx.Err()
+			case <-timeoutChan:
+				if timeoutChan, exceed = r.timeoutChan(); exceed {
+					if r.synchronized {
+						r.unlock()
+					}
+					return skipped, timeoutError
+				}
 			}
+			r.readBlocked(ctx, start)
 		}
 	}
 	if r.synchronized {
@@ -239,107 +410,91 @@ func (r *Reader) Skip(toSkip int) (int, error) {
 	return skipped, nil
 }
 
-func (r *Reader) SkipWithContext(ctx context.Context, toSkip int) (int, error) {
-	if toSkip <= 0 {
-		if r.IsClosed() {
-			return 0, io.EOF
-		}
-		return 0, nil
-	}
+// AcquireRead waits for data and returns the view of buffered bytes at the
+// read position, so they can be parsed in place. The view is shorter than
+// buffered data when it wraps around the end of memory. Every successful
+// AcquireRead must be followed by ReleaseRead, synchronized reader holds the
+// lock in between
+func (r *Reader) AcquireRead() ([]byte, error) {
 	if r.synchronized {
-		err := r.lockWithContext(ctx)
+		err := r.lock()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
-	skipped := 0
-	timeoutChan, exceed := r.timeoutChan()
-	if exceed {
+	err := r.ReadWait(1)
+	if err != nil {
 		if r.synchronized {
 			r.unlock()
 		}
-		return 0, timeoutError
+		return nil, err
 	}
-	for skipped < toSkip {
-		hs, closed, head, sz := r.loadHeader()
-		if closed && sz == 0 {
-			if r.synchronized {
-				r.unlock()
-			}
-			notify(r.wsig) // resume other readers (if any)
-			return skipped, io.EOF
-		}
-		n := minInt(sz, toSkip-skipped)
-		if n > 0 {
-			for {
-				head = (head + n) & r.mask
-				sz -= n
-				if atomic.CompareAndSwapUint64(r.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
-			}
-			skipped += n
-			notify(r.rsig)
-		} else {
-			select {
-			case <-r.wsig:
-			case <-ctx.Done():
-				if r.synchronized {
-					r.unlock()
-				}
-				return skipped, ctx.Err()
-			case <-timeoutChan:
-				if r.synchronized {
-					r.unlock()
-				}
-				return skipped, timeoutError
-			}
-		}
+	_, _, head, sz := r.loadHeader()
+	end := minInt(head+sz, len(r.mem))
+	r.acquired = end - head
+	return r.mem[head:end], nil
+}
+
+// ReleaseRead consumes first n bytes of the view returned by AcquireRead
+func (r *Reader) ReleaseRead(n int) {
+	if n > r.acquired {
+		panic("release exceeds acquired region")
+	}
+	r.acquired = 0
+	if n > 0 {
+		r.advance(n)
 	}
 	if r.synchronized {
 		r.unlock()
 	}
-	return skipped, nil
+}
+
+// SetReadDeadline sets the deadline for blocked and future reads, zero
+// deadline means no deadline. Reads that exceed it fail with the error
+// implementing net.Error with Timeout() == true
+func (r *Reader) SetReadDeadline(deadline time.Time) error {
+	r.setDeadline(deadline)
+	return nil
+}
+
+// Tee makes the reader copy every consumed byte, skipped ones included, into
+// w straight from the ring memory. Errors of w are ignored, nil w stops the
+// copying. Tee must not be called concurrently with reads
+func (r *Reader) Tee(w io.Writer) {
+	r.tee = w
 }
 
 // Len returns number of buffered bytes availbale to immediate read
 func (r *Reader) Len() int {
-	return int(atomic.LoadUint64(r.pbits) & uint64(low31bits))
+	return r.dataAvail()
 }
 
-func (r *Reader) ReadWait(min int) error {
-	if min > r.Cap() {
-		return ErrOvercap
-	}
-	if min < 1 {
-		min = 1
-	}
-	timeoutChan, exceed := r.timeoutChan()
-	if exceed {
-		return timeoutError
-	}
-	for {
-		_, closed, _, sz := r.loadHeader()
-		if sz >= min {
-			return nil
-		}
-		if closed {
-			notify(r.wsig) // resume other readers (if any)
-			return io.EOF
-		}
-		select {
-		case <-r.wsig:
-		case <-timeoutChan:
-			return timeoutError
-		}
+// CloseRead closes the read end. The buffered data is discarded, further
+// reads and writes fail with ErrReadClosed. The clone is dropped instead
+func (r *Reader) CloseRead() error {
+	if r.clone {
+		r.drop()
+		return nil
+	}
+	return r.close(closeFlag|readCloseFlag, ErrReadClosed)
+}
+
+// Close closes the pipe, the clone is dropped instead
+func (r *Reader) Close() error {
+	if r.clone {
+		r.drop()
+		return nil
 	}
+	return r.ringbuf.Close()
+}
+
+func (r *Reader) ReadWait(min int) error {
+	return r.ReadWaitWithContext(context.Background(), min)
 }
 
 func (r *Reader) ReadWaitWithContext(ctx context.Context, min int) error {
-	if min > r.Cap() {
-		return ErrOvercap
+	if min > r.maxCap() {
+		return r.overcap(min)
 	}
 	if min < 1 {
 		min = 1
@@ -348,26 +503,55 @@ func (r *Reader) ReadWaitWithContext(ctx context.Context, min int) error {
 	if exceed {
 		return timeoutError
 	}
+	var parked parking
+	defer parked.leave()
 	for {
-		_, closed, _, sz := r.loadHeader()
+		closed, sz := r.loadAvail()
+		var minChan <-chan time.Time
 		if sz >= min {
-			return nil
+			if sz, minChan = r.coalesce(closed, sz, r.maxCap()); sz > 0 {
+				return nil
+			}
 		}
 		if closed {
 			notify(r.wsig) // resume other readers (if any)
-			return io.EOF
+			return r.closeErr()
 		}
+		if !r.park(&parked, &r.waiting.data) {
+			continue // check again before the first wait
+		}
+		start := r.waitStart()
 		select {
 		case <-r.wsig:
+		case <-minChan:
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-timeoutChan:
-			return timeoutError
+			if timeoutChan, exceed = r.timeoutChan(); exceed {
+				return timeoutError
+			}
 		}
+		r.readBlocked(ctx, start)
 	}
 }
 
 func (r *Reader) ReadByte() (byte, error) {
+	// fast path: byte is available and lock is free
+	if !r.deadline.isSet() && r.mpmc == nil && r.limit == nil && r.overflow == nil && (!r.synchronized || atomic.CompareAndSwapInt32(&r.lck, 0, 1)) {
+		_, _, head, sz := r.loadHeader()
+		ok := sz > 0
+		var c byte
+		if ok {
+			c = r.mem[head]
+			r.advance(1)
+		}
+		if r.synchronized {
+			r.unlock()
+		}
+		if ok {
+			return c, nil
+		}
+	}
 	var b [1]byte
 	_, err := r.Read(b[:])
 	return b[0], err
@@ -386,39 +570,47 @@ func (r *Reader) WriteTo(w io.Writer) (readed int64, err error) {
 		}
 		return 0, timeoutError
 	}
+	var parked parking
+	defer parked.leave()
 	for {
-		hs, closed, head, sz := r.loadHeader()
+		_, closed, head, sz := r.loadHeader()
 		if closed && sz == 0 {
 			notify(r.wsig) // resume other readers
 			if r.synchronized {
 				r.unlock()
 			}
-			return readed, nil
+			if err = r.closeErr(); err == io.EOF {
+				err = nil
+			}
+			return readed, err
 		}
+		sz, limitChan := r.coalesce(closed, sz, r.maxCap())
 		if sz > 0 {
+			sz, limitChan = r.throttle(sz)
+		}
+		if sz > 0 {
+			// occupied space is mem[head:head+sz], or mem[head:] + mem[:rest] when wrapped
+			end := minInt(head+sz, len(r.mem))
+			rest := sz - (end - head)
 			var n int
-			if head > r.Cap()-sz {
-				// wrapped
-				var n1, n2 int
-				n1, err := w.Write(r.mem[head:])
-				if err == nil {
-					n2, err = w.Write(r.mem[:sz-(r.Cap()-head)])
-				}
-				n = n1 + n2
-			} else {
-				n, err = w.Write(r.mem[head : head+sz])
+			n, err = w.Write(r.mem[head:end])
+			if n < end-head && err == nil {
+				err = io.ErrShortWrite
+			}
+			if n > 0 {
+				r.advance(n)
+				readed += int64(n)
 			}
-			readed += int64(n)
-			for {
-				head = (head + n) & r.mask
-				sz -= n
-				if atomic.CompareAndSwapUint64(r.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
+			if err == nil && rest > 0 {
+				n, err = w.Write(r.mem[:rest])
+				if n < rest && err == nil {
+					err = io.ErrShortWrite
+				}
+				if n > 0 {
+					r.advance(n)
+					readed += int64(n)
 				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
 			}
-			notify(r.rsig)
 			if err != nil {
 				if r.synchronized {
 					r.unlock()
@@ -426,14 +618,22 @@ func (r *Reader) WriteTo(w io.Writer) (readed int64, err error) {
 				return readed, err
 			}
 		} else {
+			if !r.park(&parked, &r.waiting.data) {
+				continue // check again before the first wait
+			}
+			start := r.waitStart()
 			select {
 			case <-r.wsig:
+			case <-limitChan:
 			case <-timeoutChan:
-				if r.synchronized {
-					r.unlock()
+				if timeoutChan, exceed = r.timeoutChan(); exceed {
+					if r.synchronized {
+						r.unlock()
+					}
+					return readed, timeoutError
 				}
-				return readed, timeoutError
 			}
+			r.readBlocked(context.Background(), start)
 		}
 	}
 }
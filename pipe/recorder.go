@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Recorder keeps the most recent data written to it, e.g. the last 64KB of
+// debug output of the connection to dump on error. Writes never block, they
+// overwrite the oldest data
+type Recorder struct {
+	r *Reader
+	w *Writer
+}
+
+// NewRecorder creates the recorder of the last max bytes, max is rounded up
+// as the pipe buffer size
+func NewRecorder(max int) *Recorder {
+	r, w := OverflowPipe(max, DropOldest)
+	return &Recorder{r: r, w: w}
+}
+
+// Write records data. It may be called concurrently
+func (rec *Recorder) Write(data []byte) (int, error) {
+	return rec.w.Write(data)
+}
+
+// WriteString records s
+func (rec *Recorder) WriteString(s string) (int, error) {
+	return rec.w.WriteString(s)
+}
+
+// Overwritten returns number of bytes overwritten before they were read
+func (rec *Recorder) Overwritten() int64 {
+	return rec.w.Dropped()
+}
+
+// Len returns number of recorded bytes
+func (rec *Recorder) Len() int {
+	return rec.r.Buffered()
+}
+
+// Cap returns capacity of the recorder
+func (rec *Recorder) Cap() int {
+	return rec.w.Cap()
+}
+
+// Reader returns the read end that consumes the recorded data as the stream
+func (rec *Recorder) Reader() *Reader {
+	return rec.r
+}
+
+// Snapshot returns the copy of the recorded data without consuming it. It
+// may be called concurrently with writes, the copy is made under the lock
+// of the writer. The closed recorder has no writers, its data is copied
+// without the lock
+func (rec *Recorder) Snapshot() []byte {
+	r := rec.r
+	if rec.w.lock() == nil {
+		defer rec.w.unlock()
+	}
+	rpos := atomic.LoadUint64(&r.hdr.rpos)
+	n := int(atomic.LoadUint64(&r.hdr.wpos) - rpos)
+	buf := make([]byte, n)
+	pos := r.offset(rpos)
+	if pos > len(r.mem)-n {
+		// wrapped
+		ll := len(r.mem) - pos
+		copy(buf[:ll], r.mem[pos:])
+		copy(buf[ll:], r.mem[:n-ll])
+	} else {
+		copy(buf, r.mem[pos:pos+n])
+	}
+	return buf
+}
+
+// WriteTo writes the snapshot of the recorded data to w
+func (rec *Recorder) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(rec.Snapshot())
+	return int64(n), err
+}
+
+// Close closes the recorder, the recorded data can still be read
+func (rec *Recorder) Close() error {
+	return rec.w.Close()
+}
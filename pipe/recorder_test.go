@@ -0,0 +1,51 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(64)
+	var all bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(io.MultiWriter(rec, &all), "line %d\n", i)
+	}
+	require.Equal(t, 64, rec.Len())
+	require.Equal(t, int64(all.Len()-64), rec.Overwritten())
+	require.Equal(t, string(all.Bytes()[all.Len()-64:]), string(rec.Snapshot()))
+	var dump bytes.Buffer
+	rec.WriteTo(&dump)
+	require.Equal(t, string(rec.Snapshot()), dump.String())
+	rec.Close()
+	require.Equal(t, dump.String(), string(rec.Snapshot()))
+	res, err := io.ReadAll(rec.Reader())
+	require.NoError(t, err)
+	require.Equal(t, dump.String(), string(res))
+	require.Equal(t, 0, rec.Len())
+
+	// the snapshot taken while writing holds whole records
+	rec = NewRecorder(64)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var b [8]byte
+		for i := uint64(1); i <= 100000; i++ {
+			binary.BigEndian.PutUint64(b[:], i)
+			rec.Write(b[:])
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		requireRecords(t, bytes.NewReader(rec.Snapshot()))
+	}
+}
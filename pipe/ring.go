@@ -0,0 +1,190 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// Ring is the bounded queue of elements with the pipe semantics: blocking,
+// non-blocking and context-aware push and pop, and close that lets the
+// consumers drain the queued elements
+type Ring[T any] struct {
+	hdr   header
+	buf   []T
+	mask  int
+	wsig  chan struct{} // element pushed
+	rsig  chan struct{} // element popped
+	plock chan struct{} // serializes pushers
+	qlock chan struct{} // serializes poppers
+}
+
+// NewRing creates the ring for at least size elements
+func NewRing[T any](size int) *Ring[T] {
+	if size < 1 {
+		size = 1
+	} else if (size & (size - 1)) != 0 {
+		size = 1 << bitlen(uint(size))
+	}
+	return &Ring[T]{
+		buf:   make([]T, size),
+		mask:  size - 1,
+		wsig:  make(chan struct{}, 1),
+		rsig:  make(chan struct{}, 1),
+		plock: make(chan struct{}, 1),
+		qlock: make(chan struct{}, 1),
+	}
+}
+
+func (q *Ring[T]) Push(v T) error {
+	return q.PushContext(context.Background(), v)
+}
+
+// PushContext waits for the free slot and pushes v. It fails with io.EOF if
+// the ring is closed
+func (q *Ring[T]) PushContext(ctx context.Context, v T) error {
+	select {
+	case q.plock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-q.plock }()
+	for {
+		if q.IsClosed() {
+			return io.EOF
+		}
+		if q.push(v) {
+			return nil
+		}
+		select {
+		case <-q.rsig:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryPush pushes v if there is the free slot. It never blocks
+func (q *Ring[T]) TryPush(v T) bool {
+	select {
+	case q.plock <- struct{}{}:
+	default:
+		return false
+	}
+	ok := !q.IsClosed() && q.push(v)
+	<-q.plock
+	return ok
+}
+
+func (q *Ring[T]) push(v T) bool {
+	wpos := atomic.LoadUint64(&q.hdr.wpos)
+	if wpos-atomic.LoadUint64(&q.hdr.rpos) >= uint64(len(q.buf)) {
+		return false
+	}
+	q.buf[wpos&uint64(q.mask)] = v
+	atomic.StoreUint64(&q.hdr.wpos, wpos+1)
+	notify(q.wsig)
+	return true
+}
+
+func (q *Ring[T]) Pop() (T, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext waits for the element and pops it. It fails with io.EOF if the
+// ring is closed and drained
+func (q *Ring[T]) PopContext(ctx context.Context) (v T, err error) {
+	select {
+	case q.qlock <- struct{}{}:
+	case <-ctx.Done():
+		return v, ctx.Err()
+	}
+	defer func() { <-q.qlock }()
+	for {
+		closed := q.IsClosed()
+		var ok bool
+		if v, ok = q.pop(); ok {
+			return v, nil
+		}
+		if closed {
+			notify(q.wsig) // resume other poppers (if any)
+			return v, io.EOF
+		}
+		select {
+		case <-q.wsig:
+		case <-ctx.Done():
+			return v, ctx.Err()
+		}
+	}
+}
+
+// TryPop pops the element if there is one. It never blocks
+func (q *Ring[T]) TryPop() (v T, ok bool) {
+	select {
+	case q.qlock <- struct{}{}:
+	default:
+		return v, false
+	}
+	v, ok = q.pop()
+	<-q.qlock
+	return v, ok
+}
+
+func (q *Ring[T]) pop() (v T, ok bool) {
+	rpos := atomic.LoadUint64(&q.hdr.rpos)
+	if atomic.LoadUint64(&q.hdr.wpos) == rpos {
+		return v, false
+	}
+	var zero T
+	pos := rpos & uint64(q.mask)
+	v = q.buf[pos]
+	q.buf[pos] = zero // don't keep the reference
+	atomic.StoreUint64(&q.hdr.rpos, rpos+1)
+	notify(q.rsig)
+	return v, true
+}
+
+// Peek returns the next element without popping it. It never blocks
+func (q *Ring[T]) Peek() (v T, ok bool) {
+	select {
+	case q.qlock <- struct{}{}:
+	default:
+		return v, false
+	}
+	rpos := atomic.LoadUint64(&q.hdr.rpos)
+	if atomic.LoadUint64(&q.hdr.wpos) != rpos {
+		v, ok = q.buf[rpos&uint64(q.mask)], true
+	}
+	<-q.qlock
+	return v, ok
+}
+
+// Len returns number of queued elements
+func (q *Ring[T]) Len() int {
+	rpos := atomic.LoadUint64(&q.hdr.rpos)
+	return int(atomic.LoadUint64(&q.hdr.wpos) - rpos)
+}
+
+// Cap returns capacity of the ring
+func (q *Ring[T]) Cap() int {
+	return len(q.buf)
+}
+
+// Close closes the ring. Queued elements can still be popped
+func (q *Ring[T]) Close() error {
+	for {
+		flags := atomic.LoadUint64(&q.hdr.flags)
+		if (flags & closeFlag) != 0 {
+			return nil
+		}
+		if atomic.CompareAndSwapUint64(&q.hdr.flags, flags, flags|closeFlag) {
+			notify(q.wsig)
+			notify(q.rsig)
+			return nil
+		}
+	}
+}
+
+func (q *Ring[T]) IsClosed() bool {
+	return (atomic.LoadUint64(&q.hdr.flags) & closeFlag) != 0
+}
@@ -0,0 +1,70 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingElements(t *testing.T) {
+	q := NewRing[*int](3)
+	require.Equal(t, 4, q.Cap())
+	for i := 0; i < 4; i++ {
+		v := i
+		require.True(t, q.TryPush(&v))
+	}
+	v := 4
+	require.False(t, q.TryPush(&v))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded, q.PushContext(ctx, &v))
+	cancel()
+	p, ok := q.Peek()
+	require.True(t, ok)
+	require.Equal(t, 0, *p)
+	require.Equal(t, 4, q.Len())
+	q.Close()
+	require.Equal(t, io.EOF, q.Push(&v))
+	for i := 0; i < 4; i++ {
+		p, err := q.Pop()
+		require.NoError(t, err)
+		require.Equal(t, i, *p)
+	}
+	_, err := q.Pop()
+	require.Equal(t, io.EOF, err)
+
+	const producers, n = 4, 10000
+	qi := NewRing[int](16)
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= n; j++ {
+				qi.Push(j)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		qi.Close()
+	}()
+	sums := make(chan int)
+	for i := 0; i < 2; i++ {
+		go func() {
+			sum := 0
+			for {
+				v, err := qi.Pop()
+				if err != nil {
+					break
+				}
+				sum += v
+			}
+			sums <- sum
+		}()
+	}
+	require.Equal(t, producers*n*(n+1)/2, <-sums+<-sums)
+}
@@ -4,14 +4,14 @@ import (
 	"context"
 	"errors"
 	"io"
-	"runtime"
 	"sync/atomic"
 	"time"
-
-	"github.com/pi/goal/md"
 )
 
 var ErrOvercap = errors.New("Buffer overcap")
+var ErrReadClosed = errors.New("Read end closed")
+var ErrNotClosed = errors.New("Pipe is not closed")
+var ErrAborted = errors.New("Pipe aborted")
 
 type timeoutErrorType int
 
@@ -27,35 +27,74 @@ func (e timeoutErrorType) Temporary() bool {
 
 var timeoutError = timeoutErrorType(0)
 
+type closeError struct {
+	err error
+}
+
 func notify(c chan struct{}) {
+	schedYield(SchedNotify)
+	signal(c)
+}
+
+// signal is notify without the sched point
+func signal(c chan struct{}) {
 	select {
 	case c <- struct{}{}:
 	default:
 	}
 }
 
-type ringbuf struct {
-	pbits *uint64 // highest bit - close flag. next 31 bits: read pos, next bit - unused, next 31 bits: read avail
-	mem   []byte
-	mask  int
-	wsig  chan struct{}
-	rsig  chan struct{}
+// header is shared by both ends of the pipe. Read and write counters are
+// monotonic totals of bytes moved, so buffer size is limited only by memory
+type header struct {
+	rpos  uint64 // total bytes read
+	_     [7]uint64
+	wpos  uint64 // total bytes written
+	_     [7]uint64
+	flags uint64 // closeFlag, readCloseFlag, abortFlag
+}
 
-	deadline time.Duration
-	timeoutC <-chan time.Time
+type ringbuf struct {
+	hdr     *header
+	mem     []byte
+	mask    int // len(mem)-1 for power of two sizes, -1 otherwise
+	wsig    chan struct{}
+	rsig    chan struct{}
+	cerr    *atomic.Value // closeError, set once by the first close
+	grow    *growth       // nil unless the buffer is auto-growing
+	remote  *remote       // nil unless the pipe is shared with other processes
+	spsc    *spscCursor   // nil unless the pipe is single-producer single-consumer
+	mpmc    *mpmcState    // nil unless the pipe is multi-producer multi-consumer
+	occ     *occupancy
+	waiting *waiting // the goroutines parked on wsig and rsig
+
+	overflow *overflowState // nil unless the writer never waits for space
+
+	deadline deadline
+	tee      io.Writer     // read end only, receives the consumed bytes
+	busyPoll time.Duration // read end only, the poll before parking
+	window   *window       // read end only, nil unless marked once
+	limit    *rateLimit    // nil unless the end is throttled
+	nagle    *nagle        // write end only, nil unless the wakeups are deferred
+	batch    *publishBatch // write end only, nil unless the publication is batched
+	idle     *idleWatch    // nil unless the end watches the pipe for idleness
+	stats    Stats         // nil unless the events are reported
+
+	tracer         StallTracer // nil unless the waits are traced
+	stallThreshold time.Duration
 
 	synchronized bool
 	lsig         chan struct{}
 	lck          int32
 	lq           int32
+	spinPolicy   *SpinPolicy // nil if DefaultSpin
+	adapt        *adaptive   // nil unless the spin policy is adaptive
+	locker       *ringbuf    // the end whose lock the clone shares, nil if none
 }
 
-const low63bits = ^uint64(0) >> 1
-const low31bits = ^uint32(0) >> 1
-
-const closeFlag = low63bits + 1
-
-const headerFlagMask = closeFlag
+const closeFlag = uint64(1)
+const readCloseFlag = uint64(2)
+const abortFlag = uint64(4) // the buffered data is discarded
 
 const defaultBufferSize = 32 * 1024
 const minBufferSize = 8
@@ -95,7 +134,8 @@ func bitlen(x uint) (n uint) {
 	return n
 }
 
-func (b *ringbuf) init(max int, synchronized bool) {
+// bufferSize returns the size of the buffer of at least max bytes
+func bufferSize(max int) int {
 	if max == 0 {
 		max = defaultBufferSize
 	} else if max < minBufferSize {
@@ -104,15 +144,26 @@ func (b *ringbuf) init(max int, synchronized bool) {
 		// round up to power of two
 		max = 1 << bitlen(uint(max))
 	}
-	b.initWith(make([]byte, max), synchronized)
+	return max
+}
+
+func (b *ringbuf) init(max int, synchronized bool) {
+	b.initWith(make([]byte, bufferSize(max)), synchronized)
 }
 
 func (b *ringbuf) initWith(mem []byte, synchronized bool) {
 	b.mem = mem
-	b.mask = len(mem) - 1
-	b.pbits = new(uint64)
+	b.mask = -1
+	if (len(mem) & (len(mem) - 1)) == 0 {
+		b.mask = len(mem) - 1
+	}
+	b.hdr = &header{}
 	b.wsig = make(chan struct{}, 1)
 	b.rsig = make(chan struct{}, 1)
+	b.cerr = &atomic.Value{}
+	b.cerr.Store(closeError{})
+	b.occ = &occupancy{}
+	b.waiting = &waiting{}
 
 	if synchronized {
 		b.synchronized = true
@@ -121,70 +172,337 @@ func (b *ringbuf) initWith(mem []byte, synchronized bool) {
 }
 
 func (b *ringbuf) initFrom(src *ringbuf, sync bool) {
-	b.pbits = src.pbits
+	b.hdr = src.hdr
 	b.mem = src.mem
 	b.mask = src.mask
 	b.wsig = src.wsig
 	b.rsig = src.rsig
+	b.cerr = src.cerr
+	b.grow = src.grow
+	b.remote = src.remote
+	b.occ = src.occ
+	b.waiting = src.waiting
 	if sync {
 		b.synchronized = true
 		b.lsig = make(chan struct{}, 1)
 	}
 }
 
-func (b *ringbuf) loadHeader() (hs uint64, closed bool, readPos int, readAvail int) {
-	hs = atomic.LoadUint64(b.pbits)
-	closed = (hs & closeFlag) != 0
-	readPos = int((hs >> 32) & uint64(low31bits))
-	readAvail = int(hs & uint64(low31bits))
+// loadHeader returns the flags, the read position in mem and the number of
+// bytes available to read. Flags are loaded first, so the reader that sees
+// the close sees all data written before it. wpos is loaded before rpos, so
+// the reader never overestimates data and the writer never overestimates space
+func (b *ringbuf) loadHeader() (flags uint64, closed bool, readPos int, readAvail int) {
+	if b.spsc != nil {
+		return b.loadCached()
+	}
+	flags = atomic.LoadUint64(&b.hdr.flags)
+	closed = (flags & closeFlag) != 0
+	wpos := atomic.LoadUint64(&b.hdr.wpos) + b.unpublished()
+	rpos := atomic.LoadUint64(&b.hdr.rpos)
+	if b.window != nil {
+		rpos = b.window.pos(rpos)
+	}
+	if b.grow != nil {
+		// memory is loaded after counters, so it holds all the data counted
+		b.loadMem()
+	}
+	readPos = b.offset(rpos)
+	if (flags&(readCloseFlag|abortFlag)) == 0 && wpos > rpos {
+		readAvail = int(wpos - rpos)
+	}
 	return
 }
 
+// loadAvail is loadHeader for callers that don't touch the memory. Unlike
+// loadHeader it may be called without holding the lock of the growing buffer
+func (b *ringbuf) loadAvail() (closed bool, readAvail int) {
+	flags := atomic.LoadUint64(&b.hdr.flags)
+	wpos := atomic.LoadUint64(&b.hdr.wpos) + b.unpublished()
+	rpos := atomic.LoadUint64(&b.hdr.rpos)
+	if b.window != nil {
+		rpos = b.window.pos(rpos)
+	}
+	if (flags&(readCloseFlag|abortFlag)) == 0 && wpos > rpos {
+		readAvail = int(wpos - rpos)
+	}
+	return (flags & closeFlag) != 0, readAvail
+}
+
+// offset returns the position in mem of the byte counted by c
+func (b *ringbuf) offset(c uint64) int {
+	if b.mask >= 0 {
+		return int(c & uint64(b.mask))
+	}
+	return int(c % uint64(len(b.mem)))
+}
+
+// wrap folds the position past the end of mem back to its start
+func (b *ringbuf) wrap(pos int) int {
+	if pos >= len(b.mem) {
+		pos -= len(b.mem)
+	}
+	return pos
+}
+
+// advance moves the read position forward by n bytes and wakes writers
+func (b *ringbuf) advance(n int) {
+	rpos := atomic.LoadUint64(&b.hdr.rpos)
+	free := n
+	if b.window != nil {
+		rpos = b.window.pos(rpos)
+		free = b.window.retain(n, len(b.mem))
+	}
+	if b.tee != nil {
+		pos := b.offset(rpos)
+		end := minInt(pos+n, len(b.mem))
+		b.tee.Write(b.mem[pos:end])
+		if rest := n - (end - pos); rest > 0 {
+			b.tee.Write(b.mem[:rest])
+		}
+	}
+	if b.stats != nil {
+		b.stats.Read(n)
+	}
+	if free > 0 {
+		b.free(uint64(free))
+	}
+}
+
+// free frees n bytes consumed by the read end and wakes writers. The data
+// of the cloned reader is freed once its clones read it too
+func (b *ringbuf) free(n uint64) {
+	if b.window != nil && b.window.cursors != nil {
+		if n = b.window.cursors.free(b.window, n, &b.hdr.rpos); n == 0 {
+			return
+		}
+	} else {
+		atomic.AddUint64(&b.hdr.rpos, n)
+	}
+	b.notifySpace()
+	if b.remote != nil {
+		b.remote.wakeSpace()
+	}
+}
+
+// publish makes n bytes at the write position available and wakes readers,
+// or holds them in the batch of the end
+func (b *ringbuf) publish(n int) {
+	if b.batch != nil {
+		if n = b.batch.hold(n); n == 0 {
+			return
+		}
+	}
+	b.advertise(n)
+}
+
+// advertise moves the write position of the header past n bytes
+func (b *ringbuf) advertise(n int) {
+	wpos := atomic.AddUint64(&b.hdr.wpos, uint64(n))
+	sz := int64(wpos - atomic.LoadUint64(&b.hdr.rpos))
+	b.occ.observe(sz)
+	if b.nagle == nil || !b.nagle.hold(int(sz)) {
+		b.notifyData()
+	}
+	if b.stats != nil {
+		b.stats.Written(n)
+	}
+	if b.remote != nil {
+		b.remote.wakeData()
+	}
+}
+
 func (b *ringbuf) dataAvail() int {
-	return int(atomic.LoadUint64(b.pbits) & uint64(low31bits))
+	_, sz := b.loadAvail()
+	return sz
 }
 
 func (b *ringbuf) spaceAvail() int {
 	return b.Cap() - b.dataAvail()
 }
 
+// Buffered returns number of bytes available to read
+func (b *ringbuf) Buffered() int {
+	return b.dataAvail()
+}
+
+// Free returns number of bytes that can be written without blocking
+func (b *ringbuf) Free() int {
+	return b.spaceAvail()
+}
+
 func (b *ringbuf) Close() error {
+	return b.closeWithError(io.EOF)
+}
+
+func (b *ringbuf) closeWithError(err error) error {
+	return b.close(closeFlag, err)
+}
+
+// Abort closes the pipe and discards the buffered data at once, so the
+// reads and writes fail with err from their next call, even after the
+// close of the pipe that kept its data. nil err means ErrAborted
+func (b *ringbuf) Abort(err error) error {
+	if err == nil {
+		err = ErrAborted
+	}
+	if (atomic.LoadUint64(&b.hdr.flags) & abortFlag) != 0 {
+		return nil
+	}
+	b.cerr.Store(closeError{err})
+	return b.close(closeFlag|abortFlag, err)
+}
+
+// close sets the flags in header and wakes all waiters.
+// Only the error of the first close is kept
+func (b *ringbuf) close(flags uint64, err error) error {
+	if b.spsc != nil {
+		atomic.StoreUint32(&b.spsc.closed, 1)
+	}
+	b.cerr.CompareAndSwap(closeError{}, closeError{err})
 	for {
-		hs := atomic.LoadUint64(b.pbits)
-		if ((hs & closeFlag) != 0) || atomic.CompareAndSwapUint64(b.pbits, hs, hs|closeFlag) {
-			if (hs & closeFlag) == 0 {
+		hs := atomic.LoadUint64(&b.hdr.flags)
+		if ((hs & flags) == flags) || atomic.CompareAndSwapUint64(&b.hdr.flags, hs, hs|flags) {
+			if (hs & flags) != flags {
+				if b.stats != nil {
+					b.stats.Closed(err)
+				}
 				notify(b.rsig)
 				notify(b.wsig)
+				b.waiting.wakeClones()
+				b.waiting.wakeCond()
 				if b.synchronized {
 					notify(b.lsig)
 				}
+				if b.remote != nil {
+					b.remote.wakeData()
+					b.remote.wakeSpace()
+				}
 			}
 			return nil
 		}
-		runtime.Gosched()
+		spin()
 	}
 }
 
-/*
-func (b *ringbuf) Reopen() {
-	b.rsig = make(chan struct{}, 1)
-	b.wsig = make(chan struct{}, 1)
-	b.lsig = make(chan struct{}, 1)
-	*b.pbits = 0 // reset head and size
-	b.lck = 0
-	b.lq = 0
-}*/
+// Reset reopens the closed pipe for reuse without reallocating its memory.
+// Buffered data is discarded. The goroutines still blocked on either end, or
+// on the lock of this end, are woken and fail with the close before the pipe
+// reopens, Reset waits for them to return. No operation may start before
+// Reset returns. ErrNotClosed is returned for the open pipe
+func (b *ringbuf) Reset() error {
+	if !b.IsClosed() {
+		return ErrNotClosed
+	}
+	for b.stragglers() {
+		notify(b.wsig)
+		b.waiting.wakeClones()
+		notify(b.rsig)
+		b.waiting.wakeCond()
+		if b.synchronized {
+			notify(b.lsig)
+		}
+		spin()
+	}
+	// counters stay monotonic, so the cached ones are never ahead
+	wpos := atomic.LoadUint64(&b.hdr.wpos)
+	if b.mpmc != nil {
+		atomic.StoreUint64(&b.mpmc.wres, wpos)
+		atomic.StoreUint64(&b.mpmc.rres, wpos)
+	}
+	atomic.StoreUint64(&b.hdr.rpos, wpos)
+	// drop stale wakeups
+	select {
+	case <-b.wsig:
+	default:
+	}
+	select {
+	case <-b.rsig:
+	default:
+	}
+	if b.synchronized {
+		select {
+		case <-b.lsig:
+		default:
+		}
+	}
+	if b.window != nil && b.window.cursors != nil {
+		b.window.cursors.rebase(wpos)
+	} else if b.window != nil {
+		*b.window = window{}
+	}
+	b.cerr.Store(closeError{})
+	if b.spsc != nil {
+		atomic.StoreUint32(&b.spsc.closed, 0)
+	}
+	atomic.StoreUint64(&b.hdr.flags, 0)
+	return nil
+}
+
+// stragglers returns true while goroutines are parked on the signals of
+// the pipe or wait for the lock of the end
+func (b *ringbuf) stragglers() bool {
+	if c := b.waiting.cond; c != nil && (atomic.LoadInt32(&c.data.waiters) > 0 || atomic.LoadInt32(&c.space.waiters) > 0) {
+		return true
+	}
+	return atomic.LoadInt32(&b.waiting.data) > 0 || atomic.LoadInt32(&b.waiting.space) > 0 ||
+		atomic.LoadInt32(&b.lq) > 0
+}
+
+// closeErr returns the error the pipe was closed with
+func (b *ringbuf) closeErr() error {
+	if (atomic.LoadUint64(&b.hdr.flags) & readCloseFlag) != 0 {
+		return ErrReadClosed
+	}
+	if err := b.cerr.Load().(closeError).err; err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// writeErr returns the error for writes into the closed pipe
+func (b *ringbuf) writeErr() error {
+	if (atomic.LoadUint64(&b.hdr.flags) & readCloseFlag) != 0 {
+		return ErrReadClosed
+	}
+	if err := b.cerr.Load().(closeError).err; err == ErrIdle || (atomic.LoadUint64(&b.hdr.flags)&abortFlag) != 0 {
+		return err
+	}
+	return io.EOF
+}
 
 func (b *ringbuf) IsClosed() bool {
-	return (atomic.LoadUint64(b.pbits) & closeFlag) != 0
+	return (atomic.LoadUint64(&b.hdr.flags) & closeFlag) != 0
 }
 
 // Cap returns capacity of the buffer
 func (b *ringbuf) Cap() int {
+	if b.grow != nil {
+		return len(b.grow.load())
+	}
+	return len(b.mem)
+}
+
+// maxCap returns the size the buffer may grow to
+func (b *ringbuf) maxCap() int {
+	if b.grow != nil {
+		return b.grow.max
+	}
 	return len(b.mem)
 }
 
+func (b *ringbuf) tryLock() bool {
+	if b.locker != nil {
+		return b.locker.tryLock()
+	}
+	return atomic.LoadInt32(&b.lck) == 0 && atomic.CompareAndSwapInt32(&b.lck, 0, 1)
+}
+
 func (b *ringbuf) unlock() {
+	if b.locker != nil {
+		b.locker.unlock()
+		return
+	}
 	atomic.StoreInt32(&b.lck, 0)
 	if atomic.LoadInt32(&b.lq) > 0 {
 		notify(b.lsig)
@@ -192,52 +510,48 @@ func (b *ringbuf) unlock() {
 }
 
 func (b *ringbuf) lock() error {
-	// fast path
-	lck := atomic.LoadInt32(&b.lck)
-	if (lck == 0) && atomic.CompareAndSwapInt32(&b.lck, 0, 1) {
-		return nil
-	}
-	// slow path
-	atomic.AddInt32(&b.lq, 1)
-	for {
-		// first spin some
-		for i := 0; i < 100; i++ {
-			lck = atomic.LoadInt32(&b.lck)
-			if (lck == 0) && atomic.CompareAndSwapInt32(&b.lck, 0, 1) {
-				atomic.AddInt32(&b.lq, -1)
-				return nil
-			}
-			runtime.Gosched()
-		}
-		// then wait notification
-		<-b.lsig
-		if b.IsClosed() {
-			atomic.AddInt32(&b.lq, -1)
-			notify(b.lsig) // resume other waiters (if any)
-			return io.EOF
-		}
-	}
+	return b.lockWithContext(context.Background())
 }
 
 func (b *ringbuf) lockWithContext(ctx context.Context) error {
+	if b.locker != nil {
+		return b.locker.lockWithContext(ctx)
+	}
 	// fast path
 	lck := atomic.LoadInt32(&b.lck)
 	if (lck == 0) && atomic.CompareAndSwapInt32(&b.lck, 0, 1) {
 		return nil
 	}
 	// slow path
+	policy := b.SpinPolicy()
+	spins := policy.Spins
+	if b.adapt != nil {
+		spins = int(atomic.LoadInt32(&b.adapt.lock))
+	}
+	var since time.Time // when it parked, for the adaptive policy
 	atomic.AddInt32(&b.lq, 1)
 	for {
 		// first spin some
-		for i := 0; i < 100; i++ {
+		acquired := false
+		for i := 0; i < spins && !acquired; i++ {
 			lck = atomic.LoadInt32(&b.lck)
-			if (lck == 0) && atomic.CompareAndSwapInt32(&b.lck, 0, 1) {
-				atomic.AddInt32(&b.lq, -1)
-				return nil
+			if acquired = (lck == 0) && atomic.CompareAndSwapInt32(&b.lck, 0, 1); !acquired {
+				policy.pause(&b.lck, i)
 			}
-			runtime.Gosched()
+		}
+		// without spinning the unlock may have missed the waiter counted above
+		if acquired || (spins == 0 && atomic.CompareAndSwapInt32(&b.lck, 0, 1)) {
+			atomic.AddInt32(&b.lq, -1)
+			if b.adapt != nil {
+				b.adapt.learn(&b.adapt.lock, since, policy.Spins)
+			}
+			return nil
+		}
+		if b.adapt != nil && since.IsZero() {
+			since = time.Now()
 		}
 		// then wait notification
+		schedWait(b.wakeLock)
 		select {
 		case <-b.lsig:
 		case <-ctx.Done():
@@ -253,37 +567,23 @@ func (b *ringbuf) lockWithContext(ctx context.Context) error {
 }
 
 func (b *ringbuf) getDeadline() time.Time {
-	if b.deadline == 0 {
-		return time.Time{}
-	} else {
-		return time.Now().Add(b.deadline - md.Monotime())
-	}
+	return b.deadline.get()
 }
 
 func (b *ringbuf) setDeadline(deadline time.Time) {
-	if deadline.IsZero() {
-		b.deadline = 0
-		b.timeoutC = nil
-	} else {
-		timeout := deadline.Sub(time.Now())
-		b.deadline = md.Monotime() + timeout
-		b.timeoutC = time.After(timeout)
-	}
+	b.deadline.set(deadline)
+	b.waiting.wakeCond() // they wait on the channels from now on
 }
 
-func (b *ringbuf) timeoutChan() (<-chan time.Time, bool) {
-	if b.deadline == 0 {
-		return nil, false
-	}
-	timeout := b.deadline - md.Monotime()
-	if timeout <= 0 {
-		return nil, true
-	}
-	return b.timeoutC, false
+// timeoutChan returns the channel to wait on, or true if the deadline is exceeded.
+// The channel is closed when the deadline expires or is changed, the caller
+// calls timeoutChan again after wakeup
+func (b *ringbuf) timeoutChan() (<-chan struct{}, bool) {
+	return b.deadline.wait()
 }
 
 func (b *ringbuf) checkDeadline() error {
-	if b.deadline > 0 && b.deadline <= md.Monotime() {
+	if b.deadline.exceeded() {
 		return timeoutError
 	}
 	return nil
@@ -4,9 +4,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
-	"runtime"
 	"sync"
-	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,6 +23,8 @@ func (b *ringbuf) write(data []byte) (int, error) {
 		}
 	}
 	written := 0
+	var parked parking
+	defer parked.leave()
 	for written < toWrite {
 		_, closed, head, sz := b.loadHeader()
 		if closed {
@@ -32,7 +32,7 @@ func (b *ringbuf) write(data []byte) (int, error) {
 		}
 		nw := minInt(b.Cap()-sz, toWrite-written)
 		if nw > 0 {
-			writePos := (head + sz) & b.mask
+			writePos := b.wrap(head + sz)
 			if writePos > b.Cap()-nw {
 				// wrapped
 				ll := b.Cap() - writePos
@@ -41,13 +41,15 @@ func (b *ringbuf) write(data []byte) (int, error) {
 			} else {
 				copy(b.mem[writePos:writePos+nw], data[written:written+nw])
 			}
-			atomic.AddUint64(b.pbits, uint64(nw))
+			b.publish(nw)
 			written += nw
-			notify(b.wsig)
 		} else {
 			if closed {
 				return written, io.EOF
 			}
+			if !b.park(&parked, &b.waiting.space) {
+				continue
+			}
 			<-b.rsig
 		}
 	}
@@ -64,8 +66,10 @@ func (b *ringbuf) _read(data []byte) (int, error) {
 		}
 	}
 	readed := 0
+	var parked parking
+	defer parked.leave()
 	for readed < toRead {
-		hs, closed, head, sz := b.loadHeader()
+		_, closed, head, sz := b.loadHeader()
 		nr := minInt(sz, toRead-readed)
 		if nr > 0 {
 			if head > b.Cap()-nr {
@@ -76,24 +80,18 @@ func (b *ringbuf) _read(data []byte) (int, error) {
 			} else {
 				copy(data[readed:readed+nr], b.mem[head:head+nr])
 			}
-			for {
-				head = (head + nr) & b.mask
-				sz -= nr
-				if atomic.CompareAndSwapUint64(b.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = b.loadHeader()
-			}
+			b.advance(nr)
 			readed += nr
 			if closed {
 				return readed, io.EOF
 			}
-			notify(b.rsig)
 		} else {
 			if closed {
 				return readed, io.EOF
 			}
+			if !b.park(&parked, &b.waiting.data) {
+				continue
+			}
 			<-b.wsig
 		}
 	}
@@ -116,8 +114,10 @@ func (r *ringbuf) read(data []byte) (int, error) {
 		}
 	}
 	readed := 0
+	var parked parking
+	defer parked.leave()
 	for readed < toRead {
-		hs, closed, head, sz := r.loadHeader()
+		_, closed, head, sz := r.loadHeader()
 		nr := minInt(sz, toRead-readed)
 		if nr > 0 {
 			if head > r.Cap()-nr {
@@ -128,15 +128,7 @@ func (r *ringbuf) read(data []byte) (int, error) {
 			} else {
 				copy(data[readed:readed+nr], r.mem[head:head+nr])
 			}
-			for {
-				head = (head + nr) & r.mask
-				sz -= nr
-				if atomic.CompareAndSwapUint64(r.pbits, hs, (hs&headerFlagMask)|(uint64(head)<<32)|uint64(sz)) {
-					break
-				}
-				runtime.Gosched()
-				hs, closed, head, sz = r.loadHeader()
-			}
+			r.advance(nr)
 			readed += nr
 			if closed {
 				if r.synchronized {
@@ -144,7 +136,6 @@ func (r *ringbuf) read(data []byte) (int, error) {
 				}
 				return readed, io.EOF
 			}
-			notify(r.rsig)
 		} else {
 			if closed {
 				if r.synchronized {
@@ -152,6 +143,9 @@ func (r *ringbuf) read(data []byte) (int, error) {
 				}
 				return readed, io.EOF
 			}
+			if !r.park(&parked, &r.waiting.data) {
+				continue
+			}
 			<-r.wsig
 		}
 	}
@@ -0,0 +1,27 @@
+package pipe
+
+// SchedPoint is the point where the pipe lets the test scheduler decide the
+// interleaving of the goroutines using it
+type SchedPoint int
+
+const (
+	// SchedNotify is before waking the goroutines waiting on the other end
+	SchedNotify SchedPoint = iota + 1
+	// SchedWait is before waiting for the other end or the lock
+	SchedWait
+	// SchedSpin is the retry of the contended update, instead of runtime.Gosched
+	SchedSpin
+)
+
+// Scheduler hands off the execution at the sched points, e.g. to the
+// goroutine chosen by the seeded sequence, so the interleaving of
+// concurrency tests can be replayed. It is installed by SetScheduler of the
+// package built with the pipesched tag, without the tag the points cost
+// nothing
+type Scheduler interface {
+	// Yield is called at p. At SchedWait wake ends the wait about to start
+	// as the spurious wakeup, the waiter checks its condition again, so the
+	// scheduler may resume the waiter when it chooses. It is nil at the
+	// other points
+	Yield(p SchedPoint, wake func())
+}
@@ -0,0 +1,14 @@
+//go:build !pipesched
+// +build !pipesched
+
+package pipe
+
+import "runtime"
+
+func schedYield(p SchedPoint) {}
+
+func schedWait(wake func()) {}
+
+func spin() {
+	runtime.Gosched()
+}
@@ -0,0 +1,189 @@
+//go:build pipesched
+// +build pipesched
+
+package pipe
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type schedHolder struct {
+	s Scheduler
+}
+
+var scheduler atomic.Value // schedHolder
+
+// SetScheduler installs s for all pipes, nil s restores the runtime
+// scheduling. It should be called by the test before the pipes are used
+func SetScheduler(s Scheduler) {
+	scheduler.Store(schedHolder{s})
+}
+
+func schedYield(p SchedPoint) {
+	if h, _ := scheduler.Load().(schedHolder); h.s != nil {
+		h.s.Yield(p, nil)
+	}
+}
+
+func schedWait(wake func()) {
+	if h, _ := scheduler.Load().(schedHolder); h.s != nil {
+		h.s.Yield(SchedWait, wake)
+	}
+}
+
+func spin() {
+	if h, _ := scheduler.Load().(schedHolder); h.s != nil {
+		h.s.Yield(SchedSpin, nil)
+		return
+	}
+	runtime.Gosched()
+}
+
+// SeededScheduler runs the goroutines started by Go one at a time. The
+// running one parks at every sched point, and the scheduler releases
+// exactly one of the parked ones, drawn from the sequence of the seed, so
+// the same seed gives the same interleaving and the same Points. The
+// released waiter is woken spuriously and checks again, so the draw never
+// waits for the other end. The goroutines must block only in the pipes,
+// the other goroutines pass the points freely
+type SeededScheduler struct {
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	parts   []*schedPart // in the order of Go
+	byID    map[int64]*schedPart
+	left    int
+	done    chan struct{}
+	started bool
+	points  []SchedPoint
+}
+
+// schedPart is the goroutine run by the scheduler
+type schedPart struct {
+	id   int64
+	turn chan struct{} // the token, handed to the part to run
+	wake func()        // ends the wait the part parked before, if any
+	end  bool
+}
+
+// NewSeededScheduler creates the scheduler drawing from the seed
+func NewSeededScheduler(seed int64) *SeededScheduler {
+	return &SeededScheduler{
+		rnd:  rand.New(rand.NewSource(seed)),
+		byID: make(map[int64]*schedPart),
+		done: make(chan struct{}),
+	}
+}
+
+// Go starts fn as the goroutine run by the scheduler, it runs after Wait
+// is called. Go must not be called after Wait
+func (s *SeededScheduler) Go(fn func()) {
+	p := &schedPart{turn: make(chan struct{}, 1)}
+	ready := make(chan struct{})
+	go func() {
+		p.id = goid()
+		s.mu.Lock()
+		s.byID[p.id] = p
+		s.mu.Unlock()
+		close(ready)
+		<-p.turn
+		defer s.exit(p)
+		fn()
+	}()
+	<-ready
+	s.mu.Lock()
+	s.parts = append(s.parts, p)
+	s.left++
+	s.mu.Unlock()
+}
+
+// Wait releases the goroutines started by Go and returns when all of them
+// returned
+func (s *SeededScheduler) Wait() {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		panic("pipe: SeededScheduler.Wait called twice")
+	}
+	s.started = true
+	if s.left == 0 {
+		close(s.done)
+	} else {
+		s.release()
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *SeededScheduler) Yield(p SchedPoint, wake func()) {
+	id := goid()
+	s.mu.Lock()
+	part := s.byID[id]
+	if part == nil || !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.points = append(s.points, p)
+	part.wake = wake
+	s.release()
+	s.mu.Unlock()
+	<-part.turn
+}
+
+// exit ends p and hands the token to the next one
+func (s *SeededScheduler) exit(p *schedPart) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.end = true
+	delete(s.byID, p.id)
+	s.left--
+	if s.left == 0 {
+		close(s.done)
+		return
+	}
+	s.release()
+}
+
+// release hands the token to the part drawn from the ones not ended, the
+// caller parks on its turn after
+func (s *SeededScheduler) release() {
+	n := s.rnd.Intn(s.left)
+	for _, p := range s.parts {
+		if p.end {
+			continue
+		}
+		if n > 0 {
+			n--
+			continue
+		}
+		if p.wake != nil {
+			p.wake()
+			p.wake = nil
+		}
+		p.turn <- struct{}{}
+		return
+	}
+}
+
+// Points returns the points passed so far in the order of their hand-offs
+func (s *SeededScheduler) Points() []SchedPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SchedPoint(nil), s.points...)
+}
+
+// goid returns the id of the calling goroutine, parsed from the header of
+// its stack
+func goid() int64 {
+	var b [64]byte
+	s := strings.TrimPrefix(string(b[:runtime.Stack(b[:], false)]), "goroutine ")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
@@ -0,0 +1,47 @@
+//go:build pipesched
+// +build pipesched
+
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func schedRun(t *testing.T, seed int64) []SchedPoint {
+	s := NewSeededScheduler(seed)
+	SetScheduler(s)
+	defer SetScheduler(nil)
+	r, w := Pipe(16)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	var b bytes.Buffer
+	var err error
+	s.Go(func() {
+		w.Write(data)
+		w.Close()
+	})
+	s.Go(func() {
+		_, err = io.Copy(&b, r)
+	})
+	s.Wait()
+	require.NoError(t, err)
+	require.Equal(t, data, b.Bytes())
+	return s.Points()
+}
+
+func TestSeededScheduler(t *testing.T) {
+	var runs [][]SchedPoint
+	for seed := int64(1); seed <= 5; seed++ {
+		points := schedRun(t, seed)
+		require.Contains(t, points, SchedNotify)
+		require.Contains(t, points, SchedWait)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, points, schedRun(t, seed), "seed %d", seed)
+		}
+		runs = append(runs, points)
+	}
+	require.NotEqual(t, runs[0], runs[1])
+}
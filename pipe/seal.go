@@ -0,0 +1,147 @@
+package pipe
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrAuth = errors.New("Message authentication failed")
+
+// nonceCounter is the nonce of the next frame: the random base of the
+// stream xored with the frame number in its last 8 bytes, as in TLS 1.3, so
+// the streams sealed with the same key don't repeat the nonces
+type nonceCounter struct {
+	base  []byte // nil until the base is chosen or received
+	nonce []byte
+	n     uint64
+}
+
+func newNonceCounter(aead cipher.AEAD) nonceCounter {
+	if aead.NonceSize() < 8 {
+		panic("AEAD nonce is too short")
+	}
+	return nonceCounter{nonce: make([]byte, aead.NonceSize())}
+}
+
+func (c *nonceCounter) next() []byte {
+	copy(c.nonce, c.base)
+	tail := c.nonce[len(c.nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^c.n)
+	c.n++
+	return c.nonce
+}
+
+// SealWriter encrypts and authenticates each write as one frame. The frames
+// are numbered by the nonce, so the reader detects dropped, reordered and
+// replayed frames without the nonce being sent. The first frame of the
+// stream carries the random nonce base
+type SealWriter struct {
+	w     io.Writer
+	fw    *FramedWriter
+	aead  cipher.AEAD
+	nonce nonceCounter
+	buf   []byte
+}
+
+// Seal creates the writer sealing the data written to w with aead, e.g.
+// AES-GCM of crypto/cipher or ChaCha20-Poly1305 of golang.org/x/crypto. The
+// key may seal other streams, e.g. both directions, each of them chooses
+// its nonce base at random
+func Seal(w io.Writer, aead cipher.AEAD) *SealWriter {
+	return &SealWriter{w: w, fw: NewFramedWriter(w, 0), aead: aead, nonce: newNonceCounter(aead)}
+}
+
+// Write seals data and writes it as one frame. It must not be called
+// concurrently
+func (s *SealWriter) Write(data []byte) (int, error) {
+	if s.nonce.base == nil {
+		base := make([]byte, len(s.nonce.nonce))
+		if _, err := rand.Read(base); err != nil {
+			return 0, err
+		}
+		if err := s.fw.WriteFrame(base); err != nil {
+			return 0, err
+		}
+		s.nonce.base = base
+	}
+	s.buf = s.aead.Seal(s.buf[:0], s.nonce.next(), data, nil)
+	if err := s.fw.WriteFrame(s.buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetBatch batches the frames as FramedWriter.SetBatch
+func (s *SealWriter) SetBatch(size int, interval time.Duration) error {
+	return s.fw.SetBatch(size, interval)
+}
+
+// Flush writes the batched frames
+func (s *SealWriter) Flush() error {
+	return s.fw.Flush()
+}
+
+// Close writes the batched frames and closes the underlying writer if it's
+// io.Closer, even if the write fails. The first error is returned
+func (s *SealWriter) Close() error {
+	return s.fw.Close()
+}
+
+// OpenReader reads the data written by SealWriter
+type OpenReader struct {
+	fr    *FramedReader
+	aead  cipher.AEAD
+	nonce nonceCounter
+	buf   []byte
+	rest  []byte // unread part of the opened frame
+	err   error  // sticky authentication failure
+}
+
+// Open creates the reader opening the frames read from r with aead. The
+// frame of more than max bytes of data fails with *FrameSizeError before
+// it is read, so the peer can't make the reader allocate more. It panics
+// if max isn't positive. The stream cut at the frame boundary ends with
+// io.EOF, the protocol on top of it detects the truncation
+func Open(r io.Reader, aead cipher.AEAD, max int) *OpenReader {
+	if max <= 0 {
+		panic("frame size limit is not positive")
+	}
+	return &OpenReader{fr: NewFramedReader(r, max+aead.Overhead()), aead: aead, nonce: newNonceCounter(aead)}
+}
+
+// Read reads the opened data. It fails with ErrAuth if the frame was
+// modified, dropped or replayed, the stream can't be read further then
+func (o *OpenReader) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	for len(o.rest) == 0 {
+		if o.err != nil {
+			return 0, o.err
+		}
+		frame, err := o.fr.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		if o.nonce.base == nil {
+			if len(frame) != len(o.nonce.nonce) {
+				o.err = ErrAuth
+				return 0, o.err
+			}
+			o.nonce.base = append([]byte(nil), frame...)
+			continue
+		}
+		if o.buf, err = o.aead.Open(o.buf[:0], o.nonce.next(), frame, nil); err != nil {
+			o.err = ErrAuth
+			return 0, o.err
+		}
+		o.rest = o.buf
+	}
+	n := copy(data, o.rest)
+	o.rest = o.rest[n:]
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeal(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 16))
+	require.NoError(t, err)
+	aead, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	r, w := Pipe(256)
+	data := bytes.Repeat([]byte("secret"), 100)
+	go func() {
+		s := Seal(w, aead)
+		for i := 0; i < 10; i++ {
+			s.Write(data[:i*50])
+		}
+		s.Close()
+	}()
+	res, err := io.ReadAll(Open(r, aead, 1000))
+	require.NoError(t, err)
+	require.Equal(t, 45*50, len(res))
+
+	var buf bytes.Buffer
+	s := Seal(&buf, aead)
+	s.Write([]byte("first"))
+	s.Write([]byte("second"))
+	sealed := buf.Bytes()
+	sealed[len(sealed)-1] ^= 1
+	o := Open(bytes.NewReader(sealed), aead, 100)
+	n, err := o.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	_, err = o.Read(make([]byte, 10))
+	require.Equal(t, ErrAuth, err)
+
+	// the replayed frame fails too
+	buf.Reset()
+	s = Seal(&buf, aead)
+	s.Write([]byte("first"))
+	frame := append([]byte(nil), buf.Bytes()[buf.Len()-4-5-aead.Overhead():]...)
+	buf.Write(frame)
+	o = Open(&buf, aead, 100)
+	_, err = o.Read(make([]byte, 10))
+	require.NoError(t, err)
+	_, err = o.Read(make([]byte, 10))
+	require.Equal(t, ErrAuth, err)
+
+	// the streams of the same key use other nonces
+	var buf2 bytes.Buffer
+	s = Seal(&buf2, aead)
+	s.Write([]byte("first"))
+	require.NotEqual(t, buf.Bytes()[:buf2.Len()], buf2.Bytes())
+
+	// the frame over the limit isn't read
+	buf.Reset()
+	s = Seal(&buf, aead)
+	s.Write(make([]byte, 101))
+	_, err = Open(&buf, aead, 100).Read(make([]byte, 10))
+	require.IsType(t, &FrameSizeError{}, err)
+	require.Panics(t, func() { Open(&buf, aead, 0) })
+}
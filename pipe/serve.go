@@ -0,0 +1,38 @@
+package pipe
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Serve accepts the connections of l and passes each of them to accept in
+// its own goroutine as the Duplex bridged by the pipes of the default size.
+// The Duplex is closed when accept returns. The temporary errors of Accept
+// are retried after a growing delay, others are returned, net.ErrClosed by
+// the close of l
+func Serve(l net.Listener, accept func(duplex *Duplex)) error {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go func() {
+			d := Bridge(c, 0)
+			defer d.Close()
+			accept(d)
+		}()
+	}
+}
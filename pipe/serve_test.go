@@ -0,0 +1,36 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error)
+	go func() {
+		served <- Serve(l, func(d *Duplex) {
+			d.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(d, buf); err == nil {
+				d.Write(bytes.ToUpper(buf))
+			}
+		})
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = c.Write([]byte("hello"))
+	require.NoError(t, err)
+	b, err := io.ReadAll(c) // the Duplex is closed after accept
+	require.NoError(t, err)
+	require.Equal(t, "HELLO", string(b))
+	c.Close()
+	l.Close()
+	require.ErrorIs(t, <-served, net.ErrClosed)
+}
@@ -0,0 +1,93 @@
+package pipe
+
+import "sync"
+
+const defaultRegionSize = 4 << 20
+
+// Slab carves the buffers of the pipes out of a few large regions, e.g. for
+// 100k pipes whose buffers the GC would track one by one otherwise. The
+// buffers are of power of two sizes, the freed ones are reused by the
+// allocations of the same size. The regions are never returned to the
+// runtime. Slab may be used concurrently
+type Slab struct {
+	mu         sync.Mutex
+	regionSize int
+	region     []byte       // the rest of the current region
+	free       [64][][]byte // freed buffers by bit length of their size
+	regions    int
+}
+
+// NewSlab creates the allocator reserving regions of regionSize bytes,
+// non-positive regionSize means 4MB
+func NewSlab(regionSize int) *Slab {
+	if regionSize <= 0 {
+		regionSize = defaultRegionSize
+	}
+	return &Slab{regionSize: regionSize}
+}
+
+// Alloc returns the buffer of at least size bytes rounded up as in Pipe.
+// The buffers larger than the region are allocated separately
+func (s *Slab) Alloc(size int) []byte {
+	size = bufferSize(size)
+	class := bitlen(uint(size))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.free[class]; len(l) > 0 {
+		mem := l[len(l)-1]
+		s.free[class] = l[:len(l)-1]
+		return mem
+	}
+	if size > s.regionSize {
+		return make([]byte, size)
+	}
+	if len(s.region) < size {
+		s.freeRest(s.region)
+		s.region = make([]byte, s.regionSize)
+		s.regions++
+	}
+	mem := s.region[:size:size]
+	s.region = s.region[size:]
+	return mem
+}
+
+// freeRest puts the rest of the region to the free lists of smaller sizes
+func (s *Slab) freeRest(rest []byte) {
+	for len(rest) >= minBufferSize {
+		size := 1 << (bitlen(uint(len(rest))) - 1)
+		s.free[bitlen(uint(size))] = append(s.free[bitlen(uint(size))], rest[:size:size])
+		rest = rest[size:]
+	}
+}
+
+// Free returns the buffer taken by Alloc for reuse. The buffer must not be
+// used after Free
+func (s *Slab) Free(mem []byte) {
+	if len(mem) == 0 || (len(mem)&(len(mem)-1)) != 0 {
+		panic("pipe memory is not allocated by Slab")
+	}
+	class := bitlen(uint(len(mem)))
+	s.mu.Lock()
+	s.free[class] = append(s.free[class], mem[:len(mem):len(mem)])
+	s.mu.Unlock()
+}
+
+// Regions returns number of regions reserved
+func (s *Slab) Regions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.regions
+}
+
+// Pipe creates the pipe with buffer of at least max bytes from the slab by
+// InitWith. The buffer is returned to the slab by Release
+func (s *Slab) Pipe(max int, rsync, wsync bool) (*Reader, *Writer) {
+	return InitWith(s.Alloc(max), rsync, wsync)
+}
+
+// Release frees the buffer of the pipe created by Pipe, the ends of the
+// pipe must not be used after Release
+func (s *Slab) Release(r *Reader) {
+	s.Free(r.mem)
+	r.mem = nil
+}
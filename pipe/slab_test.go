@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlab(t *testing.T) {
+	s := NewSlab(64 << 10)
+	var rs []*Reader
+	for i := 0; i < 8; i++ {
+		r, w := s.Pipe(16<<10, false, false)
+		require.Equal(t, 16<<10, w.Cap())
+		_, err := w.Write([]byte("hello"))
+		require.NoError(t, err)
+		rs = append(rs, r)
+	}
+	require.Equal(t, 2, s.Regions())
+	// the buffers don't overlap
+	for _, r := range rs {
+		buf := make([]byte, 5)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+	}
+	for _, r := range rs {
+		s.Release(r)
+	}
+	for i := 0; i < 8; i++ {
+		s.Alloc(16 << 10)
+	}
+	require.Equal(t, 2, s.Regions())
+
+	// the rest of the region is split for smaller buffers
+	s = NewSlab(48 << 10)
+	require.Len(t, s.Alloc(32<<10), 32<<10)
+	require.Len(t, s.Alloc(32<<10), 32<<10)
+	require.Len(t, s.Alloc(16<<10), 16<<10)
+	require.Equal(t, 2, s.Regions())
+	require.Len(t, s.Alloc(1<<20), 1<<20)
+	require.Equal(t, 2, s.Regions())
+}
@@ -0,0 +1,170 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+)
+
+var ErrBadSnapshot = errors.New("Bad pipe snapshot")
+var ErrNotEmpty = errors.New("Pipe is not empty")
+
+const snapshotMagic = 0x50534e50 // "PSNP"
+const snapshotVersion = 1
+const snapshotHeaderSize = 4 + 1 + 1 + 8 // magic, version, flags, rpos
+
+// Snapshot writes the unread data of the pipe with its read position and
+// close state to w, e.g. to drain the pipe to disk on shutdown. The data is
+// not consumed. The pipe must be quiescent: no operation may run on either
+// end until Snapshot returns
+func (b *ringbuf) Snapshot(w io.Writer) error {
+	flags := atomic.LoadUint64(&b.hdr.flags)
+	wpos := atomic.LoadUint64(&b.hdr.wpos)
+	rpos := atomic.LoadUint64(&b.hdr.rpos)
+	if b.grow != nil {
+		b.loadMem()
+	}
+	n := 0
+	if wpos > rpos && (flags&(readCloseFlag|abortFlag)) == 0 {
+		n = int(wpos - rpos)
+	}
+	var cerr string
+	if (flags & closeFlag) != 0 {
+		if err := b.cerr.Load().(closeError).err; err != nil && err != io.EOF {
+			cerr = err.Error()
+		}
+	}
+	hdr := make([]byte, snapshotHeaderSize+2*binary.MaxVarintLen64, snapshotHeaderSize+2*binary.MaxVarintLen64+len(cerr))
+	binary.BigEndian.PutUint32(hdr, snapshotMagic)
+	hdr[4], hdr[5] = snapshotVersion, byte(flags)
+	binary.BigEndian.PutUint64(hdr[6:], rpos)
+	l := snapshotHeaderSize + binary.PutUvarint(hdr[snapshotHeaderSize:], uint64(n))
+	l += binary.PutUvarint(hdr[l:], uint64(len(cerr)))
+	hdr = append(hdr[:l], cerr...)
+
+	crc := crc32.New(castagnoli)
+	mw := io.MultiWriter(w, crc)
+	if _, err := mw.Write(hdr); err != nil {
+		return err
+	}
+	head := b.offset(rpos)
+	if head+n > len(b.mem) {
+		// wrapped
+		if _, err := mw.Write(b.mem[head:]); err != nil {
+			return err
+		}
+		if _, err := mw.Write(b.mem[:head+n-len(b.mem)]); err != nil {
+			return err
+		}
+	} else if _, err := mw.Write(b.mem[head : head+n]); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// Restore refills the empty pipe from the snapshot read from r: the data,
+// the read position and the close state. It fails with ErrOvercap if the
+// data doesn't fit into the buffer and with ErrBadSnapshot if the snapshot
+// is corrupted, the pipe is left empty then. The pipe must be quiescent as
+// for Snapshot
+func (b *ringbuf) Restore(r io.Reader) error {
+	if b.IsClosed() {
+		return b.writeErr()
+	}
+	if b.Buffered() != 0 {
+		return ErrNotEmpty
+	}
+	br := &snapshotReader{r: r, crc: crc32.New(castagnoli)}
+	var fixed [snapshotHeaderSize]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return snapshotErr(err)
+	}
+	if binary.BigEndian.Uint32(fixed[:]) != snapshotMagic || fixed[4] != snapshotVersion {
+		return ErrBadSnapshot
+	}
+	flags := uint64(fixed[5])
+	rpos := binary.BigEndian.Uint64(fixed[6:])
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return snapshotErr(err)
+	}
+	if n > uint64(b.Cap()) {
+		return b.overcap(frameSize(n))
+	}
+	ne, err := binary.ReadUvarint(br)
+	if err != nil {
+		return snapshotErr(err)
+	}
+	if ne > 1<<16 {
+		return ErrBadSnapshot
+	}
+	cerr := make([]byte, ne)
+	if _, err = io.ReadFull(br, cerr); err != nil {
+		return snapshotErr(err)
+	}
+	if b.grow != nil {
+		b.loadMem()
+	}
+	head := b.offset(rpos)
+	ll := minInt(int(n), len(b.mem)-head)
+	if _, err = io.ReadFull(br, b.mem[head:head+ll]); err == nil {
+		_, err = io.ReadFull(br, b.mem[:int(n)-ll])
+	}
+	if err != nil {
+		return snapshotErr(err)
+	}
+	var sum [4]byte
+	if _, err = io.ReadFull(r, sum[:]); err != nil {
+		return snapshotErr(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != br.crc.Sum32() {
+		return ErrBadSnapshot
+	}
+	// the reservations of the MPMC pipe restart at the restored positions
+	if b.mpmc != nil {
+		atomic.StoreUint64(&b.mpmc.rres, rpos)
+		atomic.StoreUint64(&b.mpmc.wres, rpos+n)
+	}
+	atomic.StoreUint64(&b.hdr.rpos, rpos)
+	atomic.StoreUint64(&b.hdr.wpos, rpos)
+	b.publish(int(n))
+	if (flags & closeFlag) != 0 {
+		var err error = io.EOF
+		if len(cerr) > 0 {
+			err = errors.New(string(cerr))
+		}
+		b.closeWithError(err)
+	}
+	return nil
+}
+
+// snapshotReader sums the snapshot as it is read, without reading past it
+type snapshotReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	b   [1]byte
+}
+
+func (s *snapshotReader) Read(data []byte) (int, error) {
+	n, err := s.r.Read(data)
+	s.crc.Write(data[:n])
+	return n, err
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(s, s.b[:])
+	return s.b[0], err
+}
+
+func snapshotErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBadSnapshot
+	}
+	return err
+}
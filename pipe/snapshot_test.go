@@ -0,0 +1,53 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	r, w := Pipe(16)
+	w.WriteString("0123456789")
+	r.Read(make([]byte, 8))
+	w.WriteString("abcdefghij") // wraps
+	w.CloseWithError(errors.New("shutdown"))
+	var b bytes.Buffer
+	require.NoError(t, r.Snapshot(&b))
+	snap := b.Bytes()
+	require.Equal(t, 12, r.Buffered()) // not consumed
+
+	r2, w2 := Pipe(16)
+	require.NoError(t, w2.Restore(bytes.NewReader(snap)))
+	data, err := io.ReadAll(r2)
+	require.Equal(t, "89abcdefghij", string(data))
+	require.EqualError(t, err, "shutdown")
+
+	r3, w3 := Pipe(16)
+	bad := append([]byte(nil), snap...)
+	bad[len(bad)-6] ^= 1
+	require.Equal(t, ErrBadSnapshot, w3.Restore(bytes.NewReader(bad)))
+	require.Equal(t, ErrBadSnapshot, w3.Restore(bytes.NewReader(snap[:10])))
+	require.Equal(t, 0, r3.Buffered())
+	_, w4 := Pipe(8)
+	require.Equal(t, ErrOvercap, w4.Restore(bytes.NewReader(snap)))
+	w3.WriteString("x")
+	require.Equal(t, ErrNotEmpty, w3.Restore(bytes.NewReader(snap)))
+
+	// the MPMC pipe reserves from the restored positions
+	r, w = Pipe(16)
+	w.WriteString("0123456789")
+	r.Read(make([]byte, 8))
+	b.Reset()
+	require.NoError(t, r.Snapshot(&b))
+	r5, w5 := MPMCPipe(16)
+	require.NoError(t, w5.Restore(&b))
+	_, err = w5.Write([]byte("kl"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, _ := r5.Read(buf)
+	require.Equal(t, "89kl", string(buf[:n]))
+}
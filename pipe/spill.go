@@ -0,0 +1,159 @@
+package pipe
+
+import (
+	"os"
+	"sync"
+)
+
+// SpillWriter is the write end of the pipe that spills the data which
+// doesn't fit into the ring to the temporary file instead of waiting. The
+// spilled data is moved back into the ring as the reader drains it, in order
+type SpillWriter struct {
+	w *Writer
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signaled when data is spilled or the writer is closed
+	f      *os.File
+	roff   int64 // offset of the spilled data not moved yet
+	woff   int64 // offset of the end of the spilled data
+	closed bool
+	err    error // the close error, moved to the ring after the spilled data
+	ferr   error // sticky error of the spill file
+	done   chan struct{}
+}
+
+// SpillPipe creates the pipe with the ring of max bytes whose writer spills
+// to the temporary file in dir, see os.CreateTemp. The writer may be called
+// concurrently, the file is removed when the spilled data is drained after
+// Close
+func SpillPipe(max int, dir string) (*Reader, *SpillWriter, error) {
+	f, err := os.CreateTemp(dir, "pipe-spill-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	r, w := pipe(max, false, false)
+	s := &SpillWriter{w: w, f: f, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	go s.move()
+	return r, s, nil
+}
+
+// Write writes data into the ring, or into the file if it doesn't fit or
+// the data spilled before is still there. It never waits for the reader
+func (s *SpillWriter) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.w.IsClosed() {
+		return 0, s.w.writeErr()
+	}
+	if s.ferr != nil {
+		return 0, s.ferr
+	}
+	n := 0
+	if s.roff == s.woff {
+		// nothing is spilled, the mover doesn't touch the ring
+		n = s.w.writeNoWait(data)
+		if n == len(data) {
+			return n, nil
+		}
+	}
+	nf, err := s.f.WriteAt(data[n:], s.woff)
+	s.woff += int64(nf)
+	s.cond.Signal()
+	if err != nil {
+		s.ferr = err
+	}
+	return n + nf, err
+}
+
+// Spilled returns number of bytes in the file not moved into the ring yet
+func (s *SpillWriter) Spilled() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.woff - s.roff
+}
+
+// Close closes the pipe once the spilled data is moved into the ring
+func (s *SpillWriter) Close() error {
+	return s.CloseWithError(nil)
+}
+
+// CloseWithError closes the pipe with err once the spilled data is moved
+// into the ring. Only the error of the first close is kept, nil err means
+// io.EOF
+func (s *SpillWriter) CloseWithError(err error) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		s.err = err
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Wait waits until the spilled data is moved into the ring or dropped after
+// close, and the file is removed
+func (s *SpillWriter) Wait() {
+	<-s.done
+}
+
+// move moves the spilled data into the ring, the reader's pace
+func (s *SpillWriter) move() {
+	defer close(s.done)
+	defer func() {
+		s.f.Close()
+		os.Remove(s.f.Name())
+	}()
+	buf := make([]byte, s.w.Cap())
+	s.mu.Lock()
+	for {
+		for s.roff == s.woff && !s.closed {
+			s.cond.Wait()
+		}
+		if s.roff == s.woff {
+			// closed and drained
+			err := s.err
+			s.mu.Unlock()
+			s.w.CloseWithError(err)
+			return
+		}
+		off := s.roff
+		n := int(minInt64(s.woff-off, int64(len(buf))))
+		s.mu.Unlock()
+
+		n, err := s.f.ReadAt(buf[:n], off)
+		if err == nil {
+			_, err = s.w.Write(buf[:n])
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			// the reader is closed or the file failed, drop the rest
+			if s.ferr == nil {
+				s.ferr = err
+			}
+			s.roff = s.woff
+			s.mu.Unlock()
+			if err != ErrReadClosed {
+				s.w.CloseWithError(err)
+			}
+			return
+		}
+		s.roff += int64(n)
+		if s.roff == s.woff {
+			// reuse the file from the start
+			s.roff, s.woff = 0, 0
+			if err = s.f.Truncate(0); err != nil {
+				s.ferr = err
+			}
+		}
+	}
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,44 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillPipe(t *testing.T) {
+	r, w, err := SpillPipe(16, t.TempDir())
+	require.NoError(t, err)
+	var data []byte
+	for i := 0; i < 100; i++ {
+		chunk := []byte(fmt.Sprintf("chunk %d;", i))
+		n, err := w.Write(chunk)
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+		data = append(data, chunk...)
+	}
+	require.True(t, w.Spilled() > 0)
+	w.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, string(data), string(got))
+	w.Wait()
+	require.Equal(t, int64(0), w.Spilled())
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, io.EOF, err)
+
+	// the reader closed with the spilled data
+	dir := t.TempDir()
+	r, w, err = SpillPipe(16, dir)
+	require.NoError(t, err)
+	w.Write(make([]byte, 100))
+	r.CloseRead()
+	w.Wait()
+	_, err = w.Write([]byte("x"))
+	require.Equal(t, ErrReadClosed, err)
+	files, _ := os.ReadDir(dir)
+	require.Len(t, files, 0)
+}
@@ -0,0 +1,98 @@
+package pipe
+
+import "sync/atomic"
+
+// Pause is what the contended lock does between its attempts
+type Pause int
+
+const (
+	// PauseYield yields the processor by runtime.Gosched
+	PauseYield Pause = iota
+	// PauseBusy busy-waits polling the lock word with atomic loads, without
+	// entering the scheduler. It is the plain load loop, Go has no portable
+	// CPU pause instruction
+	PauseBusy
+)
+
+// busyUnit is the number of the lock word loads in the unit of PauseBusy
+const busyUnit = 30
+
+// maxBackoff is the limit of the pause in units, as the power of two
+const maxBackoff = 6
+
+// SpinPolicy is how the lock of the synchronized end is contended before
+// the goroutine parks waiting for the unlock
+type SpinPolicy struct {
+	Spins   int   // attempts before parking, zero parks at once
+	Pause   Pause // the pause between the attempts
+	Backoff bool  // the pause doubles after each attempt, up to 64 units
+
+	// Adaptive makes Spins the limit of the attempts learned from the
+	// hand-offs: the end spins longer while they complete in microseconds
+	// and parks at once while the waits are long. The read and write waits
+	// of the adaptive end spin too before parking
+	Adaptive bool
+}
+
+// DefaultSpin is the policy of the new pipe: 100 attempts yielding once
+var DefaultSpin = SpinPolicy{Spins: 100, Pause: PauseYield}
+
+// SetSpinPolicy sets how the lock of the synchronized end spins. Few spins
+// save the processors of the small machine, many spins avoid the parking
+// under the contention of many cores. The clones of the writer share its
+// policy. Negative Spins is zero. It must not be called concurrently with
+// the use of the end
+func (b *ringbuf) SetSpinPolicy(p SpinPolicy) {
+	if p.Spins < 0 {
+		p.Spins = 0
+	}
+	b.adapt = nil
+	if p == DefaultSpin {
+		b.spinPolicy = nil
+		return
+	}
+	b.spinPolicy = &p
+	if p.Adaptive {
+		b.adapt = &adaptive{lock: int32(p.Spins), wait: int32(p.Spins)}
+	}
+}
+
+// SpinPolicy returns the policy of the lock of the end
+func (b *ringbuf) SpinPolicy() SpinPolicy {
+	if b.spinPolicy == nil {
+		return DefaultSpin
+	}
+	return *b.spinPolicy
+}
+
+// busyWord is loaded by the busy pause of the wait, which has no lock word
+var busyWord int32
+
+// busyPause busy-waits for n units of PauseBusy
+func busyPause(n int) {
+	for n *= busyUnit; n > 0; n-- {
+		atomic.LoadInt32(&busyWord)
+	}
+}
+
+// pause waits after the failed attempt i to acquire the lock, nil lck is
+// the attempt of the wait
+func (p *SpinPolicy) pause(lck *int32, i int) {
+	n := 1
+	if p.Backoff {
+		n <<= uint(minInt(i, maxBackoff))
+	}
+	if p.Pause == PauseYield {
+		for ; n > 0; n-- {
+			spin()
+		}
+		return
+	}
+	schedYield(SchedSpin)
+	if lck == nil {
+		busyPause(n)
+		return
+	}
+	for n *= busyUnit; n > 0 && atomic.LoadInt32(lck) != 0; n-- {
+	}
+}
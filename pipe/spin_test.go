@@ -0,0 +1,44 @@
+package pipe
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpinPolicy(t *testing.T) {
+	r, w := SyncPipe(64)
+	require.Equal(t, DefaultSpin, w.SpinPolicy())
+	for _, p := range []SpinPolicy{
+		{Spins: 0},
+		{Spins: 10, Pause: PauseBusy, Backoff: true},
+		{Spins: 1000, Pause: PauseYield, Backoff: true},
+		DefaultSpin,
+	} {
+		r.SetSpinPolicy(p)
+		w.SetSpinPolicy(p)
+		require.Equal(t, p, w.SpinPolicy())
+		const writers, bytes = 8, 1000
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < bytes; j++ {
+					w.WriteByte(1)
+				}
+			}()
+		}
+		buf := make([]byte, writers*bytes)
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, len(buf), n)
+		wg.Wait()
+	}
+	r, w = New(Spin(SpinPolicy{Spins: 5, Pause: PauseBusy}))
+	require.Equal(t, 5, r.SpinPolicy().Spins)
+	require.Equal(t, PauseBusy, w.SpinPolicy().Pause)
+	w.SetSpinPolicy(SpinPolicy{Spins: -1})
+	require.Equal(t, 0, w.SpinPolicy().Spins)
+}
@@ -0,0 +1,45 @@
+package pipe
+
+import "io"
+
+// Splice moves n bytes from src to dst, or all of them up to the close of
+// src if n is negative. The data is copied straight from the read view of
+// src into the write region of dst, without the intermediate buffer of
+// io.Copy. It returns io.EOF if src is closed before n bytes are moved. The
+// views of both ends are held while moving, so the synchronized ends stay
+// locked until Splice returns
+func Splice(dst *PipeWriter, src *PipeReader, n int64) (int64, error) {
+	var moved int64
+	for n < 0 || moved < n {
+		view, err := src.AcquireRead()
+		if err != nil {
+			if err == io.EOF && n < 0 {
+				err = nil
+			}
+			return moved, err
+		}
+		if n >= 0 && int64(len(view)) > n-moved {
+			view = view[:n-moved]
+		}
+		consumed := 0
+		for consumed < len(view) && err == nil {
+			// wait for any free space instead of the whole view
+			want := minInt(len(view)-consumed, dst.maxCap())
+			if free := dst.Free(); free > 0 && free < want {
+				want = free
+			}
+			var region []byte
+			if region, err = dst.AcquireWrite(want); err == nil {
+				k := copy(region, view[consumed:])
+				dst.CommitWrite(k)
+				consumed += k
+			}
+		}
+		src.ReleaseRead(consumed)
+		moved += int64(consumed)
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,37 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplice(t *testing.T) {
+	sr, sw := Pipe(64)
+	dr, dw := Pipe(16)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	go func() {
+		sw.Write(data)
+		sw.Close()
+	}()
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(dr)
+		done <- b
+	}()
+	n, err := Splice(dw, sr, 100)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), n)
+	n, err = Splice(dw, sr, -1)
+	require.NoError(t, err)
+	require.Equal(t, int64(900), n)
+	n, err = Splice(dw, sr, 1)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, int64(0), n)
+	dw.Close()
+	require.Equal(t, data, <-done)
+}
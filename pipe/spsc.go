@@ -0,0 +1,73 @@
+package pipe
+
+import (
+	"sync/atomic"
+)
+
+// spscCursor caches the counter of the other end of the single-producer
+// single-consumer pipe. The end loads the counter of the other one, and so
+// pulls its cache line, only when the buffer looks empty to the reader or
+// full to the writer
+type spscCursor struct {
+	reader bool
+	peer   uint64
+	closed uint32 // the pipe is closed through this end
+}
+
+// SPSCPipe creates the pipe for exactly one writing and one reading
+// goroutine. Its ends are never locked and mostly work with cached counters
+func SPSCPipe(max int) (*Reader, *Writer) {
+	r, w := pipe(max, false, false)
+	r.spsc = &spscCursor{reader: true}
+	w.spsc = &spscCursor{}
+	return r, w
+}
+
+// loadCached is loadHeader of the SPSC end. The end reads its own counter,
+// which only it writes, without the atomic load. While the cached counter
+// of the other end shows data to the reader or space to the writer and the
+// pipe isn't closed through this end, it loads nothing shared: the end
+// notices the close through the other end, e.g. CloseRead or Abort, once
+// it runs out of the data or space it has seen
+func (b *ringbuf) loadCached() (flags uint64, closed bool, readPos int, readAvail int) {
+	var rpos, wpos uint64
+	if b.spsc.reader {
+		rpos = b.hdr.rpos
+		if b.spsc.peer > rpos && atomic.LoadUint32(&b.spsc.closed) == 0 {
+			return 0, false, b.offset(rpos), int(b.spsc.peer - rpos)
+		}
+		// looks empty
+		flags = atomic.LoadUint64(&b.hdr.flags)
+		b.spsc.peer = atomic.LoadUint64(&b.hdr.wpos)
+		wpos = b.spsc.peer
+	} else {
+		wpos = b.hdr.wpos + b.unpublished()
+		if wpos-b.spsc.peer < uint64(len(b.mem)) && atomic.LoadUint32(&b.spsc.closed) == 0 {
+			return 0, false, b.offset(b.spsc.peer), int(wpos - b.spsc.peer)
+		}
+		// looks full
+		flags = atomic.LoadUint64(&b.hdr.flags)
+		b.spsc.peer = atomic.LoadUint64(&b.hdr.rpos)
+		rpos = b.spsc.peer
+	}
+	closed = (flags & closeFlag) != 0
+	readPos = b.offset(rpos)
+	if (flags&(readCloseFlag|abortFlag)) == 0 && wpos > rpos {
+		readAvail = int(wpos - rpos)
+	}
+	return
+}
+
+// refresh reloads the cached counter, it returns true if the counter changed
+func (b *ringbuf) refresh() bool {
+	if b.spsc == nil {
+		return false
+	}
+	peer := b.spsc.peer
+	if b.spsc.reader {
+		b.spsc.peer = atomic.LoadUint64(&b.hdr.wpos)
+	} else {
+		b.spsc.peer = atomic.LoadUint64(&b.hdr.rpos)
+	}
+	return b.spsc.peer != peer
+}
@@ -0,0 +1,125 @@
+package pipe
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	. "github.com/pi/goal/pipe/_testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPSCPipe(t *testing.T) {
+	r, w := SPSCPipe(64)
+	for round := 0; round < 2; round++ {
+		const total = 1 << 18
+		go func() {
+			var b [100]byte
+			c := 0
+			for c < total {
+				n := minInt(rand.Intn(len(b))+1, total-c)
+				for j := 0; j < n; j++ {
+					b[j] = byte(c + j)
+				}
+				switch c % 3 {
+				case 0:
+					w.Write(b[:n])
+				case 1:
+					w.WriteBuffers(net.Buffers{b[:n/2], b[n/2 : n]})
+				default:
+					for j := 0; j < n; j++ {
+						w.WriteByte(b[j])
+					}
+				}
+				c += n
+			}
+			w.Close()
+		}()
+		c := 0
+		var b [50]byte
+		for i := 0; ; i++ {
+			var n int
+			var err error
+			if i%2 == 0 {
+				n, err = r.Read(b[:rand.Intn(len(b))+1])
+			} else {
+				b[0], err = r.ReadByte()
+				if err == nil {
+					n = 1
+				}
+			}
+			for j := 0; j < n; j++ {
+				if b[j] != byte(c) {
+					t.Fatalf("wrong byte at %d", c)
+				}
+				c++
+			}
+			if err != nil {
+				require.Equal(t, io.EOF, err)
+				break
+			}
+		}
+		require.Equal(t, total, c)
+		require.NoError(t, r.Reset())
+	}
+
+	// the end notices its own close while the cached counter shows data
+	r, w = SPSCPipe(64)
+	w.Write([]byte("ab"))
+	w.Close()
+	_, err := w.Write([]byte("c"))
+	require.Equal(t, io.EOF, err)
+	b, err := r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('a'), b)
+	r.CloseRead()
+	_, err = r.ReadByte()
+	require.Equal(t, ErrReadClosed, err)
+}
+
+func benchmarkMessages(b *testing.B, r io.Reader, w io.WriteCloser) {
+	b.SetBytes(64)
+	go func() {
+		var msg [64]byte
+		for i := 0; i < b.N; i++ {
+			w.Write(msg[:])
+		}
+		w.Close()
+	}()
+	var msg [64]byte
+	for i := 0; i < b.N; i++ {
+		io.ReadFull(r, msg[:])
+	}
+}
+
+func BenchmarkPipeMessages(b *testing.B) {
+	r, w := Pipe(BS)
+	benchmarkMessages(b, r, w)
+}
+
+func BenchmarkSPSCPipeMessages(b *testing.B) {
+	r, w := SPSCPipe(BS)
+	benchmarkMessages(b, r, w)
+}
+
+func BenchmarkIoPipeMessages(b *testing.B) {
+	r, w := io.Pipe()
+	benchmarkMessages(b, r, w)
+}
+
+func BenchmarkChanMessages(b *testing.B) {
+	b.SetBytes(64)
+	c := make(chan [64]byte, BS/64)
+	go func() {
+		var msg [64]byte
+		for i := 0; i < b.N; i++ {
+			c <- msg
+		}
+		close(c)
+	}()
+	for i := 0; i < b.N; i++ {
+		<-c
+	}
+}
@@ -0,0 +1,19 @@
+package pipe
+
+import "io"
+
+// SSHChannel is the part of ssh.Channel of golang.org/x/crypto/ssh the
+// adapter uses, ssh.Channel satisfies it as is
+type SSHChannel interface {
+	io.ReadWriteCloser
+	// CloseWrite sends EOF to the peer
+	CloseWrite() error
+}
+
+// SSHPipe bridges the SSH channel through the pipes of bufSize bytes. The
+// close of the write end sends the channel EOF after the written data, the
+// channel EOF of the peer ends the received data with io.EOF. The channel
+// is closed when both directions end or by Close
+func SSHPipe(ch SSHChannel, bufSize int) *Duplex {
+	return Bridge(ch, bufSize)
+}
@@ -0,0 +1,42 @@
+package pipe
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memSSHChannel is the end of the in-memory SSH channel
+type memSSHChannel struct {
+	*memQUICStream
+}
+
+func (c memSSHChannel) CloseWrite() error { return c.w.Close() }
+
+func (c memSSHChannel) Close() error {
+	c.w.Close()
+	return c.Reader.CloseRead()
+}
+
+func TestSSHPipe(t *testing.T) {
+	a, b := memQUICStreams()
+	da := SSHPipe(memSSHChannel{a}, 64)
+	db := SSHPipe(memSSHChannel{b}, 64)
+	go func() {
+		da.Write([]byte("hello"))
+		da.CloseWrite()
+	}()
+	data, err := io.ReadAll(db)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	// the other direction is still open
+	_, err = db.Write([]byte("world"))
+	require.NoError(t, err)
+	db.CloseWrite()
+	data, err = io.ReadAll(da)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(data))
+	require.NoError(t, da.Wait())
+	require.NoError(t, db.Wait())
+}
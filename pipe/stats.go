@@ -0,0 +1,74 @@
+package pipe
+
+import (
+	"context"
+	"time"
+)
+
+// Stats receives the events of the pipe end. The methods are called from the
+// hot paths, maybe concurrently, so they must be fast and must not call the
+// pipe. Waits ended by the cancellation or timeout are not reported
+type Stats interface {
+	// Written is called when n bytes are published to the reader
+	Written(n int)
+	// Read is called when n bytes are consumed
+	Read(n int)
+	// WriteBlocked is called when the writer has waited d for free space
+	WriteBlocked(d time.Duration)
+	// ReadBlocked is called when the reader has waited d for data
+	ReadBlocked(d time.Duration)
+	// Overcap is called when the request for n bytes fails with ErrOvercap
+	Overcap(n int)
+	// Closed is called when the end closes the pipe with err
+	Closed(err error)
+}
+
+// SetStats makes the end report its events to s, nil s stops the reports.
+// Set the same s on both ends to get the numbers of the pipe. It must not be
+// called concurrently with other methods of the end
+func (b *ringbuf) SetStats(s Stats) {
+	b.stats = s
+}
+
+// waitStart passes SchedWait and returns the start of the wait measured for
+// stats and tracing
+func (b *ringbuf) waitStart() (t time.Time) {
+	if b.nagle != nil {
+		// the reader may be what the writer waits for
+		b.nagle.flush()
+	}
+	b.flushBatch()
+	schedWait(b.wakeWaiters)
+	if b.stats != nil || b.tracer != nil {
+		t = time.Now()
+	}
+	return t
+}
+
+// writeBlocked reports the wait for free space started at t
+func (b *ringbuf) writeBlocked(ctx context.Context, t time.Time) {
+	if b.stats != nil {
+		b.stats.WriteBlocked(time.Since(t))
+	}
+	if b.tracer != nil {
+		b.traceStall(ctx, t, true)
+	}
+}
+
+// readBlocked reports the wait for data started at t
+func (b *ringbuf) readBlocked(ctx context.Context, t time.Time) {
+	if b.stats != nil {
+		b.stats.ReadBlocked(time.Since(t))
+	}
+	if b.tracer != nil {
+		b.traceStall(ctx, t, false)
+	}
+}
+
+// overcap reports and returns ErrOvercap for the request of n bytes
+func (b *ringbuf) overcap(n int) error {
+	if b.stats != nil {
+		b.stats.Overcap(n)
+	}
+	return ErrOvercap
+}
@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingStats struct {
+	written, read, writeBlocked, readBlocked, overcap, closed int64
+}
+
+func (s *countingStats) Written(n int) { atomic.AddInt64(&s.written, int64(n)) }
+func (s *countingStats) Read(n int)    { atomic.AddInt64(&s.read, int64(n)) }
+func (s *countingStats) WriteBlocked(d time.Duration) {
+	atomic.AddInt64(&s.writeBlocked, int64(d))
+}
+func (s *countingStats) ReadBlocked(d time.Duration) {
+	atomic.AddInt64(&s.readBlocked, int64(d))
+}
+func (s *countingStats) Overcap(n int)    { atomic.AddInt64(&s.overcap, 1) }
+func (s *countingStats) Closed(err error) { atomic.AddInt64(&s.closed, 1) }
+
+func TestStats(t *testing.T) {
+	var s countingStats
+	r, w := Pipe(16)
+	r.SetStats(&s)
+	w.SetStats(&s)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w.Write(make([]byte, 100))
+		w.Close()
+	}()
+	time.Sleep(40 * time.Millisecond)
+	n, err := io.Copy(io.Discard, r)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), n)
+	require.Equal(t, int64(100), atomic.LoadInt64(&s.written))
+	require.Equal(t, int64(100), atomic.LoadInt64(&s.read))
+	require.True(t, atomic.LoadInt64(&s.writeBlocked) > int64(10*time.Millisecond))
+	_, err = w.AcquireWrite(17)
+	require.Equal(t, ErrOvercap, err)
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.overcap))
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.closed))
+	r.Close()
+	require.Equal(t, int64(1), atomic.LoadInt64(&s.closed))
+}
@@ -0,0 +1,38 @@
+package pipe
+
+import (
+	"context"
+	"time"
+)
+
+// Stall describes the wait of the pipe end
+type Stall struct {
+	Write    bool // the writer waited for free space, otherwise the reader waited for data
+	Start    time.Time
+	Duration time.Duration
+	Buffered int // bytes buffered after the wait
+	Cap      int
+}
+
+// StallTracer receives the waits longer than the threshold with the context
+// of the blocked call, so the stall shows up in the request trace. With
+// OpenTelemetry it starts the span of trace.SpanFromContext(ctx) with
+// timestamp s.Start, sets the attributes and ends it at s.Start+s.Duration
+type StallTracer interface {
+	Stall(ctx context.Context, s Stall)
+}
+
+// SetStallTracer makes the end report its waits longer than threshold to t,
+// nil t stops the reports. The calls without context report
+// context.Background(). It must not be called concurrently with other
+// methods of the end
+func (b *ringbuf) SetStallTracer(t StallTracer, threshold time.Duration) {
+	b.tracer = t
+	b.stallThreshold = threshold
+}
+
+func (b *ringbuf) traceStall(ctx context.Context, start time.Time, write bool) {
+	if d := time.Since(start); d >= b.stallThreshold {
+		b.tracer.Stall(ctx, Stall{Write: write, Start: start, Duration: d, Buffered: b.Buffered(), Cap: b.Cap()})
+	}
+}
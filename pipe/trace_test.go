@@ -0,0 +1,59 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stallKey struct{}
+
+type stallRecorder struct {
+	mu     sync.Mutex
+	stalls []Stall
+	ctxs   []interface{}
+}
+
+func (s *stallRecorder) Stall(ctx context.Context, st Stall) {
+	s.mu.Lock()
+	s.stalls = append(s.stalls, st)
+	s.ctxs = append(s.ctxs, ctx.Value(stallKey{}))
+	s.mu.Unlock()
+}
+
+func TestStallTracer(t *testing.T) {
+	var s stallRecorder
+	r, w := Pipe(16)
+	r.SetStallTracer(&s, 10*time.Millisecond)
+	w.SetStallTracer(&s, time.Hour)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteString("hello")
+	}()
+	ctx := context.WithValue(context.Background(), stallKey{}, "req")
+	buf := make([]byte, 16)
+	n, err := r.ReadContext(ctx, buf[:5])
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	// the short wait is not traced
+	w.WriteString("x")
+	go func() {
+		time.Sleep(time.Millisecond)
+		w.WriteString("y")
+	}()
+	r.Read(buf[:1])
+	r.Read(buf[:1])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.Len(t, s.stalls, 1)
+	st := s.stalls[0]
+	require.False(t, st.Write)
+	require.True(t, st.Duration >= 10*time.Millisecond)
+	require.Equal(t, 5, st.Buffered)
+	require.Equal(t, 16, st.Cap)
+	require.Equal(t, "req", s.ctxs[0])
+}
@@ -0,0 +1,119 @@
+package pipe
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+var ErrNoPeerCred = errors.New("Peer credentials are not supported")
+
+// PeerCred is the credentials of the process on the other end of the unix
+// socket
+type PeerCred struct {
+	Pid int
+	Uid int
+	Gid int
+}
+
+// UnixServer accepts the connections of the unix socket, each of them as
+// the Duplex
+type UnixServer struct {
+	l       *net.UnixListener
+	bufSize int
+	check   func(PeerCred) error
+
+	interval time.Duration // of the keepalive, zero if off
+	missed   int
+}
+
+// ServeUnix listens on the unix socket at path, the conversations are
+// buffered by the pipes of bufSize bytes. The stale socket file left by the
+// dead server is removed
+func ServeUnix(path string, bufSize int) (*UnixServer, error) {
+	addr := &net.UnixAddr{Name: path, Net: "unix"}
+	l, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		if c, derr := net.DialUnix("unix", nil, addr); derr == nil {
+			c.Close()
+			return nil, err
+		}
+		if st, serr := os.Stat(path); serr != nil || st.Mode()&os.ModeSocket == 0 {
+			return nil, err
+		}
+		os.Remove(path)
+		if l, err = net.ListenUnix("unix", addr); err != nil {
+			return nil, err
+		}
+	}
+	return &UnixServer{l: l, bufSize: bufSize}, nil
+}
+
+// CheckPeer sets the check of the credentials of the clients, the clients
+// it fails are disconnected. Accept fails with ErrNoPeerCred if the system
+// doesn't report the credentials
+func (s *UnixServer) CheckPeer(check func(PeerCred) error) {
+	s.check = check
+}
+
+// SetKeepalive wraps the accepted connections by Keepalive, the clients
+// must wrap theirs too, e.g. by DialUnixKeepalive. Non-positive interval
+// turns it off
+func (s *UnixServer) SetKeepalive(interval time.Duration, missed int) {
+	s.interval, s.missed = interval, missed
+}
+
+// Accept waits for the client and returns the conversation with it
+func (s *UnixServer) Accept() (*Duplex, error) {
+	for {
+		c, err := s.l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		if s.check != nil {
+			cred, err := peerCred(c)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			if s.check(cred) != nil {
+				c.Close()
+				continue
+			}
+		}
+		if s.interval > 0 {
+			return Bridge(Keepalive(c, s.interval, s.missed), s.bufSize), nil
+		}
+		return Bridge(c, s.bufSize), nil
+	}
+}
+
+func (s *UnixServer) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+// Close stops accepting the clients and removes the socket file, the
+// accepted conversations stay
+func (s *UnixServer) Close() error {
+	return s.l.Close()
+}
+
+// DialUnix connects to the unix socket at path and returns the conversation
+// buffered by the pipes of bufSize bytes
+func DialUnix(path string, bufSize int) (*Duplex, error) {
+	return DialUnixKeepalive(path, bufSize, 0, 0)
+}
+
+// DialUnixKeepalive is DialUnix that wraps the connection by Keepalive, for
+// the server with SetKeepalive. Non-positive interval turns it off
+func DialUnixKeepalive(path string, bufSize int, interval time.Duration, missed int) (*Duplex, error) {
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		return Bridge(Keepalive(c, interval, missed), bufSize), nil
+	}
+	return Bridge(c, bufSize), nil
+}
@@ -0,0 +1,73 @@
+package pipe
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixBridge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sock")
+	s, err := ServeUnix(path, 64)
+	require.NoError(t, err)
+	defer s.Close()
+	var peer PeerCred
+	if runtime.GOOS == "linux" {
+		s.CheckPeer(func(c PeerCred) error {
+			peer = c
+			return nil
+		})
+	}
+	go func() {
+		d, err := s.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(d, d)
+		d.Close()
+	}()
+	d, err := DialUnix(path, 64)
+	require.NoError(t, err)
+	go func() {
+		d.Write([]byte("hello"))
+		d.CloseWrite()
+	}()
+	b, err := io.ReadAll(d)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	require.NoError(t, d.Wait())
+	if runtime.GOOS == "linux" {
+		require.Equal(t, os.Getpid(), peer.Pid)
+	}
+}
+
+func TestUnixKeepalive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sock")
+	s, err := ServeUnix(path, 64)
+	require.NoError(t, err)
+	defer s.Close()
+	s.SetKeepalive(5*time.Millisecond, 3)
+	go func() {
+		d, err := s.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(d, d)
+		d.Close()
+	}()
+	d, err := DialUnixKeepalive(path, 64, 5*time.Millisecond, 3)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond) // idle past the missed heartbeats
+	go func() {
+		d.Write([]byte("hello"))
+		d.CloseWrite()
+	}()
+	b, err := io.ReadAll(d)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
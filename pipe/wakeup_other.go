@@ -0,0 +1,21 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package pipe
+
+import "time"
+
+// event polls the shared memory
+type event struct{}
+
+func newEvent(path, name string) (event, error) {
+	return event{}, nil
+}
+
+func (event) wait(addr *uint32, val uint32) {
+	time.Sleep(mmapPollInterval)
+}
+
+func (event) wake(addr *uint32) {}
+
+func (event) close() {}
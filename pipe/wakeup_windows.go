@@ -0,0 +1,51 @@
+package pipe
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW = kernel32.NewProc("CreateEventW")
+	procSetEvent     = kernel32.NewProc("SetEvent")
+)
+
+// event is the auto-reset named event, shared by the processes that map the
+// same file. One wake releases one waiter, others recheck after the timeout
+type event struct {
+	h syscall.Handle
+}
+
+func newEvent(path, name string) (event, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+	ename, err := syscall.UTF16PtrFromString(fmt.Sprintf("Local\\goal-pipe-%x-%s", hash.Sum64(), name))
+	if err != nil {
+		return event{}, err
+	}
+	// the existing event is opened too
+	h, _, e := procCreateEventW.Call(0, 0, 0, uintptr(unsafe.Pointer(ename)))
+	if h == 0 {
+		return event{}, e
+	}
+	return event{syscall.Handle(h)}, nil
+}
+
+func (e event) wait(addr *uint32, val uint32) {
+	syscall.WaitForSingleObject(e.h, 10*uint32(mmapPollInterval.Milliseconds()))
+}
+
+func (e event) wake(addr *uint32) {
+	procSetEvent.Call(uintptr(e.h))
+}
+
+func (e event) close() {
+	syscall.CloseHandle(e.h)
+}
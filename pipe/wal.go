@@ -0,0 +1,352 @@
+package pipe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrBadLog = errors.New("Log directory is corrupted")
+
+const defaultSegmentSize = 64 << 20
+
+const logSuffix = ".log"
+const offsetFile = "offset"
+
+// LogPipe is the pipe whose writes are appended to the log of segment files,
+// the ring caches the log for the reader. The reader commits the offset it
+// has read up to, the segments before it are deleted. Reopened LogPipe
+// resumes reading at the committed offset
+type LogPipe struct {
+	dir     string
+	segSize int64
+	r       *Reader
+	cw      *Writer // fills the cache from the log
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signaled on append and close
+	segs    []int64    // start offsets of the segments
+	f       *os.File   // last segment, appended to
+	end     int64      // end offset of the log
+	eof     bool       // CloseWrite is called
+	stopped bool       // Close is called
+	err     error      // sticky write error
+
+	read      int64 // offset the reader has read up to
+	committed int64
+	done      chan struct{}
+}
+
+func segmentName(start int64) string {
+	s := strconv.FormatInt(start, 10)
+	return strings.Repeat("0", 20-len(s)) + s + logSuffix
+}
+
+// OpenLog opens the log in dir, creating the directory if needed. The ring
+// of cacheSize bytes caches the log for the reader, the segments are
+// rolled at segmentSize bytes, non-positive segmentSize means 64MB
+func OpenLog(dir string, cacheSize int, segmentSize int64) (*LogPipe, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &LogPipe{dir: dir, segSize: segmentSize, done: make(chan struct{})}
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, logSuffix) {
+			start, err := strconv.ParseInt(strings.TrimSuffix(name, logSuffix), 10, 64)
+			if err != nil {
+				return nil, ErrBadLog
+			}
+			p.segs = append(p.segs, start)
+		}
+	}
+	sort.Slice(p.segs, func(i, j int) bool { return p.segs[i] < p.segs[j] })
+	if p.committed, err = p.readOffset(); err != nil {
+		return nil, err
+	}
+	if len(p.segs) == 0 {
+		p.segs = append(p.segs, p.committed)
+	}
+	last := p.segs[len(p.segs)-1]
+	if p.f, err = os.OpenFile(filepath.Join(dir, segmentName(last)), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	st, err := p.f.Stat()
+	if err == nil {
+		err = syncDir(dir) // the segment may be created
+	}
+	if err != nil {
+		p.f.Close()
+		return nil, err
+	}
+	p.end = last + st.Size()
+	if p.committed < p.segs[0] || p.committed > p.end {
+		p.f.Close()
+		return nil, ErrBadLog
+	}
+	p.read = p.committed
+	p.cond = sync.NewCond(&p.mu)
+	p.r, p.cw = pipe(cacheSize, false, false)
+	go p.fill(p.committed)
+	return p, nil
+}
+
+// syncDir makes the entries of dir durable, the created and renamed files.
+// Windows persists them with the files and can't sync the directory
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readOffset returns the committed offset, zero for the new log
+func (p *LogPipe) readOffset() (int64, error) {
+	b, err := os.ReadFile(filepath.Join(p.dir, offsetFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 12 || crc32.Checksum(b[:8], castagnoli) != binary.LittleEndian.Uint32(b[8:]) {
+		return 0, ErrBadLog
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// Write appends data to the log. It may be called concurrently, the data
+// becomes durable by Sync
+func (p *LogPipe) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.eof || p.stopped {
+		return 0, io.EOF
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	written := 0
+	for written < len(data) {
+		if size := p.end - p.segs[len(p.segs)-1]; size >= p.segSize {
+			if p.err = p.roll(); p.err != nil {
+				return written, p.err
+			}
+		}
+		n := minInt(len(data)-written, int(p.segSize-(p.end-p.segs[len(p.segs)-1])))
+		n, p.err = p.f.Write(data[written : written+n])
+		written += n
+		p.end += int64(n)
+		p.cond.Broadcast()
+		if p.err != nil {
+			return written, p.err
+		}
+	}
+	return written, nil
+}
+
+// roll syncs the last segment and starts the new one, whose entry is synced
+// in the directory, so Sync of its data makes it durable
+func (p *LogPipe) roll() error {
+	if err := p.f.Sync(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(p.dir, segmentName(p.end)), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err = syncDir(p.dir); err != nil {
+		f.Close()
+		return err
+	}
+	p.f.Close()
+	p.f = f
+	p.segs = append(p.segs, p.end)
+	return nil
+}
+
+// Sync makes the written data durable
+func (p *LogPipe) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.f.Sync()
+}
+
+// fill copies the log from pos into the cache
+func (p *LogPipe) fill(pos int64) {
+	defer close(p.done)
+	var rf *os.File
+	var rstart int64
+	defer func() {
+		if rf != nil {
+			rf.Close()
+		}
+	}()
+	buf := make([]byte, p.cw.Cap())
+	for {
+		p.mu.Lock()
+		for pos == p.end && !p.eof && !p.stopped {
+			p.cond.Wait()
+		}
+		if p.stopped {
+			p.mu.Unlock()
+			return
+		}
+		if pos == p.end {
+			p.mu.Unlock()
+			p.cw.Close()
+			return
+		}
+		// the segment holding pos
+		i := sort.Search(len(p.segs), func(i int) bool { return p.segs[i] > pos }) - 1
+		start, end := p.segs[i], p.end
+		if i+1 < len(p.segs) {
+			end = p.segs[i+1]
+		}
+		p.mu.Unlock()
+
+		var err error
+		if rf == nil || rstart != start {
+			if rf != nil {
+				rf.Close()
+			}
+			rf, err = os.Open(filepath.Join(p.dir, segmentName(start)))
+			rstart = start
+		}
+		n := 0
+		if err == nil {
+			n, err = rf.ReadAt(buf[:minInt64(end-pos, int64(len(buf)))], pos-start)
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+		}
+		if err != nil {
+			p.cw.CloseWithError(err)
+			return
+		}
+		if _, err = p.cw.Write(buf[:n]); err != nil {
+			return
+		}
+		pos += int64(n)
+	}
+}
+
+// Read reads the log after the data read before. It must not be called
+// concurrently
+func (p *LogPipe) Read(data []byte) (int, error) {
+	return p.ReadContext(context.Background(), data)
+}
+
+func (p *LogPipe) ReadContext(ctx context.Context, data []byte) (int, error) {
+	n, err := p.r.ReadContext(ctx, data)
+	p.read += int64(n)
+	return n, err
+}
+
+// Offset returns the offset the reader has read up to
+func (p *LogPipe) Offset() int64 {
+	return p.read
+}
+
+// Commit persists the offset the reader has read up to and deletes the
+// segments before it. It must be called by the reader
+func (p *LogPipe) Commit() error {
+	if p.read == p.committed {
+		return nil
+	}
+	var b [12]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(p.read))
+	binary.LittleEndian.PutUint32(b[8:], crc32.Checksum(b[:8], castagnoli))
+	tmp := filepath.Join(p.dir, offsetFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b[:])
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(p.dir, offsetFile))
+	}
+	if err == nil {
+		// the rename is durable before the segments are deleted, or the
+		// crash may leave the old offset below the first segment
+		err = syncDir(p.dir)
+	}
+	if err != nil {
+		return err
+	}
+	p.committed = p.read
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// the segment is deleted when the next one starts before the offset
+	for len(p.segs) > 1 && p.segs[1] <= p.committed {
+		if err = os.Remove(filepath.Join(p.dir, segmentName(p.segs[0]))); err != nil && !os.IsNotExist(err) {
+			// retried by the next commit, e.g. while open on Windows
+			return nil
+		}
+		p.segs = p.segs[1:]
+	}
+	return nil
+}
+
+// Segments returns number of segment files of the log
+func (p *LogPipe) Segments() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.segs)
+}
+
+// CloseWrite ends the log, the reader gets io.EOF after reading it. The end
+// is not persisted, the reopened log may be appended to
+func (p *LogPipe) CloseWrite() error {
+	p.mu.Lock()
+	p.eof = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	return nil
+}
+
+// Close syncs the log and closes its files. The uncommitted reads are read
+// again after reopen. The pipe must not be used after Close
+func (p *LogPipe) Close() error {
+	p.mu.Lock()
+	p.stopped = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.r.CloseRead() // releases the filler waiting for space
+	<-p.done
+	err := p.f.Sync()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
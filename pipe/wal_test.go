@@ -0,0 +1,71 @@
+package pipe
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogPipe(t *testing.T) {
+	dir := t.TempDir()
+	p, err := OpenLog(dir, 16, 10)
+	require.NoError(t, err)
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	n, err := p.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, 4, p.Segments())
+	require.NoError(t, p.Sync())
+
+	buf := make([]byte, 25)
+	_, err = io.ReadFull(p, buf)
+	require.NoError(t, err)
+	require.Equal(t, string(data[:25]), string(buf))
+	_, err = io.ReadFull(p, buf[:5]) // not committed
+	require.NoError(t, err)
+	require.NoError(t, p.Commit())
+	p.Read(buf[:1])
+	require.Equal(t, int64(31), p.Offset())
+	require.NoError(t, p.Commit())
+	require.Equal(t, 1, p.Segments()) // from 30
+	require.NoError(t, p.Close())
+
+	p, err = OpenLog(dir, 16, 10)
+	require.NoError(t, err)
+	p.Write([]byte("!"))
+	p.CloseWrite()
+	rest, err := io.ReadAll(p)
+	require.NoError(t, err)
+	require.Equal(t, "vwxyz!", string(rest))
+	require.NoError(t, p.Close())
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	require.Len(t, files, 1)
+}
+
+func TestLogPipeReopenWithoutClose(t *testing.T) {
+	// the state left by commit and roll opens without Close, as after crash
+	dir := t.TempDir()
+	p, err := OpenLog(dir, 16, 10)
+	require.NoError(t, err)
+	p.Write([]byte("0123456789abcdefghij"))
+	require.NoError(t, p.Sync())
+	buf := make([]byte, 12)
+	_, err = io.ReadFull(p, buf)
+	require.NoError(t, err)
+	require.NoError(t, p.Commit())
+	require.Equal(t, 1, p.Segments())
+	p.Write([]byte("klm")) // rolls
+	require.NoError(t, p.Sync())
+	require.Equal(t, 2, p.Segments())
+
+	p2, err := OpenLog(dir, 16, 10)
+	require.NoError(t, err)
+	p2.CloseWrite()
+	rest, err := io.ReadAll(p2)
+	require.NoError(t, err)
+	require.Equal(t, "cdefghijklm", string(rest))
+	require.NoError(t, p2.Close())
+	require.NoError(t, p.Close())
+}
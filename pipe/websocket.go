@@ -0,0 +1,141 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+var ErrPingTimeout = errors.New("Keepalive ping timed out")
+
+// The close codes of RFC 6455
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseInternalError = 1011
+)
+
+// maxCloseReason is the length limit of the close reason, the close frame
+// payload is limited to 125 bytes
+const maxCloseReason = 123
+
+// WebSocket is the part of the WebSocket connection the adapter uses, e.g.
+// the few lines wrapping the connection of gorilla/websocket or nhooyr
+type WebSocket interface {
+	// ReadMessage returns the next binary message. The close of the peer
+	// is returned as *WebSocketCloseError
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends data as one binary message
+	WriteMessage(data []byte) error
+	// Ping sends the ping and waits for the pong
+	Ping(ctx context.Context) error
+	// Close sends the close frame and closes the connection
+	Close(code int, reason string) error
+}
+
+// WebSocketCloseError is the close frame received from the peer
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return "websocket closed with " + strconv.Itoa(e.Code) + ": " + e.Reason
+}
+
+// webSocketCloser closes the connection with the normal close code
+type webSocketCloser struct {
+	ws WebSocket
+}
+
+func (c webSocketCloser) Close() error {
+	return c.ws.Close(CloseNormal, "")
+}
+
+// WebSocketPipe bridges the WebSocket through the pipes of bufSize bytes:
+// each frame written by FramedWriter into the Duplex is sent as one binary
+// message, each received message is read by FramedReader from the Duplex.
+// The close of the write end sends the normal close, CloseWithError of the
+// write end sends the internal error close with the error as the reason.
+// The normal and going away closes of the peer end the received frames
+// with io.EOF, other ones with *WebSocketCloseError. Non-zero keepalive
+// pings the peer at its interval, the connection fails with
+// ErrPingTimeout if the pong isn't received within it
+func WebSocketPipe(ws WebSocket, bufSize int, keepalive time.Duration) *Duplex {
+	d, rw, sr := newDuplex(bufSize, webSocketCloser{ws})
+	d.run(func() {
+		fw := NewFramedWriter(rw, 0)
+		for {
+			msg, err := ws.ReadMessage()
+			if err != nil {
+				var ce *WebSocketCloseError
+				if errors.As(err, &ce) && (ce.Code == CloseNormal || ce.Code == CloseGoingAway) {
+					err = nil
+				}
+				d.endRecv(rw, err)
+				return
+			}
+			if err = fw.WriteFrame(msg); err != nil {
+				return // the reader is closed
+			}
+		}
+	}, func() {
+		fr := NewFramedReader(sr, 0)
+		for {
+			frame, err := fr.ReadFrame()
+			if err == io.EOF {
+				ws.Close(CloseNormal, "")
+				return
+			}
+			if err == nil {
+				err = ws.WriteMessage(frame)
+			} else if err != ErrReadClosed {
+				// closed with the error by the writer
+				reason := err.Error()
+				if len(reason) > maxCloseReason {
+					reason = reason[:maxCloseReason]
+				}
+				ws.Close(CloseInternalError, reason)
+			}
+			if err != nil {
+				d.endSend(sr, err)
+				return
+			}
+		}
+	})
+	if keepalive > 0 {
+		go d.keepalive(ws, rw, sr, keepalive)
+	}
+	return d
+}
+
+// keepalive pings the peer until the connection ends, the missing pong
+// fails both directions
+func (d *Duplex) keepalive(ws WebSocket, recv *Writer, send *Reader, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := ws.Ping(ctx)
+		cancel()
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			d.setErr(ErrPingTimeout)
+			recv.CloseWithError(ErrPingTimeout)
+			send.CloseRead()
+			ws.Close(CloseGoingAway, ErrPingTimeout.Error())
+			return
+		}
+	}
+}
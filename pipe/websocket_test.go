@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memWebSocket is the in-memory end of the WebSocket connection
+type memWebSocket struct {
+	in, out chan []byte
+	closed  chan *WebSocketCloseError
+	peer    *memWebSocket
+	once    sync.Once
+	mute    bool // doesn't answer pings
+}
+
+func memWebSockets() (*memWebSocket, *memWebSocket) {
+	a := &memWebSocket{in: make(chan []byte, 16), closed: make(chan *WebSocketCloseError, 1)}
+	b := &memWebSocket{in: make(chan []byte, 16), closed: make(chan *WebSocketCloseError, 1)}
+	a.out, a.peer = b.in, b
+	b.out, b.peer = a.in, a
+	return a, b
+}
+
+func (m *memWebSocket) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-m.in:
+		return msg, nil
+	case ce := <-m.closed:
+		m.closed <- ce
+		return nil, ce
+	}
+}
+
+func (m *memWebSocket) WriteMessage(data []byte) error {
+	m.out <- append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memWebSocket) Ping(ctx context.Context) error {
+	if m.peer.mute {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (m *memWebSocket) Close(code int, reason string) error {
+	m.once.Do(func() {
+		m.peer.closed <- &WebSocketCloseError{Code: code, Reason: reason}
+		m.closed <- &WebSocketCloseError{Code: code, Reason: reason}
+	})
+	return nil
+}
+
+func TestWebSocketPipe(t *testing.T) {
+	a, b := memWebSockets()
+	da := WebSocketPipe(a, 64, 0)
+	fw := NewFramedWriter(da, 0)
+	require.NoError(t, fw.WriteFrame([]byte("hello")))
+	require.NoError(t, fw.WriteFrame([]byte("world")))
+	require.Equal(t, "hello", string(<-b.in))
+	require.Equal(t, "world", string(<-b.in))
+
+	b.WriteMessage([]byte("reply"))
+	fr := NewFramedReader(da, 0)
+	frame, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, "reply", string(frame))
+	da.Writer().CloseWithError(errors.New("boom"))
+	_, err = b.ReadMessage()
+	require.Equal(t, &WebSocketCloseError{Code: CloseInternalError, Reason: "boom"}, err)
+	_, err = fr.ReadFrame() // the close is echoed
+	require.Equal(t, &WebSocketCloseError{Code: CloseInternalError, Reason: "boom"}, err)
+
+	a, b = memWebSockets()
+	da = WebSocketPipe(a, 64, 0)
+	b.Close(CloseNormal, "")
+	_, err = NewFramedReader(da, 0).ReadFrame()
+	require.Equal(t, io.EOF, err)
+
+	// the missing pong fails the connection
+	a, b = memWebSockets()
+	b.mute = true
+	da = WebSocketPipe(a, 64, 10*time.Millisecond)
+	_, err = NewFramedReader(da, 0).ReadFrame()
+	require.Equal(t, ErrPingTimeout, err)
+	require.Equal(t, ErrPingTimeout, da.Wait())
+}
@@ -0,0 +1,194 @@
+package pipe
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNoMark = errors.New("Read position is not marked")
+
+// window is the retained region of the read end between the mark and the
+// read position. The retained bytes are not freed, so they count against
+// the free space of the writer
+type window struct {
+	marked bool
+	ahead  uint64 // bytes read past the mark
+
+	cursors *cursors // nil unless the reader is cloned
+	base    uint64   // the mark or the read position of the cloned reader
+}
+
+// retain counts n bytes read, it returns number of bytes to free. The mark
+// is dropped when the retained region fills the buffer, as the writer could
+// never write again
+func (w *window) retain(n int, size int) int {
+	if !w.marked {
+		return n
+	}
+	w.ahead += uint64(n)
+	if w.ahead < uint64(size) {
+		return 0
+	}
+	n = int(w.ahead)
+	w.marked, w.ahead = false, 0
+	return n
+}
+
+// pos returns the read position of the end, rpos is the freed position
+func (w *window) pos(rpos uint64) uint64 {
+	if w.cursors != nil {
+		return w.base + w.ahead
+	}
+	return rpos + w.ahead
+}
+
+// cursors are the windows of the reader and its clones, the data is freed
+// up to the slowest of them
+type cursors struct {
+	mu      sync.Mutex
+	windows []*window
+	sigs    []chan struct{} // the data signals of the clones
+}
+
+// free moves the window w forward by n bytes and returns number of bytes
+// freed at rpos
+func (c *cursors) free(w *window, n uint64, rpos *uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.base += n
+	return c.release(rpos)
+}
+
+// release moves rpos to the slowest window and returns number of bytes freed
+func (c *cursors) release(rpos *uint64) uint64 {
+	if len(c.windows) == 0 {
+		return 0
+	}
+	min := c.windows[0].base
+	for _, w := range c.windows[1:] {
+		if w.base < min {
+			min = w.base
+		}
+	}
+	old := atomic.LoadUint64(rpos)
+	if min <= old {
+		return 0
+	}
+	atomic.StoreUint64(rpos, min)
+	return min - old
+}
+
+// rebase moves all windows to pos, e.g. on Reset
+func (c *cursors) rebase(pos uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.windows {
+		w.marked, w.ahead, w.base = false, 0, pos
+	}
+}
+
+// Clone returns the independent read end starting at the read position of
+// r, so the new consumer joins the stream in progress, e.g. the debug tap
+// of the live pipe. The data is freed once r and all its clones read it past
+// their marks, the slow clone holds up the writer as the retained data does.
+// Close and CloseRead of the clone drop it without closing the pipe, the
+// clone must not be used after. The settings of the end are not cloned.
+// Clone must not run concurrently with the reads of r. It panics for the
+// pipes that don't support Mark, the growing and the shared pipes
+func (r *Reader) Clone() *Reader {
+	if r.spsc != nil || r.mpmc != nil || r.overflow != nil || r.grow != nil || r.remote != nil {
+		panic("reader is not cloneable")
+	}
+	if r.window == nil {
+		r.window = &window{}
+	}
+	cs := r.window.cursors
+	if cs == nil {
+		cs = &cursors{}
+		r.window.base = atomic.LoadUint64(&r.hdr.rpos)
+		r.window.cursors = cs
+		cs.windows = []*window{r.window}
+	}
+	c := &Reader{clone: true}
+	c.initFrom(&r.ringbuf, r.synchronized)
+	c.wsig = make(chan struct{}, 1)
+	c.window = &window{cursors: cs, base: r.window.pos(0)}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.windows = append(cs.windows, c.window)
+	cs.sigs = append(cs.sigs[:len(cs.sigs):len(cs.sigs)], c.wsig)
+	c.waiting.clones.Store(cs.sigs)
+	return c
+}
+
+// drop removes the clone from the cursors and frees the data it held
+func (r *Reader) drop() {
+	cs := r.window.cursors
+	cs.mu.Lock()
+	for i, w := range cs.windows {
+		if w == r.window {
+			cs.windows = append(cs.windows[:i:i], cs.windows[i+1:]...)
+			break
+		}
+	}
+	for i, c := range cs.sigs {
+		if c == r.wsig {
+			cs.sigs = append(cs.sigs[:i:i], cs.sigs[i+1:]...)
+			break
+		}
+	}
+	r.waiting.clones.Store(cs.sigs)
+	n := cs.release(&r.hdr.rpos)
+	cs.mu.Unlock()
+	if n > 0 {
+		r.notifySpace()
+	}
+}
+
+// Mark starts retaining the data read from the current position, so the
+// reader may return to it by Rewind, e.g. to backtrack the speculative
+// parse. The retained data still takes the buffer space. The previous mark
+// is dropped. The mark is not supported by SPSC, MPMC and overflow pipes
+func (r *Reader) Mark() {
+	if r.spsc != nil || r.mpmc != nil || r.overflow != nil {
+		panic("mark is not supported by the pipe")
+	}
+	if r.window == nil {
+		r.window = &window{}
+	}
+	r.Unmark()
+	r.window.marked = true
+}
+
+// Rewind moves the read position back to the mark and returns number of
+// bytes to read again, the mark stays. It fails with ErrNoMark if there is
+// no mark, or the mark was dropped since the retained data filled the buffer
+func (r *Reader) Rewind() (int, error) {
+	if r.window == nil || !r.window.marked {
+		return 0, ErrNoMark
+	}
+	n := int(r.window.ahead)
+	r.window.ahead = 0
+	return n, nil
+}
+
+// Unmark drops the mark and frees the retained data
+func (r *Reader) Unmark() {
+	if r.window == nil {
+		return
+	}
+	if n := r.window.ahead; n > 0 {
+		r.window.ahead = 0
+		r.free(n)
+	}
+	r.window.marked = false
+}
+
+// Retained returns number of bytes read past the mark
+func (r *Reader) Retained() int {
+	if r.window == nil {
+		return 0
+	}
+	return int(r.window.ahead)
+}
@@ -0,0 +1,107 @@
+package pipe
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewind(t *testing.T) {
+	r, w := Pipe(16)
+	_, err := w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	_, err = r.Rewind()
+	require.Equal(t, ErrNoMark, err)
+	buf := make([]byte, 4)
+	r.Mark()
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(buf))
+	require.Equal(t, 4, r.Retained())
+	require.Equal(t, 6, r.Buffered())
+	require.Equal(t, 6, w.Free()) // the retained data takes the space
+	n, err := r.Rewind()
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(buf))
+	r.Mark()
+	require.Equal(t, 10, w.Free())
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "4567", string(buf))
+	r.Unmark()
+	require.Equal(t, 14, w.Free())
+	_, err = r.Rewind()
+	require.Equal(t, ErrNoMark, err)
+
+	// the mark is dropped when the retained data fills the buffer
+	r.Mark()
+	go func() {
+		w.Write(make([]byte, 30))
+		w.Close()
+	}()
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, b, 32)
+	_, err = r.Rewind()
+	require.Equal(t, ErrNoMark, err)
+}
+
+func TestReaderClone(t *testing.T) {
+	r, w := Pipe(16)
+	w.WriteString("0123")
+	buf := make([]byte, 16)
+	n, _ := r.Read(buf[:2])
+	require.Equal(t, "01", string(buf[:n]))
+	c := r.Clone()
+	w.WriteString("456789")
+	n, _ = r.Read(buf[:8])
+	require.Equal(t, "23456789", string(buf[:n]))
+	require.Equal(t, 8, c.Buffered())
+	require.Equal(t, 8, w.Free()) // the clone holds the data it hasn't read
+	n, _ = c.Read(buf[:4])
+	require.Equal(t, "2345", string(buf[:n]))
+	require.Equal(t, 12, w.Free())
+
+	// the mark of the reader holds the data the clone has read
+	r.Mark()
+	w.WriteString("abcd")
+	n, _ = c.Read(buf[:8])
+	require.Equal(t, "6789abcd", string(buf[:n]))
+	n, _ = r.Read(buf[:4])
+	require.Equal(t, "abcd", string(buf[:n]))
+	require.Equal(t, 12, w.Free())
+	r.Unmark()
+	require.Equal(t, 16, w.Free())
+
+	// the blocked clone is woken by the writes and the close
+	go func() {
+		w.WriteString(strings.Repeat("x", 40))
+		w.Close()
+	}()
+	var rdata []byte
+	done := make(chan struct{})
+	go func() {
+		rdata, _ = io.ReadAll(c)
+		close(done)
+	}()
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	<-done
+	require.Equal(t, strings.Repeat("x", 40), string(res))
+	require.Equal(t, string(res), string(rdata))
+
+	// the dropped clone no longer holds the writer
+	r, w = Pipe(16)
+	c = r.Clone()
+	w.WriteString("0123")
+	r.Read(buf[:4])
+	require.Equal(t, 12, w.Free())
+	require.NoError(t, c.Close())
+	require.Equal(t, 16, w.Free())
+	require.False(t, r.IsClosed())
+}
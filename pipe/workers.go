@@ -0,0 +1,113 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// PanicError is the panic of the worker recovered by WorkerPool
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("worker panic: %v", e.Value)
+}
+
+// WorkerError is the error of the worker of WorkerPool
+type WorkerError struct {
+	Worker int // the index of the worker
+	Err    error
+}
+
+func (e *WorkerError) Error() string {
+	return "worker " + strconv.Itoa(e.Worker) + ": " + e.Err.Error()
+}
+
+func (e *WorkerError) Unwrap() error {
+	return e.Err
+}
+
+// WorkerPool is the pool of the workers consuming the frames of the pipe
+type WorkerPool struct {
+	mu      sync.Mutex // serializes the reads of the frames
+	fr      *FramedReader
+	stopped bool // the read failed, the other workers exit quietly
+	wg      sync.WaitGroup
+	emu     sync.Mutex
+	errs    []*WorkerError
+}
+
+// Workers starts n workers calling fn for the frames written into the pipe
+// by FramedWriter, each frame is passed to one worker. The errors and the
+// panics of fn are reported by Wait, the worker goes on with the next
+// frame. The workers drain the frames written before the close of the pipe
+// and exit, the read error other than io.EOF stops them all
+func Workers(r *PipeReader, n int, fn func(frame []byte) error) *WorkerPool {
+	p := &WorkerPool{fr: NewFramedReader(r, 0)}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.work(i, fn)
+	}
+	return p
+}
+
+func (p *WorkerPool) work(i int, fn func(frame []byte) error) {
+	defer p.wg.Done()
+	for {
+		frame, err := p.next()
+		if err != nil {
+			if err != io.EOF {
+				p.report(i, err)
+			}
+			return
+		}
+		if err = p.call(fn, frame); err != nil {
+			p.report(i, err)
+		}
+	}
+}
+
+// next reads the copy of the next frame, the read error is sticky
+func (p *WorkerPool) next() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil, io.EOF
+	}
+	frame, err := p.fr.ReadFrame()
+	if err != nil {
+		p.stopped = err != io.EOF
+		return nil, err
+	}
+	return append([]byte(nil), frame...), nil
+}
+
+// call calls fn recovering its panic
+func (p *WorkerPool) call(fn func(frame []byte) error, frame []byte) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn(frame)
+}
+
+func (p *WorkerPool) report(i int, err error) {
+	p.emu.Lock()
+	p.errs = append(p.errs, &WorkerError{Worker: i, Err: err})
+	p.emu.Unlock()
+}
+
+// Wait waits until the workers exit and returns their errors in the order
+// they occurred
+func (p *WorkerPool) Wait() []*WorkerError {
+	p.wg.Wait()
+	p.emu.Lock()
+	defer p.emu.Unlock()
+	return p.errs
+}
@@ -0,0 +1,53 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkers(t *testing.T) {
+	r, w := Pipe(256)
+	var sum int64
+	p := Workers(r, 4, func(frame []byte) error {
+		switch string(frame) {
+		case "fail":
+			return errors.New("failed")
+		case "panic":
+			panic("boom")
+		}
+		atomic.AddInt64(&sum, int64(len(frame)))
+		return nil
+	})
+	fw := NewFramedWriter(w, 0)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, fw.WriteFrame([]byte("0123456789")))
+	}
+	fw.WriteFrame([]byte("fail"))
+	fw.WriteFrame([]byte("panic"))
+	w.Close()
+	errs := p.Wait()
+	require.Equal(t, int64(1000), sum) // drained
+	require.Len(t, errs, 2)
+	var msgs []string
+	for _, err := range errs {
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			msgs = append(msgs, fmt.Sprint(pe.Value))
+		} else {
+			msgs = append(msgs, err.Err.Error())
+		}
+	}
+	require.ElementsMatch(t, []string{"failed", "boom"}, msgs)
+
+	// the read error is reported once
+	r, w = Pipe(256)
+	p = Workers(r, 4, func([]byte) error { return nil })
+	w.CloseWithError(errors.New("broken"))
+	errs = p.Wait()
+	require.Len(t, errs, 1)
+	require.EqualError(t, errs[0].Err, "broken")
+}
@@ -0,0 +1,86 @@
+package pipe
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// BehindWriter buffers the writes to the slow writer, see WriteBehind
+type BehindWriter struct {
+	w    *Writer
+	done chan struct{}
+	mu   sync.Mutex
+	err  error // the first error of dst
+}
+
+// WriteBehind returns the writer to dst that buffers up to bufSize bytes
+// and writes them to dst in the background, so the writes wait for dst
+// only when the buffer is full. Once dst fails, the writes fail with its
+// error. The writer is synchronized, so it may be shared by goroutines
+func WriteBehind(dst io.Writer, bufSize int) *BehindWriter {
+	r, w := pipe(bufSize, false, true)
+	b := &BehindWriter{w: w, done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		if _, err := r.WriteTo(dst); err != nil {
+			b.mu.Lock()
+			b.err = err
+			b.mu.Unlock()
+			r.CloseRead()
+		}
+	}()
+	return b
+}
+
+func (b *BehindWriter) Write(data []byte) (int, error) {
+	return b.WriteContext(context.Background(), data)
+}
+
+// WriteContext is Write that can be cancelled by ctx while waiting for the
+// space
+func (b *BehindWriter) WriteContext(ctx context.Context, data []byte) (int, error) {
+	n, err := b.w.WriteContext(ctx, data)
+	if err != nil {
+		err = b.error(err)
+	}
+	return n, err
+}
+
+// Flush waits until the buffered data is written to dst or dst fails
+func (b *BehindWriter) Flush() error {
+	return b.FlushContext(context.Background())
+}
+
+// FlushContext is Flush that can be cancelled by ctx
+func (b *BehindWriter) FlushContext(ctx context.Context) error {
+	if err := b.w.WriteWaitWithContext(ctx, b.w.Cap()); err != nil {
+		return b.error(err)
+	}
+	return nil
+}
+
+// Buffered returns number of bytes not written to dst yet
+func (b *BehindWriter) Buffered() int {
+	return b.w.Buffered()
+}
+
+// Close writes the buffered data to dst and returns the first error of dst.
+// dst is not closed
+func (b *BehindWriter) Close() error {
+	b.w.Close()
+	<-b.done
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// error returns the error of dst in place of err of the pipe closed by it
+func (b *BehindWriter) error(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil && err == ErrReadClosed {
+		return b.err
+	}
+	return err
+}
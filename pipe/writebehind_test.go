@@ -0,0 +1,52 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failWriter fails after limit bytes
+type failWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (f *failWriter) Write(data []byte) (int, error) {
+	if f.Len()+len(data) > f.limit {
+		return 0, errors.New("full")
+	}
+	time.Sleep(time.Millisecond)
+	return f.Buffer.Write(data)
+}
+
+func TestWriteBehind(t *testing.T) {
+	dst := &failWriter{limit: 1000}
+	b := WriteBehind(dst, 64)
+	for i := 0; i < 10; i++ {
+		n, err := b.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		require.Equal(t, 10, n)
+	}
+	require.NoError(t, b.Flush())
+	require.Equal(t, 0, b.Buffered())
+	require.Equal(t, 100, dst.Len())
+	require.NoError(t, b.Close())
+	_, err := b.Write([]byte("x"))
+	require.Equal(t, io.EOF, err)
+
+	// the error of dst is reported by the writes and Close
+	dst = &failWriter{limit: 15}
+	b = WriteBehind(dst, 64)
+	b.Write([]byte("0123456789"))
+	b.Write([]byte("0123456789"))
+	require.EqualError(t, b.Flush(), "full")
+	_, err = b.Write([]byte("x"))
+	require.EqualError(t, err, "full")
+	require.EqualError(t, b.Close(), "full")
+	require.LessOrEqual(t, dst.Len(), 15)
+}
@@ -3,67 +3,40 @@ package pipe
 import (
 	"context"
 	"io"
-	"sync/atomic"
+	"net"
+	"time"
+	"unsafe"
 )
 
+var _ io.StringWriter = (*Writer)(nil)
+
+// stringBytes returns the bytes of s without copying. The result must not be modified
+func stringBytes(s string) []byte {
+	return *(*[]byte)(unsafe.Pointer(&struct {
+		string
+		int
+	}{s, len(s)}))
+}
+
 type Writer struct {
 	ringbuf
+	acquired int    // length of the region returned by AcquireWrite
+	partial  bool   // best-effort writes, see SetPartialWrites
+	refs     *int32 // open handles of the cloned end, nil unless cloned
+	released int32  // the handle is closed
 }
 
-func (w *Writer) writeUnlocked(data []byte) (int, error) {
-	toWrite := len(data)
-	if toWrite == 0 {
-		if w.IsClosed() {
-			return 0, io.EOF
-		} else {
-			return 0, w.checkDeadline()
-		}
+func (w *Writer) writeUnlocked(ctx context.Context, data []byte) (int, error) {
+	if w.mpmc != nil {
+		return w.writeReserved(ctx, data)
 	}
-	timeoutChan, exceed := w.timeoutChan()
-	if exceed {
-		return 0, timeoutError
-	}
-	written := 0
-	for written < toWrite {
-		_, closed, head, sz := w.loadHeader()
-		if closed {
-			notify(w.rsig) // resume other writers (if any)
-			return written, io.EOF
-		}
-		nw := minInt(w.Cap()-sz, toWrite-written)
-		if nw > 0 {
-			writePos := (head + sz) & w.mask
-			if writePos > w.Cap()-nw {
-				// wrapped
-				ll := w.Cap() - writePos
-				copy(w.mem[writePos:], data[written:written+ll])
-				copy(w.mem[:nw-ll], data[written+ll:written+nw])
-			} else {
-				copy(w.mem[writePos:writePos+nw], data[written:written+nw])
-			}
-			atomic.AddUint64(w.pbits, uint64(nw))
-			written += nw
-			notify(w.wsig)
-		} else {
-			if closed {
-				notify(w.rsig) // resume other writers (if any)
-				return written, io.EOF
-			}
-			select {
-			case <-w.rsig:
-			case <-timeoutChan:
-				return written, timeoutError
-			}
-		}
+	if w.overflow != nil {
+		return w.writeOverflow(data)
 	}
-	return toWrite, nil
-}
-
-func (w *Writer) writeUnlockedWithContext(ctx context.Context, data []byte) (int, error) {
 	toWrite := len(data)
 	if toWrite == 0 {
 		if w.IsClosed() {
-			return 0, io.EOF
+			return 0, w.writeErr()
 		} else {
 			return 0, nil
 		}
@@ -73,130 +46,206 @@ func (w *Writer) writeUnlockedWithContext(ctx context.Context, data []byte) (int
 		return 0, timeoutError
 	}
 	written := 0
+	var parked parking
+	defer parked.leave()
+	if w.condWaits(ctx) {
+		parked.cs = &w.waiting.cond.space
+	}
 	for written < toWrite {
 		_, closed, head, sz := w.loadHeader()
 		if closed {
-			return written, io.EOF
+			return written, w.writeErr()
 		}
-		nw := minInt(w.Cap()-sz, toWrite-written)
+		nw, limitChan := w.throttle(minInt(len(w.mem)-sz, toWrite-written))
 		if nw > 0 {
-			writePos := (head + sz) & w.mask
-			if writePos > w.Cap()-nw {
+			writePos := w.wrap(head + sz)
+			if writePos > len(w.mem)-nw {
 				// wrapped
-				ll := w.Cap() - writePos
+				ll := len(w.mem) - writePos
 				copy(w.mem[writePos:], data[written:written+ll])
 				copy(w.mem[:nw-ll], data[written+ll:written+nw])
 			} else {
 				copy(w.mem[writePos:writePos+nw], data[written:written+nw])
 			}
-			atomic.AddUint64(w.pbits, uint64(nw))
+			w.publish(nw)
 			written += nw
-			notify(w.wsig)
+			if w.partial && written < toWrite {
+				return written, io.ErrShortWrite
+			}
 		} else {
 			if closed {
 				notify(w.rsig) // resume other writers (if any)
-				return written, io.EOF
+				return written, w.writeErr()
+			}
+			if limitChan == nil && w.expand(toWrite-written) {
+				continue
+			}
+			if !w.park(&parked, &w.waiting.space) {
+				continue // check again before the first wait
+			}
+			start := w.waitStart()
+			if parked.cs != nil {
+				parked.waitCond(&w.ringbuf)
+				w.writeBlocked(ctx, start)
+				continue
 			}
 			select {
 			case <-w.rsig:
+			case <-limitChan:
 			case <-timeoutChan:
-				return written, timeoutError
+				if timeoutChan, exceed = w.timeoutChan(); exceed {
+					return written, timeoutError
+				}
 			case <-ctx.Done():
 				return written, ctx.Err()
 			}
+			w.writeBlocked(ctx, start)
 		}
 	}
 	return toWrite, nil
 }
 
 func (w *Writer) Write(data []byte) (int, error) {
+	return w.WriteContext(context.Background(), data)
+}
+
+// WriteContext is Write that can be cancelled by ctx while waiting for the lock or free space
+func (w *Writer) WriteContext(ctx context.Context, data []byte) (int, error) {
 	if w.IsClosed() {
-		return 0, io.EOF
+		return 0, w.writeErr()
 	}
-
-	toWrite := len(data)
-	if toWrite == 0 {
+	if len(data) == 0 {
 		return 0, nil
 	}
-
 	if w.synchronized {
-		err := w.lock()
+		err := w.lockWithContext(ctx)
 		if err != nil {
 			return 0, err
 		}
 	}
+	n, err := w.writeUnlocked(ctx, data)
+	if w.synchronized {
+		w.unlock()
+	}
+	return n, err
+}
 
-	timeoutChan, exceed := w.timeoutChan()
-	if exceed {
-		if w.synchronized {
-			w.unlock()
-		}
-		return 0, timeoutError
+// WriteString writes s directly from its backing array, without []byte conversion
+func (w *Writer) WriteString(s string) (int, error) {
+	return w.Write(stringBytes(s))
+}
+
+// TryWrite writes as much of data as fits into the free space. It never
+// blocks and never waits for the lock of a synchronized writer. ok is false
+// if the pipe is closed
+func (w *Writer) TryWrite(data []byte) (n int, ok bool) {
+	if w.synchronized && !w.tryLock() {
+		return 0, !w.IsClosed()
+	}
+	_, closed, _, _ := w.loadHeader()
+	if !closed {
+		n = w.writeNoWait(data)
+	}
+	if w.synchronized {
+		w.unlock()
 	}
+	return n, !closed
+}
 
-	written := 0
-	for written < toWrite {
-		_, closed, head, sz := w.loadHeader()
-		if closed {
+// writeNoWait copies as much of data as fits into the free space
+func (w *Writer) writeNoWait(data []byte) int {
+	_, _, head, sz := w.loadHeader()
+	nw := minInt(len(w.mem)-sz, len(data))
+	if nw > 0 && w.limit != nil {
+		nw, _ = w.limit.take(nw)
+	}
+	if nw <= 0 {
+		return 0
+	}
+	writePos := w.wrap(head + sz)
+	if writePos > len(w.mem)-nw {
+		// wrapped
+		ll := len(w.mem) - writePos
+		copy(w.mem[writePos:], data[:ll])
+		copy(w.mem[:nw-ll], data[ll:nw])
+	} else {
+		copy(w.mem[writePos:writePos+nw], data[:nw])
+	}
+	w.publish(nw)
+	return nw
+}
+
+func (w *Writer) WriteWithContext(ctx context.Context, data []byte) (int, error) {
+	return w.WriteContext(ctx, data)
+}
+
+// WriteTimeout is Write that gives up waiting after d. The timeout fails
+// with the error implementing net.Error with Timeout() == true
+func (w *Writer) WriteTimeout(data []byte, d time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	n, err := w.WriteContext(ctx, data)
+	cancel()
+	if err == context.DeadlineExceeded {
+		err = timeoutError
+	}
+	return n, err
+}
+
+func (w *Writer) WriteAll(chunks ...[]byte) (int64, error) {
+	return w.WriteAllWithContext(context.Background(), chunks...)
+}
+
+func (w *Writer) WriteAllWithContext(ctx context.Context, chunks ...[]byte) (int64, error) {
+	if w.IsClosed() {
+		return 0, w.writeErr()
+	}
+	if w.synchronized {
+		err := w.lockWithContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	var written int64
+	for _, data := range chunks {
+		n, err := w.writeUnlocked(ctx, data)
+		written += int64(n)
+		if err != nil {
 			if w.synchronized {
 				w.unlock()
 			}
-			return written, io.EOF
-		}
-		nw := minInt(w.Cap()-sz, toWrite-written)
-		if nw > 0 {
-			writePos := (head + sz) & w.mask
-			if writePos > w.Cap()-nw {
-				// wrapped
-				ll := w.Cap() - writePos
-				copy(w.mem[writePos:], data[written:written+ll])
-				copy(w.mem[:nw-ll], data[written+ll:written+nw])
-			} else {
-				copy(w.mem[writePos:writePos+nw], data[written:written+nw])
-			}
-			atomic.AddUint64(w.pbits, uint64(nw))
-			written += nw
-			notify(w.wsig)
-		} else {
-			if closed {
-				if w.synchronized {
-					w.unlock()
-				}
-				notify(w.rsig) // resume other writers (if any)
-				return written, io.EOF
-			}
-			select {
-			case <-w.rsig:
-			case <-timeoutChan:
-				if w.synchronized {
-					w.unlock()
-				}
-				return written, timeoutError
-			}
+			return written, err
 		}
 	}
 	if w.synchronized {
 		w.unlock()
 	}
-	return toWrite, nil
+	return written, nil
 }
 
-func (w *Writer) WriteWithContext(ctx context.Context, data []byte) (int, error) {
+// WriteBuffers writes all bufs. When they fit into the buffer, the free space
+// is acquired and published once, so the reader is woken once for all of them.
+// Larger bufs, and all bufs of the throttled, best-effort or overflow policy
+// writer, are written as by WriteAll
+func (w *Writer) WriteBuffers(bufs net.Buffers) (int64, error) {
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	if total > w.maxCap() || w.limit != nil || w.overflow != nil || w.partial {
+		return w.WriteAll(bufs...)
+	}
 	if w.IsClosed() {
-		return 0, io.EOF
+		return 0, w.writeErr()
 	}
-	toWrite := len(data)
-	if toWrite == 0 {
+	if total == 0 {
 		return 0, nil
 	}
-
 	if w.synchronized {
-		err := w.lockWithContext(ctx)
+		err := w.lock()
 		if err != nil {
 			return 0, err
 		}
 	}
-
 	timeoutChan, exceed := w.timeoutChan()
 	if exceed {
 		if w.synchronized {
@@ -204,115 +253,196 @@ func (w *Writer) WriteWithContext(ctx context.Context, data []byte) (int, error)
 		}
 		return 0, timeoutError
 	}
-
-	written := 0
-	for written < toWrite {
+	var parked parking
+	defer parked.leave()
+	for {
 		_, closed, head, sz := w.loadHeader()
 		if closed {
 			if w.synchronized {
 				w.unlock()
 			}
-			return written, io.EOF
+			notify(w.rsig) // resume other writers (if any)
+			return 0, w.writeErr()
 		}
-		nw := minInt(w.Cap()-sz, toWrite-written)
-		if nw > 0 {
-			writePos := (head + sz) & w.mask
-			if writePos > w.Cap()-nw {
-				// wrapped
-				ll := w.Cap() - writePos
-				copy(w.mem[writePos:], data[written:written+ll])
-				copy(w.mem[:nw-ll], data[written+ll:written+nw])
-			} else {
-				copy(w.mem[writePos:writePos+nw], data[written:written+nw])
+		if len(w.mem)-sz >= total {
+			writePos := w.wrap(head + sz)
+			for _, b := range bufs {
+				writePos = w.copyAt(writePos, b)
 			}
-			atomic.AddUint64(w.pbits, uint64(nw))
-			written += nw
-			notify(w.wsig)
-		} else {
-			if closed {
-				if w.synchronized {
-					w.unlock()
-				}
-				notify(w.rsig) // resume other writers (if any)
-				return written, io.EOF
+			w.publish(total)
+			if w.synchronized {
+				w.unlock()
 			}
-			select {
-			case <-w.rsig:
-			case <-ctx.Done():
-				if w.synchronized {
-					w.unlock()
-				}
-				return written, ctx.Err()
-			case <-timeoutChan:
+			return int64(total), nil
+		}
+		if !w.park(&parked, &w.waiting.space) {
+			continue // check again before the first wait
+		}
+		if w.refresh() || w.expand(total) {
+			continue
+		}
+		start := w.waitStart()
+		select {
+		case <-w.rsig:
+		case <-timeoutChan:
+			if timeoutChan, exceed = w.timeoutChan(); exceed {
 				if w.synchronized {
 					w.unlock()
 				}
-				return written, timeoutError
+				return 0, timeoutError
 			}
 		}
+		w.writeBlocked(context.Background(), start)
 	}
-	if w.synchronized {
-		w.unlock()
-	}
-	return toWrite, nil
 }
 
-func (w *Writer) WriteAll(chunks ...[]byte) (int64, error) {
-	//TODO optimize
-	if w.IsClosed() {
-		return 0, io.EOF
+// copyAt copies data into the free space at pos and returns the position after it
+func (w *Writer) copyAt(pos int, data []byte) int {
+	n := len(data)
+	if pos > len(w.mem)-n {
+		// wrapped
+		ll := len(w.mem) - pos
+		copy(w.mem[pos:], data[:ll])
+		copy(w.mem[:n-ll], data[ll:])
+	} else {
+		copy(w.mem[pos:pos+n], data)
+	}
+	return w.wrap(pos + n)
+}
+
+// AcquireWrite waits until n bytes are free and returns the free region at
+// the write position, so the data can be serialized right into the ring
+// memory. The region is shorter than n when free space wraps around the end
+// of memory, the caller then commits it and acquires the rest. Every
+// successful AcquireWrite must be followed by CommitWrite, synchronized
+// writer holds the lock in between
+func (w *Writer) AcquireWrite(n int) ([]byte, error) {
+	if n > w.maxCap() && !w.partial {
+		return nil, w.overcap(n)
+	}
+	if n < 1 {
+		n = 1
 	}
 	if w.synchronized {
 		err := w.lock()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
-	var written int64
-	for _, data := range chunks {
-		n, err := w.writeUnlocked(data)
-		written += int64(n)
-		if err != nil {
-			if w.synchronized {
-				w.unlock()
-			}
-			return written, err
+	w.expand(n)
+	min := n
+	if w.partial {
+		min = 1
+	}
+	err := w.WriteWait(min)
+	if err != nil {
+		if w.synchronized {
+			w.unlock()
 		}
+		return nil, err
+	}
+	_, _, head, sz := w.loadHeader()
+	writePos := w.wrap(head + sz)
+	end := minInt(writePos+minInt(n, len(w.mem)-sz), len(w.mem))
+	w.acquired = end - writePos
+	return w.mem[writePos:end], nil
+}
+
+// CommitWrite publishes first n bytes of the region returned by AcquireWrite
+func (w *Writer) CommitWrite(n int) {
+	if n > w.acquired {
+		panic("commit exceeds acquired region")
+	}
+	w.acquired = 0
+	if n > 0 {
+		w.publish(n)
 	}
 	if w.synchronized {
 		w.unlock()
 	}
-	return written, nil
 }
 
-func (w *Writer) WriteAllWithContext(ctx context.Context, chunks ...[]byte) (int64, error) {
-	if w.IsClosed() {
-		return 0, io.EOF
-	}
+// SetPartialWrites switches the writer between strict and best-effort
+// modes. Strict Write waits until all data is written, AcquireWrite and
+// WriteWait fail with ErrOvercap for more than capacity. Best-effort Write
+// waits for free space, writes as much as fits there and fails with
+// io.ErrShortWrite for the rest. Best-effort AcquireWrite returns the free
+// region of up to n bytes once there is one, WriteWait waits for the whole
+// capacity to be free. It must not be called concurrently with writes
+func (w *Writer) SetPartialWrites(on bool) {
+	w.partial = on
+}
+
+// SetWriteDeadline sets the deadline for blocked and future writes, zero
+// deadline means no deadline. Writes that exceed it fail with the error
+// implementing net.Error with Timeout() == true
+func (w *Writer) SetWriteDeadline(deadline time.Time) error {
+	w.setDeadline(deadline)
+	return nil
+}
+
+// Close closes the write end, see CloseWrite
+func (w *Writer) Close() error {
+	return w.CloseWrite()
+}
+
+// CloseWrite closes the write end. The reader gets io.EOF once the buffered
+// data is drained. The end cloned by Clone is closed by the close of its
+// last handle
+func (w *Writer) CloseWrite() error {
+	if !w.release() {
+		return nil
+	}
+	w.flushBatch()
+	return w.closeWithError(io.EOF)
+}
+
+// CloseWithError closes the pipe. Reads return err after the buffered data
+// is drained. Only the error of the first close is kept, nil err means io.EOF.
+// The error other than io.EOF closes the pipe even if other handles of the
+// cloned end are open
+func (w *Writer) CloseWithError(err error) error {
+	if err == nil || err == io.EOF {
+		return w.CloseWrite()
+	}
+	w.release()
+	w.flushBatch()
+	return w.closeWithError(err)
+}
+
+// CloseAfterDrain closes the pipe once the reader consumes the buffered
+// data, so the reader that stops at the close doesn't miss it, or once ctx
+// is done, returning its error then. The writes of other goroutines of the
+// synchronized writer wait until the close and fail then
+func (w *Writer) CloseAfterDrain(ctx context.Context) error {
 	if w.synchronized {
-		err := w.lockWithContext(ctx)
-		if err != nil {
-			return 0, err
+		if err := w.lockWithContext(ctx); err != nil {
+			w.Close()
+			return err
 		}
+		defer w.unlock()
 	}
-	var written int64
-	for _, data := range chunks {
-		n, err := w.writeUnlockedWithContext(ctx, data)
-		written += int64(n)
-		if err != nil {
-			if w.synchronized {
-				w.unlock()
-			}
-			return written, err
-		}
-	}
-	if w.synchronized {
-		w.unlock()
-	}
-	return written, nil
+	err := w.WriteWaitWithContext(ctx, w.Cap())
+	w.Close()
+	return err
 }
 
 func (w *Writer) WriteByte(b byte) error {
+	// fast path: there is free space and lock is free
+	if !w.deadline.isSet() && w.mpmc == nil && w.limit == nil && (!w.synchronized || w.tryLock()) {
+		_, closed, head, sz := w.loadHeader()
+		ok := !closed && sz < len(w.mem)
+		if ok {
+			w.mem[w.wrap(head+sz)] = b
+			w.publish(1)
+		}
+		if w.synchronized {
+			w.unlock()
+		}
+		if ok {
+			return nil
+		}
+	}
 	var data [1]byte
 	data[0] = b
 	_, err := w.Write(data[:])
@@ -320,36 +450,15 @@ func (w *Writer) WriteByte(b byte) error {
 }
 
 func (w *Writer) WriteWait(min int) error {
-	if min > w.Cap() {
-		return ErrOvercap
-	}
-	if min < 1 {
-		min = 1
-	}
-	timeoutChan, exceed := w.timeoutChan()
-	if exceed {
-		return timeoutError
-	}
-	for {
-		_, closed, _, sz := w.loadHeader()
-		if closed {
-			notify(w.rsig) // resume other writers (if any)
-			return io.EOF
-		}
-		if w.Cap()-sz >= min {
-			return nil
-		}
-		select {
-		case <-w.rsig:
-		case <-timeoutChan:
-			return timeoutError
-		}
-	}
+	return w.WriteWaitWithContext(context.Background(), min)
 }
 
 func (w *Writer) WriteWaitWithContext(ctx context.Context, min int) error {
-	if min > w.Cap() {
-		return ErrOvercap
+	if min > w.maxCap() {
+		if !w.partial {
+			return w.overcap(min)
+		}
+		min = w.maxCap()
 	}
 	if min < 1 {
 		min = 1
@@ -358,22 +467,31 @@ func (w *Writer) WriteWaitWithContext(ctx context.Context, min int) error {
 	if exceed {
 		return timeoutError
 	}
+	var parked parking
+	defer parked.leave()
 	for {
-		_, closed, _, sz := w.loadHeader()
+		closed, sz := w.loadAvail()
 		if closed {
 			notify(w.rsig) // resume other writers (if any)
-			return io.EOF
+			return w.writeErr()
 		}
 		if w.Cap()-sz >= min {
 			return nil
 		}
+		if !w.park(&parked, &w.waiting.space) {
+			continue // check again before the first wait
+		}
+		start := w.waitStart()
 		select {
 		case <-w.rsig:
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-timeoutChan:
-			return timeoutError
+			if timeoutChan, exceed = w.timeoutChan(); exceed {
+				return timeoutError
+			}
 		}
+		w.writeBlocked(ctx, start)
 	}
 }
 
@@ -391,32 +509,43 @@ func (w *Writer) ReadFrom(r io.Reader) (written int64, err error) {
 		}
 		return 0, timeoutError
 	}
+	var parked parking
+	defer parked.leave()
 	for {
 		_, closed, head, sz := w.loadHeader()
 		if closed {
 			if w.synchronized {
 				w.unlock()
 			}
-			return written, io.EOF
+			return written, w.writeErr()
 		}
-		if (w.Cap() - sz) > 0 {
-			writePos := (head + sz) & w.mask
-			var nw int
+		free, limitChan := w.throttle(len(w.mem) - sz)
+		if free > 0 {
+			writePos := w.wrap(head + sz)
+			// free space is mem[writePos:head] when wrapped,
+			// mem[writePos:] + mem[:head] otherwise
+			end := len(w.mem)
 			if writePos < head {
-				// wrapped
-				nw, err = r.Read(w.mem[writePos:head])
-			} else {
-				var n1, n2 int
-				n1, err = r.Read(w.mem[writePos:])
-				if err == nil {
-					n2, err = r.Read(w.mem[:head])
-				}
-				nw = n1 + n2
+				end = head
 			}
+			end = minInt(end, writePos+free)
+			var nw int
+			nw, err = r.Read(w.mem[writePos:end])
 			if nw > 0 {
-				atomic.AddUint64(w.pbits, uint64(nw))
+				w.publish(nw)
 				written += int64(nw)
-				notify(w.wsig)
+			}
+			free -= nw
+			if err == nil && end == len(w.mem) && writePos+nw == end && head > 0 && free > 0 {
+				nw, err = r.Read(w.mem[:minInt(head, free)])
+				if nw > 0 {
+					w.publish(nw)
+					written += int64(nw)
+				}
+				free -= nw
+			}
+			if w.limit != nil {
+				w.limit.put(free)
 			}
 			if err != nil {
 				if err == io.EOF {
@@ -433,16 +562,27 @@ func (w *Writer) ReadFrom(r io.Reader) (written int64, err error) {
 					w.unlock()
 				}
 				notify(w.rsig) // resume other writers (if any)
-				return written, io.EOF
+				return written, w.writeErr()
 			}
+			if limitChan == nil && w.expand(1) {
+				continue
+			}
+			if !w.park(&parked, &w.waiting.space) {
+				continue // check again before the first wait
+			}
+			start := w.waitStart()
 			select {
 			case <-w.rsig:
+			case <-limitChan:
 			case <-timeoutChan:
-				if w.synchronized {
-					w.unlock()
+				if timeoutChan, exceed = w.timeoutChan(); exceed {
+					if w.synchronized {
+						w.unlock()
+					}
+					return written, timeoutError
 				}
-				return written, timeoutError
 			}
+			w.writeBlocked(context.Background(), start)
 		}
 	}
 }
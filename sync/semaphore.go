@@ -0,0 +1,218 @@
+package sync
+
+import (
+	"container/list"
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// maxBackoff is the limit of the yields of one pause, as the power of two
+const maxBackoff = 6
+
+// SpinPolicy is how the contended Sema spins before it waits, as the lock
+// of the synchronized pipe end does with PauseYield: each failed attempt is
+// followed by runtime.Gosched
+type SpinPolicy struct {
+	Spins   int  // attempts before waiting, zero waits at once
+	Backoff bool // the yields double after each attempt, up to 64
+}
+
+// DefaultSpin is the policy of the new semaphore: 100 attempts yielding once
+var DefaultSpin = SpinPolicy{Spins: 100}
+
+// pause yields after the failed attempt i
+func (p *SpinPolicy) pause(i int) {
+	n := 1
+	if p.Backoff {
+		if i > maxBackoff {
+			i = maxBackoff
+		}
+		n <<= uint(i)
+	}
+	for ; n > 0; n-- {
+		runtime.Gosched()
+	}
+}
+
+// Sema is the weighted semaphore. It spins by its SpinPolicy before
+// waiting. The fair semaphore serves the waiters in the order of arrival, so a large
+// acquire is not starved by the small ones, the unfair one lets any waiter
+// that fits go first
+type Sema struct {
+	size    int64
+	cur     int64 // acquired weight
+	waiters int32
+	fair    bool
+	spin    SpinPolicy
+	mu      sync.Mutex
+	wake    chan struct{} // closed on release (unfair)
+	queue   list.List     // of *semaWaiter (fair)
+}
+
+type semaWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// Semaphore returns the semaphore of the total weight size
+func Semaphore(size int64, fair bool) *Sema {
+	return &Sema{size: size, fair: fair, spin: DefaultSpin, wake: make(chan struct{})}
+}
+
+// SetSpinPolicy sets how the acquire spins before it waits. Negative Spins
+// is zero. It must not be called concurrently with Acquire
+func (s *Sema) SetSpinPolicy(p SpinPolicy) {
+	if p.Spins < 0 {
+		p.Spins = 0
+	}
+	s.spin = p
+}
+
+// TryAcquire acquires the weight n without waiting, the fair semaphore
+// fails while anyone waits
+func (s *Sema) TryAcquire(n int64) bool {
+	if s.fair && atomic.LoadInt32(&s.waiters) > 0 {
+		return false
+	}
+	return s.tryAcquire(n)
+}
+
+// Acquire acquires the weight n, waiting until it is released or ctx is
+// done. Nil ctx waits forever. The weight larger than the semaphore waits
+// for ctx only
+func (s *Sema) Acquire(ctx context.Context, n int64) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// fast path
+	if s.TryAcquire(n) {
+		return nil
+	}
+	if n > s.size {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if s.fair {
+		return s.acquireFair(ctx, n)
+	}
+	// slow path
+	atomic.AddInt32(&s.waiters, 1)
+	defer atomic.AddInt32(&s.waiters, -1)
+	for {
+		// first spin some
+		for i := 0; i < s.spin.Spins; i++ {
+			if s.tryAcquire(n) {
+				return nil
+			}
+			s.spin.pause(i)
+		}
+		// then wait notification
+		s.mu.Lock()
+		wake := s.wake
+		s.mu.Unlock()
+		if s.tryAcquire(n) {
+			return nil
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Sema) acquireFair(ctx context.Context, n int64) error {
+	// first spin some
+	for i := 0; i < s.spin.Spins; i++ {
+		if s.TryAcquire(n) {
+			return nil
+		}
+		s.spin.pause(i)
+	}
+	// then queue up
+	s.mu.Lock()
+	atomic.AddInt32(&s.waiters, 1)
+	if s.queue.Len() == 0 && s.tryAcquire(n) {
+		atomic.AddInt32(&s.waiters, -1)
+		s.mu.Unlock()
+		return nil
+	}
+	w := &semaWaiter{n: n, ready: make(chan struct{})}
+	e := s.queue.PushBack(w)
+	s.mu.Unlock()
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ready:
+		// acquired meanwhile
+		return nil
+	default:
+	}
+	front := s.queue.Front() == e
+	s.queue.Remove(e)
+	atomic.AddInt32(&s.waiters, -1)
+	if front {
+		// the waiters behind may fit
+		s.grant()
+	}
+	return ctx.Err()
+}
+
+// Release releases the weight n
+func (s *Sema) Release(n int64) {
+	if atomic.AddInt64(&s.cur, -n) < 0 {
+		panic("releasing more than acquired")
+	}
+	if atomic.LoadInt32(&s.waiters) == 0 {
+		return
+	}
+	s.mu.Lock()
+	if s.fair {
+		s.grant()
+	} else {
+		close(s.wake)
+		s.wake = make(chan struct{})
+	}
+	s.mu.Unlock()
+}
+
+// Acquired returns the weight acquired currently
+func (s *Sema) Acquired() int64 {
+	return atomic.LoadInt64(&s.cur)
+}
+
+// grant acquires for the queued waiters in order while they fit
+func (s *Sema) grant() {
+	for {
+		e := s.queue.Front()
+		if e == nil {
+			return
+		}
+		w := e.Value.(*semaWaiter)
+		if !s.tryAcquire(w.n) {
+			return
+		}
+		s.queue.Remove(e)
+		atomic.AddInt32(&s.waiters, -1)
+		close(w.ready)
+	}
+}
+
+func (s *Sema) tryAcquire(n int64) bool {
+	for {
+		cur := atomic.LoadInt64(&s.cur)
+		if cur+n > s.size {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.cur, cur, cur+n) {
+			return true
+		}
+	}
+}
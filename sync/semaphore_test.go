@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphore(t *testing.T) {
+	for _, fair := range []bool{false, true} {
+		s := Semaphore(10, fair)
+		require.NoError(t, s.Acquire(nil, 7))
+		require.False(t, s.TryAcquire(4))
+		require.True(t, s.TryAcquire(3))
+		require.Equal(t, int64(10), s.Acquired())
+
+		ctx, cf := context.WithTimeout(context.Background(), time.Millisecond)
+		require.Equal(t, context.DeadlineExceeded, s.Acquire(ctx, 1))
+		cf()
+		ctx, cf = context.WithTimeout(context.Background(), time.Millisecond)
+		require.Equal(t, context.DeadlineExceeded, s.Acquire(ctx, 11))
+		cf()
+
+		done := make(chan error)
+		go func() {
+			done <- s.Acquire(context.Background(), 5)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		s.Release(3)
+		select {
+		case <-done:
+			t.Fatal("acquired over the size")
+		case <-time.After(10 * time.Millisecond):
+		}
+		s.Release(4)
+		require.NoError(t, <-done)
+		require.Equal(t, int64(8), s.Acquired())
+		s.Release(8)
+		require.Equal(t, int64(0), s.Acquired())
+		require.Panics(t, func() { s.Release(1) })
+	}
+}
+
+func TestSemaphoreFairness(t *testing.T) {
+	s := Semaphore(10, true)
+	require.NoError(t, s.Acquire(nil, 5))
+	big := make(chan error)
+	go func() {
+		big <- s.Acquire(context.Background(), 10)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// the small acquire fits but waits behind the big one
+	require.False(t, s.TryAcquire(1))
+	small := make(chan error)
+	go func() {
+		small <- s.Acquire(context.Background(), 1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Release(5)
+	require.NoError(t, <-big)
+	select {
+	case <-small:
+		t.Fatal("small acquire overtook")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.Release(10)
+	require.NoError(t, <-small)
+
+	// canceling the head lets the rest go
+	s = Semaphore(10, true)
+	require.NoError(t, s.Acquire(nil, 5))
+	ctx, cf := context.WithCancel(context.Background())
+	go func() {
+		big <- s.Acquire(ctx, 10)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		small <- s.Acquire(context.Background(), 1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cf()
+	require.Equal(t, context.Canceled, <-big)
+	require.NoError(t, <-small)
+}
+
+func TestSemaphoreSpinPolicy(t *testing.T) {
+	for _, p := range []SpinPolicy{{}, {Spins: 10, Backoff: true}, {Spins: -1}} {
+		for _, fair := range []bool{false, true} {
+			s := Semaphore(2, fair)
+			s.SetSpinPolicy(p)
+			require.GreaterOrEqual(t, s.spin.Spins, 0)
+			done := make(chan struct{})
+			for g := 0; g < 4; g++ {
+				go func() {
+					for i := 0; i < 1000; i++ {
+						s.Acquire(nil, 1)
+						s.Release(1)
+					}
+					done <- struct{}{}
+				}()
+			}
+			for g := 0; g < 4; g++ {
+				<-done
+			}
+			require.Equal(t, int64(0), s.Acquired())
+		}
+	}
+}